@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"go-tempest/tempest"
+	"net"
+	"time"
+)
+
+// record writes the datagrams received from hubs on the network to
+// rotating JSON Lines capture files.
+func record(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("record", flag.ExitOnError)
+	ip := flags.String("ip", "0.0.0.0", "IP address of the interface to listen on")
+	dir := flags.String("dir", ".", "directory to write capture files to")
+	prefix := flags.String("prefix", "tempest", "capture file name prefix")
+	maxSize := flags.Int64("max-size", 100*1024*1024, "start a new file after this many bytes, 0 for no limit")
+	maxAge := flags.Duration("max-age", 24*time.Hour, "start a new file after this long, 0 for no limit")
+	compress := flags.Bool("gzip", false, "compress capture files with gzip")
+	flush := flags.Duration("flush", time.Second, "write buffered records to the file this often, 0 after every record")
+	logLevel := flags.String("log-level", "info", "log level: debug, info, warn or error")
+	queueSize := flags.Int("queue-size", tempest.DefaultQueueSize, "messages queued for decoding")
+	overflow := flags.String("overflow", "drop-oldest", "when the queue is full: block, drop-oldest, drop-newest or coalesce")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	logger, err := newLogger(*logLevel)
+	if err != nil {
+		return err
+	}
+
+	queue, err := queueOption(*queueSize, *overflow)
+	if err != nil {
+		return err
+	}
+
+	recorder, err := tempest.NewRecorder(tempest.RecorderConfig{
+		Dir:           *dir,
+		Prefix:        *prefix,
+		MaxSize:       *maxSize,
+		MaxAge:        *maxAge,
+		Gzip:          *compress,
+		FlushInterval: *flush,
+	})
+	if err != nil {
+		return err
+	}
+
+	source, err := tempest.ListenUDP(&net.UDPAddr{IP: net.ParseIP(*ip), Port: tempest.Port})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Recording datagrams on %s to %s\n", source.Addr(), *dir)
+
+	network := tempest.NewNetwork("tempest", tempest.WithLogger(logger), queue)
+	runErr := network.RunSources(ctx, tempest.NewRecordingSource(source, recorder))
+
+	return errors.Join(runErr, recorder.Close())
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"go-tempest/tempest"
+)
+
+// replay logs the messages in capture files, decoded the same way as
+// messages received from hubs on the network.
+func replay(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	speed := flags.Float64("speed", tempest.ReplaySpeedUnlimited, "replay speed as a multiple of the original pace, 0 for as fast as possible")
+	logLevel := flags.String("log-level", "warn", "log level: debug, info, warn or error")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	logger, err := newLogger(*logLevel)
+	if err != nil {
+		return err
+	}
+
+	// The replayed messages are the command's output, so they are logged
+	// whatever the log level.
+	messages, err := newLogger("debug")
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no capture files given")
+	}
+
+	sources := make([]tempest.Source, 0, flags.NArg())
+	for _, name := range flags.Args() {
+		source, err := tempest.OpenReplay(name, *speed)
+		if err != nil {
+			for _, opened := range sources {
+				opened.Close()
+			}
+
+			return err
+		}
+
+		sources = append(sources, source)
+	}
+
+	network := tempest.NewNetwork("replay", tempest.WithLogger(logger))
+
+	// Replaying as fast as possible can outpace the terminal, so the
+	// replay waits for messages to be logged rather than dropping them.
+	subscription := network.SubscribeBlocking(tempest.DefaultSubscriptionBuffer)
+	logged := make(chan struct{})
+	go func() {
+		defer close(logged)
+		logMessages(messages, subscription)
+	}()
+
+	err = network.RunSources(ctx, sources...)
+	subscription.Unsubscribe()
+	<-logged
+
+	return err
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"go-tempest/tempest"
+	"net"
+	"time"
+)
+
+// simulate broadcasts the messages of simulated hubs and sensors so
+// listeners can be tested without Tempest hardware.
+func simulate(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	addr := flags.String("addr", fmt.Sprintf("255.255.255.255:%d", tempest.Port), "UDP address to send messages to")
+	hubs := flags.Int("hubs", 1, "number of simulated hubs")
+	sensors := flags.Int("sensors", 1, "number of simulated sensors paired with each hub")
+	scenario := flags.String("scenario", "fair", "weather scenario: fair or storm")
+	speed := flags.Float64("speed", 1, "simulation speed as a multiple of real time, 0 for as fast as possible")
+	duration := flags.Duration("duration", 0, "simulated time to run for, 0 to run until interrupted")
+	seed := flags.Int64("seed", 0, "seed for the simulated weather, 0 for a random seed")
+	faultRate := flags.Float64("fault-rate", 0, "probability from 0 to 1 of each fault corrupting a message, such as truncation or invalid JSON")
+	clockSkew := flags.Duration("clock-skew", 0, "offset added to message timestamps")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", *addr)
+	if err != nil {
+		return fmt.Errorf("error resolving %s: %w", *addr, err)
+	}
+
+	weather, err := tempest.ScenarioByName(*scenario)
+	if err != nil {
+		return err
+	}
+
+	simulator := tempest.NewSimulator(tempest.SimulatorConfig{
+		Hubs:          *hubs,
+		SensorsPerHub: *sensors,
+		Start:         time.Now(),
+		Duration:      *duration,
+		Speed:         *speed,
+		Seed:          *seed,
+		Scenario:      weather,
+	})
+	defer simulator.Close()
+
+	fmt.Printf("Simulating %d hubs with %d sensors each on %s\n", *hubs, *sensors, udpAddr)
+
+	if *faultRate == 0 && *clockSkew == 0 {
+		return simulator.Broadcast(ctx, udpAddr)
+	}
+
+	injector := tempest.NewFaultInjector(simulator, tempest.FaultConfig{
+		Truncate:    *faultRate,
+		Concatenate: *faultRate,
+		InvalidJSON: *faultRate,
+		WrongType:   *faultRate,
+		NullField:   *faultRate,
+		Duplicate:   *faultRate,
+		Reorder:     *faultRate,
+		ClockSkew:   *clockSkew,
+		Seed:        *seed,
+	})
+
+	err = tempest.SendUDP(ctx, injector, udpAddr)
+
+	fmt.Printf("Injected faults: %+v\n", injector.Stats())
+
+	return err
+}
@@ -1,30 +1,159 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"go-tempest/tempest"
+	"log/slog"
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 )
 
 func main() {
-	network := tempest.NewNetwork("tempest")
-	if err := network.Start(net.IPv4zero); err != nil {
-		panic(err)
+	// The command defaults to listen when no command is given.
+	command := "listen"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
 	}
 
-	defer func() {
-		fmt.Printf("Stopping %s network\n", network.NetworkName)
-		if err := network.Stop(); err != nil {
-			panic(err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	switch command {
+	case "listen":
+		err = listen(ctx, args)
+	case "record":
+		err = record(ctx, args)
+	case "replay":
+		err = replay(ctx, args)
+	case "simulate":
+		err = simulate(ctx, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", command)
+		fmt.Fprintln(os.Stderr, "usage: tempest [listen|record|replay|simulate] [flags]")
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", command, err)
+		os.Exit(1)
+	}
+}
+
+// listen logs the messages received from hubs on the network at debug
+// level.
+func listen(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("listen", flag.ExitOnError)
+	ip := flags.String("ip", "0.0.0.0", "IP address of the interface to listen on")
+	logLevel := flags.String("log-level", "info", "log level: debug, info, warn or error")
+	queueSize := flags.Int("queue-size", tempest.DefaultQueueSize, "messages queued for decoding")
+	overflow := flags.String("overflow", "drop-oldest", "when the queue is full: block, drop-oldest, drop-newest or coalesce")
+	data := flags.String("data", "", "directory to save hubs, sensors and messages to, empty to save nothing")
+	store := flags.String("store", "", "directory to save observations to instead of the data directory, empty to not use a store")
+	retain := flags.Duration("retain", 0, "how long to keep observations and rapid wind before rolling them up into aggregates, zero to keep them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	logger, err := newLogger(*logLevel)
+	if err != nil {
+		return err
+	}
+
+	queue, err := queueOption(*queueSize, *overflow)
+	if err != nil {
+		return err
+	}
+
+	options := []tempest.Option{tempest.WithLogger(logger), queue}
+	retention := tempest.Retention{Raw: *retain}
+	if *data != "" {
+		repo, err := tempest.OpenFileRepo(*data)
+		if err != nil {
+			return err
+		}
+		defer repo.Close()
+
+		options = append(options, tempest.WithHubRepo(repo), tempest.WithSensorRepo(repo), tempest.WithMessageRepo(repo))
+		retention.Aggregates = repo
+	}
+
+	if *store != "" {
+		observations, err := tempest.OpenObservationStore(tempest.StoreConfig{Dir: *store})
+		if err != nil {
+			return err
+		}
+		defer observations.Close()
+
+		options = append(options, tempest.WithMessageRepo(observations))
+	}
+
+	// Aggregates of the store are saved to the data directory.
+	if *retain > 0 {
+		if *data == "" {
+			return fmt.Errorf("-retain requires -data to save aggregates")
+		}
+
+		if err := retention.Validate(); err != nil {
+			return fmt.Errorf("invalid -retain: %w", err)
 		}
-	}()
 
-	sigChannel := make(chan os.Signal, 1)
-	signal.Notify(sigChannel, os.Interrupt, syscall.SIGTERM)
+		options = append(options, tempest.WithRetention(retention))
+	}
+
+	network := tempest.NewNetwork("tempest", options...)
 
-	<-sigChannel
-	fmt.Println("\nShutting down...")
+	subscription := network.Subscribe()
+	defer subscription.Unsubscribe()
+
+	go logMessages(logger, subscription)
+
+	if err := network.Run(ctx, net.ParseIP(*ip)); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nStopped %s network\n", network.NetworkName)
+
+	return nil
+}
+
+// newLogger returns a logger writing records at the level and above
+// to standard error.
+func newLogger(level string) (*slog.Logger, error) {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level: %s", level)
+	}
+
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})), nil
+}
+
+// queueOption returns the option configuring the network's queue of
+// messages waiting to be decoded.
+func queueOption(size int, overflow string) (tempest.Option, error) {
+	policy, err := tempest.ParseOverflowPolicy(overflow)
+	if err != nil {
+		return nil, err
+	}
+
+	return tempest.WithQueue(tempest.QueueConfig{Size: size, Overflow: policy}), nil
+}
+
+// logMessages logs the messages delivered to the subscription at debug
+// level until the subscription is cancelled.
+func logMessages(logger *slog.Logger, subscription *tempest.Subscription) {
+	for msg := range subscription.Messages() {
+		attrs := []any{slog.String("type", string(msg.Type())), slog.Time("reported", msg.Time())}
+		if wind, ok := msg.(*tempest.RapidWindEvent); ok {
+			attrs = append(attrs, slog.Float64("wind_speed", wind.WindSpeed))
+		}
+
+		logger.Debug("message", attrs...)
+	}
 }
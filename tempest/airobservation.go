@@ -0,0 +1,172 @@
+package tempest
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	obsAirIndexTimestampEpochUTC int = iota
+	obsAirIndexStationPressure
+	obsAirIndexAirTemperature
+	obsAirIndexRelativeHumidity
+	obsAirIndexLightningStrikeCount
+	obsAirIndexLightningStrikeAverageDistance
+	obsAirIndexBatteryVolts
+	obsAirIndexReportingInterval
+)
+
+// AirObservation represents an observation from a legacy AIR sensor.
+type AirObservation struct {
+	SensorSerial string
+	HubSerial    string
+	Observations []AirReading
+}
+
+// AirReading is a single reading reported in an obs_air message.
+type AirReading struct {
+	AirMeasurement
+	LightningStrikeCnt int      // lightning strike count
+	LightningStrikeAvg Distance // average lightning strike distance in kilometers
+	BatteryVolts       float64  // sensor battery voltage
+	ReportingInterval  int      // sensor reporting interval minutes
+
+	// Fields the sensor reported as null, read as zero.
+	Missing ObservationField
+}
+
+// Has returns true if the sensor reported every one of the fields.
+func (r *AirReading) Has(fields ObservationField) bool {
+	return r.Missing&fields == 0
+}
+
+// Type returns the message type for the air observation.
+func (a *AirObservation) Type() Type {
+	return MessageTypeAirObservation
+}
+
+// Time returns the time of the latest reading in the message.
+func (a *AirObservation) Time() time.Time {
+	var latest time.Time
+	for _, ob := range a.Observations {
+		if t := time.Unix(ob.EventTime, 0); t.After(latest) {
+			latest = t
+		}
+	}
+
+	return latest
+}
+
+// updateSensor records the sensor's reporting interval.
+func (a *AirObservation) updateSensor(sensor *WeatherSensor) {
+	for _, ob := range a.Observations {
+		if ob.ReportingInterval > 0 {
+			sensor.ReportInterval = time.Duration(ob.ReportingInterval) * time.Minute
+		}
+	}
+}
+
+// Read parses the air observation message.
+func (a *AirObservation) Read(message []byte) error {
+	var rawMessage RawMessage
+	if err := json.Unmarshal(message, &rawMessage); err != nil {
+		return err
+	}
+
+	return a.read(rawMessage)
+}
+
+// read parses the air observation from a raw message.
+func (a *AirObservation) read(rawMessage RawMessage) error {
+	a.Observations = make([]AirReading, 0)
+
+	sensorSerial, err := rawMessage.SensorSerial()
+	if err != nil {
+		return err
+	}
+	a.SensorSerial = sensorSerial
+
+	hubSerial, err := rawMessage.HubSerial()
+	if err != nil {
+		return err
+	}
+	a.HubSerial = hubSerial
+
+	obs, ok := rawMessage["obs"].([]interface{})
+	if !ok {
+		return fmt.Errorf("observations not found")
+	}
+
+	for _, ob := range obs {
+		observation, ok := ob.([]interface{})
+		if !ok || len(observation) <= obsAirIndexReportingInterval {
+			return fmt.Errorf("air observation does not have %d elements", obsAirIndexReportingInterval+1)
+		}
+
+		reading := AirReading{}
+		reading.SensorSerial = sensorSerial
+		reading.HubSerial = hubSerial
+
+		// Epoch seconds UTC the observation was taken. Every other
+		// field may be null when an instrument fails.
+		epochUtc, ok := observation[obsAirIndexTimestampEpochUTC].(float64)
+		if !ok {
+			return fmt.Errorf("unable to read epoch seconds utc")
+		}
+		reading.EventTime = int64(epochUtc)
+
+		// Station pressure.
+		stationPressure, err := observationNumber(observation, obsAirIndexStationPressure, FieldStationPressure, &reading.Missing)
+		if err != nil {
+			return err
+		}
+		reading.Pressure = NewPressure(stationPressure, Millibar)
+
+		// Air temperature.
+		airTemperature, err := observationNumber(observation, obsAirIndexAirTemperature, FieldAirTemperature, &reading.Missing)
+		if err != nil {
+			return err
+		}
+		reading.Temperature = NewTemp(airTemperature, Celsius)
+
+		// Relative humidity.
+		relativeHumidity, err := observationNumber(observation, obsAirIndexRelativeHumidity, FieldRelativeHumidity, &reading.Missing)
+		if err != nil {
+			return err
+		}
+		reading.Humidity = relativeHumidity
+
+		// Lightning strike count.
+		lightningStrikeCnt, err := observationNumber(observation, obsAirIndexLightningStrikeCount, FieldLightningStrikeCnt, &reading.Missing)
+		if err != nil {
+			return err
+		}
+		reading.LightningStrikeCnt = int(lightningStrikeCnt)
+
+		// Lightning strike average distance.
+		lightningStrikeAvg, err := observationNumber(observation, obsAirIndexLightningStrikeAverageDistance, FieldLightningStrikeAvg, &reading.Missing)
+		if err != nil {
+			return err
+		}
+		reading.LightningStrikeAvg = NewDistance(lightningStrikeAvg, Kilometers)
+
+		// Battery volts.
+		batteryVolts, err := observationNumber(observation, obsAirIndexBatteryVolts, FieldBatteryVolts, &reading.Missing)
+		if err != nil {
+			return err
+		}
+		reading.BatteryVolts = batteryVolts
+
+		// Reporting interval.
+		reportingInterval, err := observationNumber(observation, obsAirIndexReportingInterval, FieldReportingInterval, &reading.Missing)
+		if err != nil {
+			return err
+		}
+		reading.ReportingInterval = int(reportingInterval)
+
+		a.Observations = append(a.Observations, reading)
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package tempest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAirObservation_Read(t *testing.T) {
+	rawMessage := `{"serial_number":"AR-00004049","type":"obs_air","hub_sn":"HB-00000001","obs":[[1493164835,835.0,10.0,45,3,12,3.46,1]],"firmware_revision":17}`
+	obs := AirObservation{}
+	if err := obs.Read([]byte(rawMessage)); err != nil {
+		t.Fatalf("error reading air observation: %v", err)
+	}
+
+	if obs.SensorSerial != "AR-00004049" || obs.HubSerial != "HB-00000001" {
+		t.Errorf("unexpected serial numbers: %s %s", obs.SensorSerial, obs.HubSerial)
+	}
+
+	if len(obs.Observations) != 1 {
+		t.Fatalf("unexpected number of observations: %d", len(obs.Observations))
+	}
+
+	ob := obs.Observations[0]
+	if !obs.Time().Equal(time.Unix(1493164835, 0)) {
+		t.Errorf("unexpected time: %v", obs.Time())
+	}
+
+	if ob.Pressure.Millibar() != 835.0 {
+		t.Errorf("unexpected station pressure: %f", ob.Pressure.Millibar())
+	}
+
+	if ob.C() != 10.0 {
+		t.Errorf("unexpected air temperature: %f", ob.C())
+	}
+
+	if ob.Humidity != 45 {
+		t.Errorf("unexpected relative humidity: %f", ob.Humidity)
+	}
+
+	if ob.LightningStrikeCnt != 3 {
+		t.Errorf("unexpected lightning strike count: %d", ob.LightningStrikeCnt)
+	}
+
+	if ob.LightningStrikeAvg.Kilometers() != 12 {
+		t.Errorf("unexpected lightning strike distance: %f", ob.LightningStrikeAvg.Kilometers())
+	}
+
+	if ob.BatteryVolts != 3.46 {
+		t.Errorf("unexpected battery volts: %f", ob.BatteryVolts)
+	}
+
+	if ob.ReportingInterval != 1 {
+		t.Errorf("unexpected reporting interval: %d", ob.ReportingInterval)
+	}
+}
+
+func TestAirObservation_TimeWithoutReadings(t *testing.T) {
+	obs := AirObservation{}
+	if !obs.Time().IsZero() {
+		t.Errorf("expected the zero time, got %v", obs.Time())
+	}
+}
+
+func TestAirObservation_ReadShortObservation(t *testing.T) {
+	rawMessage := `{"serial_number":"AR-00004049","type":"obs_air","hub_sn":"HB-00000001","obs":[[1493164835,835.0]]}`
+	obs := AirObservation{}
+	if err := obs.Read([]byte(rawMessage)); err == nil {
+		t.Errorf("expected an error reading a short observation")
+	}
+}
+
+func TestAirObservation_ReadNullFields(t *testing.T) {
+	// The temperature/humidity instrument and the lightning sensor
+	// have failed and report null.
+	rawMessage := `{"serial_number":"AR-00004049","type":"obs_air","hub_sn":"HB-00000001","obs":[[1493164835,835.0,null,null,null,null,3.46,1]],"firmware_revision":17}`
+	obs := AirObservation{}
+	if err := obs.Read([]byte(rawMessage)); err != nil {
+		t.Fatalf("error reading air observation: %v", err)
+	}
+
+	ob := obs.Observations[0]
+	if ob.Has(FieldAirTemperature) || ob.Has(FieldRelativeHumidity) {
+		t.Errorf("expected temperature and humidity to be missing")
+	}
+
+	if ob.Has(FieldLightningStrikeAvg | FieldLightningStrikeCnt) {
+		t.Errorf("expected lightning fields to be missing")
+	}
+
+	if !ob.Has(FieldStationPressure | FieldBatteryVolts | FieldReportingInterval) {
+		t.Errorf("unexpected missing fields: %s", ob.Missing)
+	}
+
+	if ob.Pressure.Millibar() != 835.0 || ob.C() != 0 {
+		t.Errorf("unexpected readings: %+v", ob)
+	}
+
+	// Values that are neither numbers nor null are errors.
+	rawMessage = `{"serial_number":"AR-00004049","type":"obs_air","hub_sn":"HB-00000001","obs":[[1493164835,835.0,"10",45,3,12,3.46,1]]}`
+	if err := obs.Read([]byte(rawMessage)); err == nil {
+		t.Errorf("expected an error reading a string temperature")
+	}
+}
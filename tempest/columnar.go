@@ -0,0 +1,466 @@
+package tempest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// columnarVersion is the version of the encoding written by
+// EncodeObservations.
+const columnarVersion = 1
+
+// Encodings of a column of field values.
+const (
+	columnXOR  byte = iota // Each value XORed with the previous value.
+	columnRuns             // Runs of equal values.
+)
+
+var errColumnEnd = errors.New("unexpected end of column")
+
+// EncodeObservations returns the observations in a compressed columnar
+// encoding for archiving a sensor's series of observations.
+//
+// Each field is encoded as a column. Times are encoded as the change
+// between successive intervals, which is zero for a sensor reporting
+// regularly. Field values are XORed with the previous value so
+// unchanged and slowly changing readings take a few bits, or encoded
+// as runs of equal values for fields that are mostly zero, like rain
+// and lightning, whichever is smaller.
+//
+// DecodeObservations returns observations equal to the encoded ones
+// when their readings are in the units sensors report them in, as
+// decoded observations are. Readings in other units are converted.
+func EncodeObservations(observations []WeatherObservation) []byte {
+	data := []byte{columnarVersion}
+	data = binary.AppendUvarint(data, uint64(len(observations)))
+	if len(observations) == 0 {
+		return data
+	}
+
+	// Sensors report whole seconds, so times are encoded in seconds
+	// unless an observation has a finer time.
+	times := make([]int64, len(observations))
+	unit := int64(time.Second)
+	for i, ob := range observations {
+		times[i] = ob.EpochSecondsUTC.UnixNano()
+		if times[i]%unit != 0 {
+			unit = 1
+		}
+	}
+
+	for i := range times {
+		times[i] /= unit
+	}
+
+	missing := make([]uint64, len(observations))
+	columns := make([][]uint64, storeFields)
+	for i := range columns {
+		columns[i] = make([]uint64, len(observations))
+	}
+
+	for i, ob := range observations {
+		missing[i] = uint64(ob.Missing)
+		for field, value := range observationValues(ob) {
+			columns[field][i] = math.Float64bits(value)
+		}
+	}
+
+	data = binary.AppendUvarint(data, uint64(unit))
+	data = appendColumn(data, encodeTimes(times))
+	data = appendColumn(data, encodeRuns(missing))
+	for _, column := range columns {
+		xor, runs := encodeXOR(column), encodeRuns(column)
+		if len(runs) < len(xor) {
+			data = appendColumn(append(data, columnRuns), runs)
+		} else {
+			data = appendColumn(append(data, columnXOR), xor)
+		}
+	}
+
+	return data
+}
+
+// DecodeObservations returns the observations encoded by
+// EncodeObservations.
+func DecodeObservations(data []byte) ([]WeatherObservation, error) {
+	r := binaryReader{data: data}
+	if version := r.uint8(); r.err == nil && version != columnarVersion {
+		return nil, fmt.Errorf("unsupported observation encoding version %d", version)
+	}
+
+	count := r.uvarint()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if count == 0 {
+		return make([]WeatherObservation, 0), nil
+	}
+
+	// Every observation takes at least a bit of each column.
+	if count > uint64(len(data))*8 {
+		return nil, errors.New("observation count exceeds the encoded data")
+	}
+	n := int(count)
+
+	unit := int64(r.uvarint())
+	if r.err == nil && unit <= 0 {
+		return nil, errors.New("invalid time unit")
+	}
+
+	times, err := decodeTimes(r.column(), n)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding times: %w", err)
+	}
+
+	missing, err := decodeRuns(r.column(), n)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding missing fields: %w", err)
+	}
+
+	columns := make([][]uint64, storeFields)
+	for field := range columns {
+		kind, column := r.uint8(), r.column()
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		switch kind {
+		case columnXOR:
+			columns[field], err = decodeXOR(column, n)
+		case columnRuns:
+			columns[field], err = decodeRuns(column, n)
+		default:
+			err = fmt.Errorf("unknown column encoding %d", kind)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("error decoding %s: %w", observationFieldNames[field].name, err)
+		}
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	observations := make([]WeatherObservation, n)
+	for i := range observations {
+		var values [storeFields]float64
+		for field := range values {
+			values[field] = math.Float64frombits(columns[field][i])
+		}
+
+		observations[i] = observationFromValues(time.Unix(0, times[i]*unit), values, ObservationField(missing[i]))
+	}
+
+	return observations, nil
+}
+
+// appendColumn appends the column prefixed by its length.
+func appendColumn(data []byte, column []byte) []byte {
+	return append(binary.AppendUvarint(data, uint64(len(column))), column...)
+}
+
+// Delta-of-delta ranges encoded in fewer bits than a whole time, with
+// the prefix of each.
+var timeBuckets = []struct {
+	prefix     uint64
+	prefixBits int
+	bits       int
+}{
+	{0b10, 2, 7},
+	{0b110, 3, 9},
+	{0b1110, 4, 12},
+}
+
+// encodeTimes encodes the times as the first time, the first interval
+// and the change of each following interval. An unchanged interval
+// takes a bit and small changes take a few bits.
+func encodeTimes(times []int64) []byte {
+	var w bitWriter
+	w.writeBits(uint64(times[0]), 64)
+	if len(times) == 1 {
+		return w.data
+	}
+
+	interval := times[1] - times[0]
+	w.writeBits(uint64(interval), 64)
+	for i := 2; i < len(times); i++ {
+		next := times[i] - times[i-1]
+		change := next - interval
+		interval = next
+
+		if change == 0 {
+			w.writeBits(0, 1)
+			continue
+		}
+
+		encoded := false
+		for _, bucket := range timeBuckets {
+			if limit := int64(1) << (bucket.bits - 1); change >= -limit && change < limit {
+				w.writeBits(bucket.prefix, bucket.prefixBits)
+				w.writeBits(uint64(change), bucket.bits)
+				encoded = true
+				break
+			}
+		}
+
+		if !encoded {
+			w.writeBits(0b1111, 4)
+			w.writeBits(uint64(change), 64)
+		}
+	}
+
+	return w.data
+}
+
+// decodeTimes decodes n times encoded by encodeTimes.
+func decodeTimes(data []byte, n int) ([]int64, error) {
+	r := bitReader{data: data}
+	times := make([]int64, 0, n)
+
+	first, err := r.readBits(64)
+	if err != nil {
+		return nil, err
+	}
+	times = append(times, int64(first))
+	if n == 1 {
+		return times, nil
+	}
+
+	interval, err := r.readBits(64)
+	if err != nil {
+		return nil, err
+	}
+	times = append(times, times[0]+int64(interval))
+
+	for len(times) < n {
+		// Count the prefix's ones, up to four.
+		ones := 0
+		for ones < 4 {
+			bit, err := r.readBits(1)
+			if err != nil {
+				return nil, err
+			}
+			if bit == 0 {
+				break
+			}
+			ones++
+		}
+
+		var change int64
+		switch ones {
+		case 0:
+		case 4:
+			value, err := r.readBits(64)
+			if err != nil {
+				return nil, err
+			}
+			change = int64(value)
+		default:
+			size := timeBuckets[ones-1].bits
+			value, err := r.readBits(size)
+			if err != nil {
+				return nil, err
+			}
+			change = signExtend(value, size)
+		}
+
+		interval += uint64(change)
+		times = append(times, times[len(times)-1]+int64(interval))
+	}
+
+	return times, nil
+}
+
+// encodeXOR encodes each value XORed with the previous value. An
+// unchanged value takes a bit. A changed value takes its meaningful
+// bits, between the leading and trailing zeros of the XOR, reusing the
+// previous value's range of meaningful bits when they fit in it.
+func encodeXOR(values []uint64) []byte {
+	var w bitWriter
+	w.writeBits(values[0], 64)
+
+	leading, trailing := -1, -1
+	for i := 1; i < len(values); i++ {
+		xor := values[i] ^ values[i-1]
+		if xor == 0 {
+			w.writeBits(0, 1)
+			continue
+		}
+		w.writeBits(1, 1)
+
+		// The number of leading zeros is written in five bits.
+		lz, tz := min(bits.LeadingZeros64(xor), 31), bits.TrailingZeros64(xor)
+		if leading >= 0 && lz >= leading && tz >= trailing {
+			w.writeBits(0, 1)
+			w.writeBits(xor>>trailing, 64-leading-trailing)
+			continue
+		}
+
+		leading, trailing = lz, tz
+		meaningful := 64 - lz - tz
+		w.writeBits(1, 1)
+		w.writeBits(uint64(lz), 5)
+		w.writeBits(uint64(meaningful-1), 6)
+		w.writeBits(xor>>tz, meaningful)
+	}
+
+	return w.data
+}
+
+// decodeXOR decodes n values encoded by encodeXOR.
+func decodeXOR(data []byte, n int) ([]uint64, error) {
+	r := bitReader{data: data}
+	values := make([]uint64, 0, n)
+
+	value, err := r.readBits(64)
+	if err != nil {
+		return nil, err
+	}
+	values = append(values, value)
+
+	leading, trailing := -1, -1
+	for len(values) < n {
+		changed, err := r.readBits(1)
+		if err != nil {
+			return nil, err
+		}
+
+		if changed == 0 {
+			values = append(values, value)
+			continue
+		}
+
+		newRange, err := r.readBits(1)
+		if err != nil {
+			return nil, err
+		}
+
+		if newRange == 1 {
+			lz, err := r.readBits(5)
+			if err != nil {
+				return nil, err
+			}
+
+			meaningful, err := r.readBits(6)
+			if err != nil {
+				return nil, err
+			}
+
+			leading, trailing = int(lz), 64-int(lz)-int(meaningful+1)
+			if trailing < 0 {
+				return nil, errors.New("invalid range of meaningful bits")
+			}
+		} else if leading < 0 {
+			return nil, errors.New("missing range of meaningful bits")
+		}
+
+		xor, err := r.readBits(64 - leading - trailing)
+		if err != nil {
+			return nil, err
+		}
+
+		value ^= xor << trailing
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// encodeRuns encodes the values as runs of equal values: the length of
+// each run and its value.
+func encodeRuns(values []uint64) []byte {
+	data := make([]byte, 0)
+	for start := 0; start < len(values); {
+		end := start + 1
+		for end < len(values) && values[end] == values[start] {
+			end++
+		}
+
+		data = binary.AppendUvarint(data, uint64(end-start))
+		data = binary.AppendUvarint(data, values[start])
+		start = end
+	}
+
+	return data
+}
+
+// decodeRuns decodes n values encoded by encodeRuns.
+func decodeRuns(data []byte, n int) ([]uint64, error) {
+	r := binaryReader{data: data}
+	values := make([]uint64, 0, n)
+	for len(values) < n {
+		run, value := r.uvarint(), r.uvarint()
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		if run == 0 || run > uint64(n-len(values)) {
+			return nil, errors.New("invalid run length")
+		}
+
+		for ; run > 0; run-- {
+			values = append(values, value)
+		}
+	}
+
+	return values, nil
+}
+
+// signExtend returns the signed value of the low bits of value.
+func signExtend(value uint64, size int) int64 {
+	shift := 64 - size
+	return int64(value<<shift) >> shift
+}
+
+// bitWriter appends bits to a byte slice, most significant bit first.
+type bitWriter struct {
+	data []byte
+	free int // Unused bits of the last byte.
+}
+
+// writeBits writes the low count bits of value.
+func (w *bitWriter) writeBits(value uint64, count int) {
+	for count > 0 {
+		if w.free == 0 {
+			w.data = append(w.data, 0)
+			w.free = 8
+		}
+
+		n := min(count, w.free)
+		chunk := (value >> (count - n)) & (1<<n - 1)
+		w.data[len(w.data)-1] |= byte(chunk << (w.free - n))
+		w.free -= n
+		count -= n
+	}
+}
+
+// bitReader reads bits written by a bitWriter.
+type bitReader struct {
+	data []byte
+	pos  int // Bits read.
+}
+
+// readBits returns the next count bits.
+func (r *bitReader) readBits(count int) (uint64, error) {
+	if r.pos+count > len(r.data)*8 {
+		return 0, errColumnEnd
+	}
+
+	var value uint64
+	for count > 0 {
+		available := 8 - r.pos%8
+		n := min(count, available)
+		chunk := uint64(r.data[r.pos/8]>>(available-n)) & (1<<n - 1)
+		value = value<<n | chunk
+		r.pos += n
+		count -= n
+	}
+
+	return value, nil
+}
@@ -0,0 +1,150 @@
+package tempest
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+// simulatedObservations returns the observations of a simulated sensor
+// over the duration of the scenario.
+func simulatedObservations(t *testing.T, scenario Scenario, duration time.Duration) []WeatherObservation {
+	t.Helper()
+
+	sim := NewSimulator(SimulatorConfig{Start: time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), Duration: duration, Scenario: scenario, Seed: 1})
+	observations := make([]WeatherObservation, 0)
+	for {
+		datagram, err := sim.Next()
+		if errors.Is(err, io.EOF) {
+			return observations
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var raw RawMessage
+		if err := json.Unmarshal(datagram.Data, &raw); err != nil {
+			t.Fatal(err)
+		}
+
+		if msgType, _ := raw.Type(); msgType != MessageTypeObservation {
+			continue
+		}
+
+		var observation Observation
+		if err := observation.read(raw); err != nil {
+			t.Fatal(err)
+		}
+		observations = append(observations, observation.Observations...)
+	}
+}
+
+// checkObservations checks the decoded observations have the times,
+// values and missing fields of the encoded observations.
+func checkObservations(t *testing.T, decoded []WeatherObservation, encoded []WeatherObservation) {
+	t.Helper()
+
+	if len(decoded) != len(encoded) {
+		t.Fatalf("expected %d observations, got %d", len(encoded), len(decoded))
+	}
+
+	for i := range encoded {
+		if !decoded[i].EpochSecondsUTC.Equal(encoded[i].EpochSecondsUTC) || decoded[i].Missing != encoded[i].Missing {
+			t.Fatalf("observation %d: expected %+v, got %+v", i, encoded[i], decoded[i])
+		}
+
+		// Compare bits, so NaN equals NaN.
+		values, expected := observationValues(decoded[i]), observationValues(encoded[i])
+		for field := range values {
+			if math.Float64bits(values[field]) != math.Float64bits(expected[field]) {
+				t.Fatalf("observation %d: expected %s %v, got %v", i, observationFieldNames[field].name, expected[field], values[field])
+			}
+		}
+	}
+}
+
+func TestEncodeObservations_RoundTrip(t *testing.T) {
+	simulated := simulatedObservations(t, ScenarioStorm, 6*time.Hour)
+
+	// Irregular times and unusual values.
+	base := time.Unix(1622505600, 0)
+	unusual := []WeatherObservation{
+		{EpochSecondsUTC: base, AirTemperature: NewTemp(math.NaN(), Celsius), Missing: FieldAirTemperature | FieldUV},
+		{EpochSecondsUTC: base.Add(61 * time.Second), AirTemperature: NewTemp(math.Copysign(0, -1), Celsius), UV: math.Inf(1)},
+		{EpochSecondsUTC: base.Add(59 * time.Second), RelativeHumidity: math.SmallestNonzeroFloat64, Illuminance: -1},
+		{EpochSecondsUTC: base.Add(1500 * time.Millisecond), BatteryVolts: math.MaxFloat64},
+		{EpochSecondsUTC: base.Add(1500 * time.Millisecond)},
+		{EpochSecondsUTC: base.Add(100 * 365 * 24 * time.Hour), LightningStrikeCnt: math.MaxInt32},
+		{EpochSecondsUTC: time.Unix(0, 0), Missing: math.MaxUint32},
+	}
+
+	for name, observations := range map[string][]WeatherObservation{
+		"simulated": simulated,
+		"unusual":   unusual,
+		"one":       unusual[:1],
+		"two":       unusual[:2],
+		"none":      {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			decoded, err := DecodeObservations(EncodeObservations(observations))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkObservations(t, decoded, observations)
+		})
+	}
+
+	// Decoded observations are equal to observations decoded from
+	// sensor messages.
+	decoded, err := DecodeObservations(EncodeObservations(simulated[:1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded[0] != simulated[0] {
+		t.Errorf("expected %+v, got %+v", simulated[0], decoded[0])
+	}
+}
+
+func TestEncodeObservations_Size(t *testing.T) {
+	for name, scenario := range map[string]Scenario{"fair": ScenarioFair, "storm": ScenarioStorm} {
+		observations := simulatedObservations(t, scenario, 6*time.Hour)
+
+		var size int
+		for _, ob := range observations {
+			data, err := json.Marshal(ob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			size += len(data)
+		}
+
+		encoded := EncodeObservations(observations)
+		if len(encoded)*10 > size {
+			t.Errorf("%s: expected a tenth of %d bytes of JSON, got %d bytes", name, size, len(encoded))
+		}
+	}
+}
+
+func TestDecodeObservations_Invalid(t *testing.T) {
+	encoded := EncodeObservations(simulatedObservations(t, ScenarioStorm, time.Hour))
+
+	// Truncated encodings are errors, not panics.
+	for n := 0; n < len(encoded); n++ {
+		if _, err := DecodeObservations(encoded[:n]); err == nil {
+			t.Fatalf("expected an error decoding %d of %d bytes", n, len(encoded))
+		}
+	}
+
+	if _, err := DecodeObservations([]byte{columnarVersion + 1, 0}); err == nil {
+		t.Error("expected an error for an unknown version")
+	}
+
+	if _, err := DecodeObservations([]byte{columnarVersion, 0xff, 0xff, 0xff, 0x7f}); err == nil {
+		t.Error("expected an error for a count larger than the data")
+	}
+}
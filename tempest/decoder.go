@@ -0,0 +1,192 @@
+package tempest
+
+import (
+	"fmt"
+	"sync"
+)
+
+// A Decoder converts a raw message from the hub to a WeatherMessage.
+//
+// The hub and sensor are the network's records for the hub and sensor
+// that reported the message. The sensor is nil for messages reported by
+// hubs themselves, whose types are registered with RegisterHubDecoder.
+//
+// Decoders report problems by returning an error, which the network
+// logs with the message type and the serial numbers of the hub and
+// sensor, so decoders need no logger of their own.
+type Decoder func(raw RawMessage, sensor *WeatherSensor, hub *Hub) (WeatherMessage, error)
+
+// decoderRegistration is the decoder registered for a message type.
+type decoderRegistration struct {
+	decode Decoder
+	hub    bool // Messages of the type are reported by hubs themselves.
+}
+
+// decoders maps each message type to the decoder for the type.
+// A message type is valid if it has a decoder.
+var decoders = struct {
+	mu       sync.RWMutex
+	registry map[Type]decoderRegistration
+}{
+	registry: make(map[Type]decoderRegistration),
+}
+
+// The built-in decoders are registered in init because the decoders
+// refer back to the registry when validating message types.
+func init() {
+	RegisterDecoder(MessageTypeRainStartEvent, decodeRainStartEvent)
+	RegisterDecoder(MessageTypeLightningStrike, decodeLightningStrikeEvent)
+	RegisterDecoder(MessageTypeRapidWind, decodeRapidWindEvent)
+	RegisterDecoder(MessageTypeObservation, decodeObservation)
+	RegisterDecoder(MessageTypeAirObservation, decodeAirObservation)
+	RegisterDecoder(MessageTypeSkyObservation, decodeSkyObservation)
+	RegisterDecoder(MessageTypeDeviceStatus, decodeDeviceStatus)
+	RegisterHubDecoder(MessageTypeHubStatus, decodeHubStatus)
+}
+
+// RegisterDecoder registers the decoder for the message type,
+// replacing any decoder already registered for the type. Registering
+// a nil decoder removes the type, after which messages of the type
+// are no longer valid.
+//
+// Messages of the type are reported by sensors and must have a sensor
+// serial number.
+func RegisterDecoder(t Type, decoder Decoder) {
+	register(t, decoderRegistration{decode: decoder})
+}
+
+// RegisterHubDecoder registers the decoder for a message type reported
+// by hubs themselves, like hub_status, as RegisterDecoder does. Messages
+// of the type need no sensor serial number and are decoded with a nil
+// sensor.
+func RegisterHubDecoder(t Type, decoder Decoder) {
+	register(t, decoderRegistration{decode: decoder, hub: true})
+}
+
+// register registers the decoder for the message type, removing the
+// type if the decoder is nil.
+func register(t Type, registration decoderRegistration) {
+	decoders.mu.Lock()
+	defer decoders.mu.Unlock()
+
+	if registration.decode == nil {
+		delete(decoders.registry, t)
+		return
+	}
+
+	decoders.registry[t] = registration
+}
+
+// lookupDecoder returns the decoder registered for the message type.
+func lookupDecoder(t Type) (decoderRegistration, bool) {
+	decoders.mu.RLock()
+	defer decoders.mu.RUnlock()
+
+	registration, found := decoders.registry[t]
+	return registration, found
+}
+
+// requireSensor returns an error if a sensor message has no sensor.
+func requireSensor(sensor *WeatherSensor) error {
+	if sensor == nil {
+		return fmt.Errorf("sensor not found")
+	}
+
+	return nil
+}
+
+// decodeRapidWindEvent decodes a rapid_wind message.
+func decodeRapidWindEvent(raw RawMessage, sensor *WeatherSensor, hub *Hub) (WeatherMessage, error) {
+	if err := requireSensor(sensor); err != nil {
+		return nil, err
+	}
+
+	event, err := NewRapidWindEvent(raw, sensor, hub)
+	if err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+// decodeLightningStrikeEvent decodes an evt_strike message.
+func decodeLightningStrikeEvent(raw RawMessage, sensor *WeatherSensor, hub *Hub) (WeatherMessage, error) {
+	if err := requireSensor(sensor); err != nil {
+		return nil, err
+	}
+
+	event, err := NewLightningStrikeEvent(raw, sensor, hub)
+	if err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+// decodeRainStartEvent decodes an evt_precip message.
+func decodeRainStartEvent(raw RawMessage, sensor *WeatherSensor, hub *Hub) (WeatherMessage, error) {
+	if err := requireSensor(sensor); err != nil {
+		return nil, err
+	}
+
+	event, err := NewRainStartEvent(raw, sensor, hub)
+	if err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+// decodeObservation decodes an obs_st message.
+func decodeObservation(raw RawMessage, _ *WeatherSensor, _ *Hub) (WeatherMessage, error) {
+	observation := &Observation{}
+	if err := observation.read(raw); err != nil {
+		return nil, err
+	}
+
+	return observation, nil
+}
+
+// decodeAirObservation decodes an obs_air message.
+func decodeAirObservation(raw RawMessage, _ *WeatherSensor, _ *Hub) (WeatherMessage, error) {
+	observation := &AirObservation{}
+	if err := observation.read(raw); err != nil {
+		return nil, err
+	}
+
+	return observation, nil
+}
+
+// decodeSkyObservation decodes an obs_sky message.
+func decodeSkyObservation(raw RawMessage, _ *WeatherSensor, _ *Hub) (WeatherMessage, error) {
+	observation := &SkyObservation{}
+	if err := observation.read(raw); err != nil {
+		return nil, err
+	}
+
+	return observation, nil
+}
+
+// decodeDeviceStatus decodes a device_status message.
+func decodeDeviceStatus(raw RawMessage, sensor *WeatherSensor, hub *Hub) (WeatherMessage, error) {
+	if err := requireSensor(sensor); err != nil {
+		return nil, err
+	}
+
+	status, err := NewDeviceStatus(raw, sensor, hub)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// decodeHubStatus decodes a hub_status message.
+func decodeHubStatus(raw RawMessage, _ *WeatherSensor, hub *Hub) (WeatherMessage, error) {
+	status, err := NewHubStatus(raw, hub)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
@@ -0,0 +1,123 @@
+package tempest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// firmwareMessage is a message type introduced by newer firmware.
+type firmwareMessage struct {
+	hub *Hub
+}
+
+func (f *firmwareMessage) Type() Type      { return "evt_firmware" }
+func (f *firmwareMessage) Time() time.Time { return time.Time{} }
+
+func TestRegisterDecoder(t *testing.T) {
+	const firmwareType Type = "evt_firmware"
+	if firmwareType.Valid() {
+		t.Fatalf("expected %s to be invalid before registering", firmwareType)
+	}
+
+	RegisterDecoder(firmwareType, func(raw RawMessage, sensor *WeatherSensor, hub *Hub) (WeatherMessage, error) {
+		return &firmwareMessage{hub: hub}, nil
+	})
+	defer RegisterDecoder(firmwareType, nil)
+
+	if !firmwareType.Valid() {
+		t.Fatalf("expected %s to be valid after registering", firmwareType)
+	}
+
+	n := NewNetwork("test")
+	decoded, err := n.decode(context.Background(), testNetworkMessage(t, `{"serial_number":"ST-00000512","type":"evt_firmware","hub_sn":"HB-00013030"}`))
+	if err != nil {
+		t.Fatalf("error decoding registered type: %v", err)
+	}
+
+	if msg, ok := decoded.(*firmwareMessage); !ok || msg.hub != n.hubs.hubs["HB-00013030"] {
+		t.Errorf("unexpected message: %+v", decoded)
+	}
+}
+
+func TestRegisterHubDecoder(t *testing.T) {
+	const firmwareType Type = "hub_firmware"
+	RegisterHubDecoder(firmwareType, func(raw RawMessage, sensor *WeatherSensor, hub *Hub) (WeatherMessage, error) {
+		if sensor != nil {
+			return nil, fmt.Errorf("unexpected sensor %s", sensor.SensorSerial)
+		}
+
+		return &firmwareMessage{hub: hub}, nil
+	})
+	defer RegisterHubDecoder(firmwareType, nil)
+
+	// Hubs report their own serial number as the serial number.
+	n := NewNetwork("test")
+	decoded, err := n.decode(context.Background(), testNetworkMessage(t, `{"serial_number":"HB-00013030","type":"hub_firmware"}`))
+	if err != nil {
+		t.Fatalf("error decoding registered hub type: %v", err)
+	}
+
+	if msg, ok := decoded.(*firmwareMessage); !ok || msg.hub != n.hubs.hubs["HB-00013030"] {
+		t.Errorf("unexpected message: %+v", decoded)
+	}
+
+	if sensors := n.hubs.hubs["HB-00013030"].WeatherSensors; len(sensors) != 0 {
+		t.Errorf("expected no sensors, got %+v", sensors)
+	}
+}
+
+func TestRegisterHubDecoder_Network(t *testing.T) {
+	const firmwareType Type = "hub_firmware"
+	RegisterHubDecoder(firmwareType, func(raw RawMessage, sensor *WeatherSensor, hub *Hub) (WeatherMessage, error) {
+		return &firmwareMessage{hub: hub}, nil
+	})
+	defer RegisterHubDecoder(firmwareType, nil)
+
+	payload := `{"serial_number":"HB-00013030","type":"hub_firmware"}`
+	raw := testNetworkMessage(t, payload).raw
+	if _, err := raw.SensorSerial(); err == nil {
+		t.Errorf("expected no sensor serial for a hub message")
+	}
+
+	// The network delivers the message without a sensor.
+	datagrams := make(chan Datagram, 1)
+	datagrams <- Datagram{Data: []byte(payload), Source: net.IPv4(192, 168, 1, 2)}
+	close(datagrams)
+
+	n := NewNetwork("test")
+	sub := n.Subscribe((&firmwareMessage{}).Type())
+	if err := n.RunSources(context.Background(), NewChannelSource(datagrams)); err != nil {
+		t.Fatal(err)
+	}
+	sub.Unsubscribe()
+
+	messages := make([]WeatherMessage, 0)
+	for message := range sub.Messages() {
+		messages = append(messages, message)
+	}
+
+	hub := n.hubs.hubs["HB-00013030"]
+	if len(messages) != 1 || hub == nil || messages[0].(*firmwareMessage).hub != hub {
+		t.Fatalf("unexpected messages: %+v", messages)
+	}
+
+	if len(hub.WeatherSensors) != 0 {
+		t.Errorf("expected no sensors, got %+v", hub.WeatherSensors)
+	}
+}
+
+func TestRegisterDecoder_Override(t *testing.T) {
+	RegisterDecoder(MessageTypeRapidWind, func(raw RawMessage, sensor *WeatherSensor, hub *Hub) (WeatherMessage, error) {
+		return nil, fmt.Errorf("overridden")
+	})
+	defer RegisterDecoder(MessageTypeRapidWind, decodeRapidWindEvent)
+
+	n := NewNetwork("test")
+	_, err := n.decode(context.Background(), testNetworkMessage(t, `{"serial_number":"ST-00000512","type":"rapid_wind","hub_sn":"HB-00013030","ob":[1493322445,2.3,128]}`))
+	if err == nil {
+		t.Errorf("expected the overriding decoder to be used")
+	}
+}
@@ -0,0 +1,169 @@
+package tempest
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SensorStatus is the sensor_status bitfield reported in a device
+// status message. A set bit indicates a failed instrument or the
+// state of the power booster.
+type SensorStatus uint32
+
+// Sensor status flags.
+const (
+	SensorStatusLightningFailed      SensorStatus = 0x00000001
+	SensorStatusLightningNoise       SensorStatus = 0x00000002
+	SensorStatusLightningDisturber   SensorStatus = 0x00000004
+	SensorStatusPressureFailed       SensorStatus = 0x00000008
+	SensorStatusTemperatureFailed    SensorStatus = 0x00000010
+	SensorStatusRHFailed             SensorStatus = 0x00000020
+	SensorStatusWindFailed           SensorStatus = 0x00000040
+	SensorStatusPrecipFailed         SensorStatus = 0x00000080
+	SensorStatusLightUVFailed        SensorStatus = 0x00000100
+	SensorStatusPowerBoosterDepleted SensorStatus = 0x00008000
+	SensorStatusPowerBoosterShore    SensorStatus = 0x00010000
+)
+
+// sensorStatusNames is the order and name of each sensor status flag.
+var sensorStatusNames = []struct {
+	flag SensorStatus
+	name string
+}{
+	{SensorStatusLightningFailed, "lightning failed"},
+	{SensorStatusLightningNoise, "lightning noise"},
+	{SensorStatusLightningDisturber, "lightning disturber"},
+	{SensorStatusPressureFailed, "pressure failed"},
+	{SensorStatusTemperatureFailed, "temperature failed"},
+	{SensorStatusRHFailed, "rh failed"},
+	{SensorStatusWindFailed, "wind failed"},
+	{SensorStatusPrecipFailed, "precip failed"},
+	{SensorStatusLightUVFailed, "light/uv failed"},
+	{SensorStatusPowerBoosterDepleted, "power booster depleted"},
+	{SensorStatusPowerBoosterShore, "power booster shore power"},
+}
+
+// sensorStatusFailures are the flags reporting a failed instrument.
+const sensorStatusFailures = SensorStatusLightningFailed |
+	SensorStatusPressureFailed |
+	SensorStatusTemperatureFailed |
+	SensorStatusRHFailed |
+	SensorStatusWindFailed |
+	SensorStatusPrecipFailed |
+	SensorStatusLightUVFailed
+
+// Has returns true if every bit of flag is set.
+func (s SensorStatus) Has(flag SensorStatus) bool {
+	return s&flag == flag
+}
+
+// Failed returns true if any instrument has failed.
+func (s SensorStatus) Failed() bool {
+	return s&sensorStatusFailures != 0
+}
+
+// OK returns true if no sensor status flags are set.
+func (s SensorStatus) OK() bool {
+	return s == 0
+}
+
+// String returns the names of the set flags separated by commas,
+// or "ok" when no flags are set.
+func (s SensorStatus) String() string {
+	if s.OK() {
+		return "ok"
+	}
+
+	names := make([]string, 0)
+	for _, status := range sensorStatusNames {
+		if s.Has(status.flag) {
+			names = append(names, status.name)
+		}
+	}
+
+	return strings.Join(names, ",")
+}
+
+// DeviceStatus represents a device status message reported by a
+// sensor to the hub.
+type DeviceStatus struct {
+	Sensor           *WeatherSensor // Sensor reporting the status to the hub.
+	Hub              *Hub           // The hub reporting the status to the network.
+	Timestamp        time.Time      // Timestamp(Epoch UTC) of the status.
+	Uptime           time.Duration  // Time since the sensor was last reset.
+	Voltage          float64        // Sensor battery voltage.
+	FirmwareRevision int            // Sensor firmware revision.
+	RSSI             int            // Signal strength of the hub as seen by the sensor (dBm).
+	HubRSSI          int            // Signal strength of the sensor as seen by the hub (dBm).
+	SensorStatus     SensorStatus   // Instrument failures and power booster state.
+	Debug            bool           // True if debugging is enabled on the sensor.
+}
+
+// NewDeviceStatus creates a new device status with a device status
+// raw message from the hub.
+func NewDeviceStatus(raw RawMessage, sensor *WeatherSensor, hub *Hub) (*DeviceStatus, error) {
+	status := &DeviceStatus{
+		Sensor: sensor,
+		Hub:    hub,
+	}
+
+	timestamp, err := raw.number("timestamp")
+	if err != nil {
+		return nil, fmt.Errorf("device status %w", err)
+	}
+	status.Timestamp = time.Unix(int64(timestamp), 0)
+
+	uptime, err := raw.number("uptime")
+	if err != nil {
+		return nil, fmt.Errorf("device status %w", err)
+	}
+	status.Uptime = time.Duration(uptime) * time.Second
+
+	voltage, err := raw.number("voltage")
+	if err != nil {
+		return nil, fmt.Errorf("device status %w", err)
+	}
+	status.Voltage = voltage
+
+	firmware, err := raw.number("firmware_revision")
+	if err != nil {
+		return nil, fmt.Errorf("device status %w", err)
+	}
+	status.FirmwareRevision = int(firmware)
+
+	rssi, err := raw.number("rssi")
+	if err != nil {
+		return nil, fmt.Errorf("device status %w", err)
+	}
+	status.RSSI = int(rssi)
+
+	hubRssi, err := raw.number("hub_rssi")
+	if err != nil {
+		return nil, fmt.Errorf("device status %w", err)
+	}
+	status.HubRSSI = int(hubRssi)
+
+	sensorStatus, err := raw.number("sensor_status")
+	if err != nil {
+		return nil, fmt.Errorf("device status %w", err)
+	}
+	status.SensorStatus = SensorStatus(sensorStatus)
+
+	// Debug is optional on older firmware.
+	if debug, err := raw.number("debug"); err == nil {
+		status.Debug = debug != 0
+	}
+
+	return status, nil
+}
+
+// Type returns the message type for the device status.
+func (d *DeviceStatus) Type() Type {
+	return MessageTypeDeviceStatus
+}
+
+// Time returns the time of the device status.
+func (d *DeviceStatus) Time() time.Time {
+	return d.Timestamp
+}
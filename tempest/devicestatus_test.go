@@ -0,0 +1,81 @@
+package tempest
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestNewDeviceStatus(t *testing.T) {
+	rawMessage := `{"serial_number":"AR-00004049","type":"device_status","hub_sn":"HB-00000001","timestamp":1510855923,"uptime":2189,"voltage":3.50,"firmware_revision":17,"rssi":-17,"hub_rssi":-87,"sensor_status":8232,"debug":0}`
+	var raw RawMessage
+	if err := json.Unmarshal([]byte(rawMessage), &raw); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := NewDeviceStatus(raw, nil, nil)
+	if err != nil {
+		t.Fatalf("error creating device status: %v", err)
+	}
+
+	if !status.Time().Equal(time.Unix(1510855923, 0)) {
+		t.Errorf("unexpected timestamp: %v", status.Time())
+	}
+
+	if status.Uptime != 2189*time.Second {
+		t.Errorf("unexpected uptime: %v", status.Uptime)
+	}
+
+	if status.Voltage != 3.5 || status.FirmwareRevision != 17 {
+		t.Errorf("unexpected voltage or firmware: %f %d", status.Voltage, status.FirmwareRevision)
+	}
+
+	if status.RSSI != -17 || status.HubRSSI != -87 {
+		t.Errorf("unexpected rssi: %d %d", status.RSSI, status.HubRSSI)
+	}
+
+	// 8232 = 0x2028 = pressure failed, rh failed and an unknown bit.
+	if !status.SensorStatus.Has(SensorStatusPressureFailed) || !status.SensorStatus.Has(SensorStatusRHFailed) {
+		t.Errorf("expected pressure and rh failures: %s", status.SensorStatus)
+	}
+
+	if status.SensorStatus.Has(SensorStatusWindFailed) {
+		t.Errorf("unexpected wind failure: %s", status.SensorStatus)
+	}
+
+	if status.Debug {
+		t.Errorf("expected debug to be disabled")
+	}
+}
+
+func TestSensorStatus_String(t *testing.T) {
+	tests := []struct {
+		status SensorStatus
+		want   string
+	}{
+		{0, "ok"},
+		{SensorStatusWindFailed, "wind failed"},
+		{SensorStatusLightningFailed | SensorStatusLightUVFailed, "lightning failed,light/uv failed"},
+		{SensorStatusPowerBoosterShore, "power booster shore power"},
+	}
+
+	for _, test := range tests {
+		if got := test.status.String(); got != test.want {
+			t.Errorf("expected %q, got %q", test.want, got)
+		}
+	}
+}
+
+func TestSensorStatus_Failed(t *testing.T) {
+	if SensorStatusLightningNoise.Failed() {
+		t.Errorf("lightning noise is not a failure")
+	}
+
+	if SensorStatusPowerBoosterDepleted.Failed() {
+		t.Errorf("power booster state is not a failure")
+	}
+
+	if !SensorStatusPrecipFailed.Failed() {
+		t.Errorf("expected precip failure")
+	}
+}
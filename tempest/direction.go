@@ -1,6 +1,9 @@
 package tempest
 
-import "math"
+import (
+	"encoding/json"
+	"math"
+)
 
 // DirectionUnit represents a unit of measurement
 // for a direction.
@@ -157,6 +160,32 @@ func NewDirection(reading interface{}, unit DirectionUnit) Direction {
 	}
 }
 
+// directionJSON is the JSON form of a direction measurement.
+type directionJSON struct {
+	Direction float64
+	Unit      DirectionUnit
+}
+
+// MarshalJSON encodes the direction measurement and its unit.
+func (d Direction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(directionJSON{Direction: d.direction, Unit: d.unit})
+}
+
+// UnmarshalJSON decodes a direction measurement encoded by
+// MarshalJSON. The measurement is kept as encoded rather than
+// passed through NewDirection, which would round cardinal
+// directions again.
+func (d *Direction) UnmarshalJSON(data []byte) error {
+	var decoded directionJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	*d = Direction{direction: decoded.Direction, unit: decoded.Unit}
+
+	return nil
+}
+
 // Cardinal returns the direction as a cardinal direction.
 func (d Direction) Cardinal() string {
 	switch d.unit {
@@ -0,0 +1,17 @@
+// Package tempest listens for WeatherFlow Tempest hubs on the local
+// network and decodes the messages they broadcast.
+//
+// A Network reads datagrams from its sources, keeps track of the hubs
+// and sensors it hears from, and delivers each decoded WeatherMessage to
+// its subscribers and message repo. Use a type switch on a
+// WeatherMessage to get at the concrete message, like *RapidWindEvent
+// or *Observation.
+//
+// # Changes to WeatherMessage
+//
+// WeatherMessage used to include Read([]byte) error. It now has only
+// Type and Time, so messages built by decoders, which never parse their
+// own JSON, satisfy it. Observation, AirObservation and SkyObservation
+// still have Read; code that called Read through a WeatherMessage must
+// call it on the concrete type instead.
+package tempest
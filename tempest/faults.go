@@ -0,0 +1,314 @@
+package tempest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FaultConfig sets how often a FaultInjector corrupts datagrams. Each
+// rate is the probability from 0 to 1 that a datagram suffers the
+// fault. A datagram suffers at most one fault, tried in field order.
+type FaultConfig struct {
+	Truncate    float64 // Cut the datagram short.
+	Concatenate float64 // Join the datagram with the one after it.
+	InvalidJSON float64 // Break the JSON syntax of the datagram.
+	WrongType   float64 // Give a field a value of the wrong type.
+	NullField   float64 // Replace a field with null.
+	Duplicate   float64 // Send the datagram twice.
+	Reorder     float64 // Send the datagram after the one following it.
+
+	// ClockSkew is added to the timestamps of every message, as if the
+	// hub's clock were wrong.
+	ClockSkew time.Duration
+
+	Seed int64 // Seed choosing the faults. Zero uses a random seed.
+}
+
+// FaultStats count the faults a FaultInjector has injected.
+type FaultStats struct {
+	Datagrams    uint64 // Datagrams returned, including faulty ones.
+	Truncated    uint64
+	Concatenated uint64
+	InvalidJSON  uint64
+	WrongTypes   uint64
+	NullFields   uint64
+	Duplicated   uint64
+	Reordered    uint64
+	Skewed       uint64 // Messages with skewed timestamps.
+}
+
+// A FaultInjector is a Source corrupting the datagrams of another
+// source, such as a Simulator, to test how a Network copes with
+// faulty hubs and networks. Use SendUDP to send the faulty datagrams
+// to a listening network.
+type FaultInjector struct {
+	source  Source
+	config  FaultConfig
+	random  *rand.Rand
+	pending []Datagram // Datagrams to return before reading the source.
+
+	mu    sync.Mutex
+	stats FaultStats
+}
+
+// NewFaultInjector returns a source corrupting the datagrams read from
+// the source. Closing the injector closes the source.
+func NewFaultInjector(source Source, config FaultConfig) *FaultInjector {
+	if config.Seed == 0 {
+		config.Seed = time.Now().UnixNano()
+	}
+
+	return &FaultInjector{
+		source: source,
+		config: config,
+		random: rand.New(rand.NewSource(config.Seed)),
+	}
+}
+
+// Stats returns the faults injected so far.
+func (f *FaultInjector) Stats() FaultStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.stats
+}
+
+// count updates the stats.
+func (f *FaultInjector) count(counter func(stats *FaultStats)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	counter(&f.stats)
+}
+
+// Read returns the next datagram, which may be faulty.
+func (f *FaultInjector) Read(ctx context.Context) (Datagram, error) {
+	datagram, err := f.next(ctx)
+	if err != nil {
+		return Datagram{}, err
+	}
+
+	f.count(func(stats *FaultStats) { stats.Datagrams++ })
+
+	return datagram, nil
+}
+
+// Close closes the source.
+func (f *FaultInjector) Close() error {
+	return f.source.Close()
+}
+
+// next returns a pending datagram or the next datagram from the
+// source with a fault applied.
+func (f *FaultInjector) next(ctx context.Context) (Datagram, error) {
+	if len(f.pending) > 0 {
+		datagram := f.pending[0]
+		f.pending = f.pending[1:]
+		return datagram, nil
+	}
+
+	datagram, err := f.read(ctx)
+	if err != nil {
+		return Datagram{}, err
+	}
+
+	switch {
+	case f.roll(f.config.Truncate):
+		if len(datagram.Data) > 1 {
+			datagram.Data = datagram.Data[:1+f.random.Intn(len(datagram.Data)-1)]
+			f.count(func(stats *FaultStats) { stats.Truncated++ })
+		}
+
+	case f.roll(f.config.Concatenate):
+		following, err := f.read(ctx)
+		if err != nil {
+			// Return what was read and let the next read fail.
+			break
+		}
+
+		datagram.Data = append(datagram.Data, following.Data...)
+		f.count(func(stats *FaultStats) { stats.Concatenated++ })
+
+	case f.roll(f.config.InvalidJSON):
+		if i := bytes.IndexAny(datagram.Data, ":,"); i >= 0 {
+			datagram.Data[i] = ';'
+			f.count(func(stats *FaultStats) { stats.InvalidJSON++ })
+		}
+
+	case f.roll(f.config.WrongType):
+		if f.mutate(&datagram, wrongType) {
+			f.count(func(stats *FaultStats) { stats.WrongTypes++ })
+		}
+
+	case f.roll(f.config.NullField):
+		if f.mutate(&datagram, func(interface{}) interface{} { return nil }) {
+			f.count(func(stats *FaultStats) { stats.NullFields++ })
+		}
+
+	case f.roll(f.config.Duplicate):
+		duplicate := datagram
+		duplicate.Data = append([]byte(nil), datagram.Data...)
+		f.pending = append(f.pending, duplicate)
+		f.count(func(stats *FaultStats) { stats.Duplicated++ })
+
+	case f.roll(f.config.Reorder):
+		following, err := f.read(ctx)
+		if err != nil {
+			break
+		}
+
+		f.pending = append(f.pending, datagram)
+		datagram = following
+		f.count(func(stats *FaultStats) { stats.Reordered++ })
+	}
+
+	return datagram, nil
+}
+
+// read returns a copy of the next datagram from the source with its
+// timestamps skewed. Datagrams are copied because a source may reuse
+// its buffer on the next read.
+func (f *FaultInjector) read(ctx context.Context) (Datagram, error) {
+	datagram, err := f.source.Read(ctx)
+	if err != nil {
+		return Datagram{}, err
+	}
+
+	datagram.Data = append([]byte(nil), datagram.Data...)
+
+	if f.config.ClockSkew != 0 {
+		if skewed, ok := skewTimestamps(datagram.Data, f.config.ClockSkew); ok {
+			datagram.Data = skewed
+			f.count(func(stats *FaultStats) { stats.Skewed++ })
+		}
+	}
+
+	return datagram, nil
+}
+
+// roll reports whether a fault with the rate happens.
+func (f *FaultInjector) roll(rate float64) bool {
+	return rate > 0 && f.random.Float64() < rate
+}
+
+// mutate replaces a random field of the datagram's message, or an
+// element of a field holding an array, with the value returned by
+// replace. The message type is left alone so the message reaches its
+// decoder. mutate reports whether the datagram was changed.
+func (f *FaultInjector) mutate(datagram *Datagram, replace func(interface{}) interface{}) bool {
+	var message map[string]interface{}
+	if err := json.Unmarshal(datagram.Data, &message); err != nil || message == nil {
+		return false
+	}
+
+	keys := make([]string, 0, len(message))
+	for key := range message {
+		if key != messageType {
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		return false
+	}
+
+	// Sort the keys so faults only depend on the seed.
+	sort.Strings(keys)
+	key := keys[f.random.Intn(len(keys))]
+
+	// Observations are arrays of arrays, so descend to a value.
+	parent, index := interface{}(message), interface{}(key)
+	value := message[key]
+	for {
+		array, ok := value.([]interface{})
+		if !ok || len(array) == 0 {
+			break
+		}
+
+		parent, index = array, f.random.Intn(len(array))
+		value = array[index.(int)]
+	}
+
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		p[index.(string)] = replace(value)
+	case []interface{}:
+		p[index.(int)] = replace(value)
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return false
+	}
+
+	datagram.Data = data
+	return true
+}
+
+// wrongType returns a value of a different JSON type than the value.
+func wrongType(value interface{}) interface{} {
+	switch value.(type) {
+	case string:
+		return 42
+	case nil, bool:
+		return []interface{}{}
+	default:
+		return "wrong"
+	}
+}
+
+// skewTimestamps shifts the timestamps of a message by the skew and
+// reports whether the message held timestamps.
+func skewTimestamps(data []byte, skew time.Duration) ([]byte, bool) {
+	var message map[string]interface{}
+	if err := json.Unmarshal(data, &message); err != nil || message == nil {
+		return nil, false
+	}
+
+	seconds := skew.Seconds()
+	shift := func(value interface{}) (interface{}, bool) {
+		timestamp, ok := value.(float64)
+		return timestamp + seconds, ok
+	}
+
+	skewed := false
+	shiftFirst := func(value interface{}) {
+		if array, ok := value.([]interface{}); ok && len(array) > 0 {
+			if shifted, ok := shift(array[0]); ok {
+				array[0] = shifted
+				skewed = true
+			}
+		}
+	}
+
+	// Timestamps are the first element of observation and event arrays
+	// and the timestamp field of status messages.
+	shiftFirst(message[rapidWindEventObservation])
+	shiftFirst(message[eventEvent])
+	if observations, ok := message["obs"].([]interface{}); ok {
+		for _, observation := range observations {
+			shiftFirst(observation)
+		}
+	}
+
+	if shifted, ok := shift(message["timestamp"]); ok {
+		message["timestamp"] = shifted
+		skewed = true
+	}
+
+	if !skewed {
+		return nil, false
+	}
+
+	skewedData, err := json.Marshal(message)
+	if err != nil {
+		return nil, false
+	}
+
+	return skewedData, true
+}
@@ -0,0 +1,191 @@
+package tempest
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+var faultTestStart = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// runFaults runs a network reading five simulated minutes of messages
+// through a fault injector with the configuration.
+func runFaults(t *testing.T, config FaultConfig) (*Network, *FaultInjector, *Subscription) {
+	t.Helper()
+
+	sim := NewSimulator(SimulatorConfig{Start: faultTestStart, Duration: 5 * time.Minute, Seed: 1})
+	config.Seed = 1
+	injector := NewFaultInjector(sim, config)
+
+	n := NewNetwork("test")
+	sub := n.SubscribeBuffered(4096)
+	t.Cleanup(sub.Unsubscribe)
+
+	if err := n.RunSources(context.Background(), injector); err != nil {
+		t.Fatal(err)
+	}
+
+	checkAccounted(t, n.Stats())
+
+	return n, injector, sub
+}
+
+// checkAccounted checks every datagram was either decoded or counted
+// as dropped.
+func checkAccounted(t *testing.T, stats Stats) {
+	t.Helper()
+
+	if accounted := stats.Oversized + stats.Malformed + stats.InvalidType + stats.QueueDropped + stats.Coalesced + stats.DecodeErrors + stats.Decoded; accounted != stats.Datagrams {
+		t.Errorf("%d of %d datagrams accounted for: %+v", accounted, stats.Datagrams, stats)
+	}
+}
+
+func TestFaultInjector_Malformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		config FaultConfig
+	}{
+		{"truncate", FaultConfig{Truncate: 1}},
+		{"concatenate", FaultConfig{Concatenate: 1}},
+		{"invalid json", FaultConfig{InvalidJSON: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, injector, _ := runFaults(t, tt.config)
+
+			stats := n.Stats()
+			if stats.Datagrams != injector.Stats().Datagrams || stats.Malformed != stats.Datagrams {
+				t.Errorf("expected every datagram to be malformed, got %+v", stats)
+			}
+		})
+	}
+}
+
+func TestFaultInjector_Fields(t *testing.T) {
+	tests := []struct {
+		name   string
+		config FaultConfig
+	}{
+		{"wrong type", FaultConfig{WrongType: 1}},
+		{"null field", FaultConfig{NullField: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, injector, _ := runFaults(t, tt.config)
+
+			injected := injector.Stats()
+			if injected.WrongTypes+injected.NullFields != injected.Datagrams {
+				t.Errorf("expected every datagram to be faulty, got %+v", injected)
+			}
+
+			// Messages with a broken field are dropped, but some fields
+			// such as the firmware revision are not needed to decode.
+			if stats := n.Stats(); stats.DecodeErrors == 0 {
+				t.Errorf("expected decode errors, got %+v", stats)
+			}
+		})
+	}
+}
+
+func TestFaultInjector_Duplicate(t *testing.T) {
+	n, injector, _ := runFaults(t, FaultConfig{Duplicate: 1})
+
+	stats := n.Stats()
+	if injected := injector.Stats(); injected.Datagrams != 2*injected.Duplicated || stats.Decoded != injected.Datagrams {
+		t.Errorf("expected every datagram to be decoded twice, got %+v from %+v", stats, injected)
+	}
+}
+
+func TestFaultInjector_Reorder(t *testing.T) {
+	_, injector, sub := runFaults(t, FaultConfig{Reorder: 0.5})
+
+	if injector.Stats().Reordered == 0 {
+		t.Fatal("expected reordered datagrams")
+	}
+
+	var last time.Time
+	backwards := 0
+	for len(sub.Messages()) > 0 {
+		msg := <-sub.Messages()
+		if wind, ok := msg.(*RapidWindEvent); ok {
+			if wind.Time().Before(last) {
+				backwards++
+			}
+			last = wind.Time()
+		}
+	}
+
+	if backwards == 0 {
+		t.Error("expected rapid wind messages out of order")
+	}
+}
+
+func TestFaultInjector_ClockSkew(t *testing.T) {
+	_, injector, sub := runFaults(t, FaultConfig{ClockSkew: -time.Hour})
+
+	if injected := injector.Stats(); injected.Skewed != injected.Datagrams {
+		t.Errorf("expected every message to be skewed, got %+v", injected)
+	}
+
+	for len(sub.Messages()) > 0 {
+		msg := <-sub.Messages()
+		if wind, ok := msg.(*RapidWindEvent); ok {
+			if !wind.Time().Before(faultTestStart) {
+				t.Errorf("expected skewed rapid wind time, got %s", wind.Time())
+			}
+		}
+	}
+}
+
+func TestFaultInjector_UDP(t *testing.T) {
+	source, err := ListenUDP(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewNetwork("test")
+	if err := n.StartSources(context.Background(), source); err != nil {
+		t.Fatal(err)
+	}
+	defer n.Stop()
+
+	sim := NewSimulator(SimulatorConfig{Start: faultTestStart, Duration: 2 * time.Minute, Seed: 1})
+	injector := NewFaultInjector(sim, FaultConfig{
+		Truncate:    0.05,
+		Concatenate: 0.05,
+		InvalidJSON: 0.05,
+		WrongType:   0.05,
+		NullField:   0.05,
+		Duplicate:   0.05,
+		Reorder:     0.05,
+		ClockSkew:   time.Minute,
+		Seed:        1,
+	})
+
+	if err := SendUDP(context.Background(), injector, source.Addr()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the network to process every datagram sent.
+	sent := injector.Stats().Datagrams
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := n.Stats()
+		if stats.Datagrams == sent && stats.Oversized+stats.Malformed+stats.InvalidType+stats.QueueDropped+stats.Coalesced+stats.DecodeErrors+stats.Decoded == sent {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("processed %+v of %d datagrams sent", stats, sent)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if stats := n.Stats(); stats.Malformed == 0 || stats.Decoded == 0 {
+		t.Errorf("expected malformed and decoded datagrams, got %+v", stats)
+	}
+}
@@ -0,0 +1,640 @@
+package tempest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Files of a FileRepo.
+const (
+	fileRepoHubs     = "hubs.json"
+	fileRepoSensors  = "sensors.json"
+	fileRepoMessages = "messages.jsonl"
+)
+
+// fileRepoStreamChunk is the most messages a FileRepo sorts at once
+// when streaming.
+const fileRepoStreamChunk = 4096
+
+// FileRepo is a HubRepo, SensorRepo and MessageRepo keeping hubs,
+// sensors and messages in files in a directory, so a network remembers
+// what it has seen across restarts.
+//
+// Hubs and sensors are kept in JSON files rewritten on every save.
+// Messages are appended to a JSON Lines file, one message per line,
+// rewritten whenever messages are deleted.
+// Streaming messages in time order reads the file once for every few
+// thousand messages streamed, sorting a bounded chunk of them each time.
+// Only the built-in message types can be saved. Messages refer to their
+// hub and sensor by serial number and load with the saved hub and
+// sensor records.
+//
+// FileRepo is safe for concurrent use by one process.
+type FileRepo struct {
+	dir string
+
+	mu       sync.Mutex
+	hubs     map[string]*Hub
+	sensors  map[string]*WeatherSensor
+	messages *os.File // Opened for appending.
+	chunk    int      // Most messages sorted at once when streaming.
+}
+
+// storedMessage is a message saved to a FileRepo.
+type storedMessage struct {
+	Type         Type            `json:"type"`
+	Time         time.Time       `json:"time"`
+	HubSerial    string          `json:"hub_sn,omitempty"`
+	SensorSerial string          `json:"serial_number,omitempty"`
+	Message      json.RawMessage `json:"message"`
+}
+
+// OpenFileRepo opens the repo in the directory, creating the directory
+// if it does not exist.
+func OpenFileRepo(dir string) (*FileRepo, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating repo directory: %w", err)
+	}
+
+	r := &FileRepo{
+		dir:     dir,
+		hubs:    make(map[string]*Hub),
+		sensors: make(map[string]*WeatherSensor),
+		chunk:   fileRepoStreamChunk,
+	}
+
+	if err := r.readJSON(fileRepoHubs, &r.hubs); err != nil {
+		return nil, err
+	}
+
+	if err := r.readJSON(fileRepoSensors, &r.sensors); err != nil {
+		return nil, err
+	}
+
+	messages, err := os.OpenFile(r.path(fileRepoMessages), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening messages: %w", err)
+	}
+
+	if err := truncateTornLine(messages); err != nil {
+		messages.Close()
+		return nil, err
+	}
+	r.messages = messages
+
+	return r, nil
+}
+
+// truncateTornLine removes a last line without a newline, left by a save
+// cut short by a crash, so the next save starts a new line.
+func truncateTornLine(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("error reading messages: %w", err)
+	}
+
+	reader, err := os.Open(file.Name())
+	if err != nil {
+		return fmt.Errorf("error reading messages: %w", err)
+	}
+	defer reader.Close()
+
+	// Search back from the end for the last newline.
+	size := int64(0)
+	buf := make([]byte, 4096)
+	for end := info.Size(); end > 0; {
+		start := max(end-int64(len(buf)), 0)
+		chunk := buf[:end-start]
+		if _, err := reader.ReadAt(chunk, start); err != nil {
+			return fmt.Errorf("error reading messages: %w", err)
+		}
+
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			size = start + int64(i) + 1
+			break
+		}
+		end = start
+	}
+
+	if size < info.Size() {
+		if err := file.Truncate(size); err != nil {
+			return fmt.Errorf("error truncating messages: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Close closes the repo.
+func (r *FileRepo) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.messages.Close()
+}
+
+// LoadHubs returns the saved hubs ordered by serial number.
+func (r *FileRepo) LoadHubs(ctx context.Context) ([]*Hub, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hubs := make([]*Hub, 0, len(r.hubs))
+	for _, hub := range r.hubs {
+		hubs = append(hubs, hub.clone())
+	}
+
+	sort.Slice(hubs, func(i, j int) bool {
+		return hubs[i].HubSerialNumber < hubs[j].HubSerialNumber
+	})
+
+	return hubs, nil
+}
+
+// SaveHub saves the hub, replacing the hub with the same serial number.
+func (r *FileRepo) SaveHub(ctx context.Context, hub *Hub) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.hubs[hub.HubSerialNumber] = hub.clone()
+
+	return r.writeJSON(fileRepoHubs, r.hubs)
+}
+
+// LoadSensors returns the saved sensors ordered by serial number.
+func (r *FileRepo) LoadSensors(ctx context.Context) ([]*WeatherSensor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sensors := make([]*WeatherSensor, 0, len(r.sensors))
+	for _, sensor := range r.sensors {
+		sensorCopy := *sensor
+		sensors = append(sensors, &sensorCopy)
+	}
+
+	sort.Slice(sensors, func(i, j int) bool {
+		return sensors[i].SensorSerial < sensors[j].SensorSerial
+	})
+
+	return sensors, nil
+}
+
+// SaveSensor saves the sensor, replacing the sensor with the same
+// serial number.
+func (r *FileRepo) SaveSensor(ctx context.Context, sensor *WeatherSensor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sensorCopy := *sensor
+	r.sensors[sensor.SensorSerial] = &sensorCopy
+
+	return r.writeJSON(fileRepoSensors, r.sensors)
+}
+
+// StreamMessages calls fn with each saved message selected by the
+// query ordered by time, until fn returns false. The repo is locked
+// while streaming, so fn must not use the repo.
+//
+// Messages are saved in the order received, so each chunk of messages
+// is selected by reading the whole file and sorted before streaming.
+func (r *FileRepo) StreamMessages(ctx context.Context, query MessageQuery, fn func(message WeatherMessage) bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var after *positionedMessage
+	for {
+		chunk, err := r.selectChunk(ctx, query, after)
+		if err != nil {
+			return err
+		}
+
+		for _, selected := range chunk {
+			message, err := r.decode(selected.stored)
+			if err != nil {
+				return err
+			}
+
+			if !fn(message) {
+				return nil
+			}
+		}
+
+		if len(chunk) < r.chunk {
+			return nil
+		}
+		after = &chunk[len(chunk)-1]
+	}
+}
+
+// positionedMessage is a saved message and its line in the messages
+// file, which orders messages saved with the same time.
+type positionedMessage struct {
+	stored storedMessage
+	line   int
+}
+
+// before returns true if the message is streamed before the other.
+func (p positionedMessage) before(other positionedMessage) bool {
+	if !p.stored.Time.Equal(other.stored.Time) {
+		return p.stored.Time.Before(other.stored.Time)
+	}
+
+	return p.line < other.line
+}
+
+// selectChunk returns the first messages selected by the query streamed
+// after the message, in streaming order and at most r.chunk of them.
+func (r *FileRepo) selectChunk(ctx context.Context, query MessageQuery, after *positionedMessage) ([]positionedMessage, error) {
+	// Keep the earliest messages, sorting and trimming whenever twice
+	// the chunk have been selected.
+	trim := func(selected []positionedMessage) []positionedMessage {
+		sort.Slice(selected, func(i, j int) bool {
+			return selected[i].before(selected[j])
+		})
+
+		return selected[:min(len(selected), r.chunk)]
+	}
+
+	selected := make([]positionedMessage, 0)
+	line := 0
+	err := r.scanMessages(ctx, func(stored storedMessage, _ []byte) error {
+		positioned := positionedMessage{stored: stored, line: line}
+		line++
+
+		if !stored.matches(query) || (after != nil && !after.before(positioned)) {
+			return nil
+		}
+
+		selected = append(selected, positioned)
+		if len(selected) >= 2*r.chunk {
+			selected = trim(selected)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return trim(selected), nil
+}
+
+// LatestMessages returns the latest saved message selected by the
+// query from each sensor, or from each hub for messages reported by
+// hubs themselves, ordered by serial number.
+func (r *FileRepo) LatestMessages(ctx context.Context, query MessageQuery) ([]WeatherMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	latest := make(map[string]storedMessage)
+	err := r.scanMessages(ctx, func(stored storedMessage, line []byte) error {
+		if !stored.matches(query) {
+			return nil
+		}
+
+		key := latestKey(stored.HubSerial, stored.SensorSerial)
+		if found, ok := latest[key]; !ok || !stored.Time.Before(found.Time) {
+			latest[key] = stored
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(latest))
+	for key := range latest {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	messages := make([]WeatherMessage, 0, len(keys))
+	for _, key := range keys {
+		message, err := r.decode(latest[key])
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+// SaveMessage appends the message to the messages file.
+func (r *FileRepo) SaveMessage(ctx context.Context, message WeatherMessage) error {
+	if _, ok := newMessage(message.Type()); !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedMessage, message.Type())
+	}
+
+	hub, sensor := messageSerials(message)
+	data, err := json.Marshal(withoutRecords(message))
+	if err != nil {
+		return fmt.Errorf("error encoding %s message: %w", message.Type(), err)
+	}
+
+	line, err := json.Marshal(storedMessage{
+		Type:         message.Type(),
+		Time:         message.Time(),
+		HubSerial:    hub,
+		SensorSerial: sensor,
+		Message:      data,
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding %s message: %w", message.Type(), err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.messages.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error saving message: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteMessages deletes the saved messages selected by the query by
+// rewriting the messages file without them. Every call that deletes
+// messages reads the whole file and holds the messages kept in memory
+// while rewriting it, so delete with as broad a query as possible
+// rather than a message at a time.
+func (r *FileRepo) DeleteMessages(ctx context.Context, query MessageQuery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var kept bytes.Buffer
+	deleted := false
+	err := r.scanMessages(ctx, func(stored storedMessage, line []byte) error {
+		if stored.matches(query) {
+			deleted = true
+			return nil
+		}
+
+		kept.Write(line)
+		kept.WriteByte('\n')
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !deleted {
+		return nil
+	}
+
+	if err := r.writeFile(fileRepoMessages, kept.Bytes()); err != nil {
+		return err
+	}
+
+	// Reopen the messages file replaced by the rewrite.
+	messages, err := os.OpenFile(r.path(fileRepoMessages), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening messages: %w", err)
+	}
+
+	r.messages.Close()
+	r.messages = messages
+
+	return nil
+}
+
+// scanMessages calls fn with each saved message and its line. A last
+// line without a newline is a save in progress and is skipped.
+func (r *FileRepo) scanMessages(ctx context.Context, fn func(stored storedMessage, line []byte) error) error {
+	file, err := os.Open(r.path(fileRepoMessages))
+	if err != nil {
+		return fmt.Errorf("error opening messages: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading messages: %w", err)
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var stored storedMessage
+		if err := json.Unmarshal(line, &stored); err != nil {
+			return fmt.Errorf("error reading messages: %w", err)
+		}
+
+		if err := fn(stored, line); err != nil {
+			return err
+		}
+	}
+}
+
+// matches returns true if the saved message is selected by the query.
+func (s storedMessage) matches(query MessageQuery) bool {
+	return query.match(s.Type, s.Time, s.HubSerial, s.SensorSerial)
+}
+
+// decode returns the saved message with the saved records of its hub
+// and sensor.
+func (r *FileRepo) decode(stored storedMessage) (WeatherMessage, error) {
+	message, ok := newMessage(stored.Type)
+	if !ok {
+		return nil, fmt.Errorf("cannot load %s messages", stored.Type)
+	}
+
+	if err := json.Unmarshal(stored.Message, message); err != nil {
+		return nil, fmt.Errorf("error decoding saved %s message: %w", stored.Type, err)
+	}
+
+	var hub *Hub
+	if stored.HubSerial != "" {
+		hub = r.hub(stored.HubSerial)
+	}
+
+	var sensor *WeatherSensor
+	if stored.SensorSerial != "" {
+		sensor = r.sensor(stored.SensorSerial, hub)
+	}
+
+	switch m := message.(type) {
+	case *RapidWindEvent:
+		m.Hub, m.Sensor = hub, sensor
+	case *LightningStrikeEvent:
+		m.Hub, m.Sensor = hub, sensor
+	case *RainStartEvent:
+		m.Hub, m.Sensor = hub, sensor
+	case *DeviceStatus:
+		m.Hub, m.Sensor = hub, sensor
+	case *HubStatus:
+		m.Hub = hub
+	}
+
+	return message, nil
+}
+
+// hub returns a copy of the saved hub with the serial number, or a hub
+// with only the serial number if it has not been saved.
+func (r *FileRepo) hub(serial string) *Hub {
+	if hub, ok := r.hubs[serial]; ok {
+		return hub.clone()
+	}
+
+	return &Hub{HubSerialNumber: serial}
+}
+
+// sensor returns a copy of the saved sensor with the serial number,
+// falling back to the hub's record of the sensor, or a sensor with only
+// the serial number if neither has been saved.
+func (r *FileRepo) sensor(serial string, hub *Hub) *WeatherSensor {
+	sensor, ok := r.sensors[serial]
+	if !ok && hub != nil {
+		sensor, ok = hub.WeatherSensors[serial]
+	}
+
+	if !ok {
+		return &WeatherSensor{SensorSerial: serial}
+	}
+
+	sensorCopy := *sensor
+	return &sensorCopy
+}
+
+// withoutRecords returns a copy of the message without the hub and
+// sensor records it refers to, which are saved separately.
+func withoutRecords(message WeatherMessage) WeatherMessage {
+	switch m := message.(type) {
+	case *RapidWindEvent:
+		event := *m
+		event.Hub, event.Sensor = nil, nil
+		return &event
+	case *LightningStrikeEvent:
+		event := *m
+		event.Hub, event.Sensor = nil, nil
+		return &event
+	case *RainStartEvent:
+		event := *m
+		event.Hub, event.Sensor = nil, nil
+		return &event
+	case *DeviceStatus:
+		status := *m
+		status.Hub, status.Sensor = nil, nil
+		return &status
+	case *HubStatus:
+		status := *m
+		status.Hub = nil
+		return &status
+	default:
+		return message
+	}
+}
+
+// newMessage returns an empty message of the type to decode a saved
+// message into.
+func newMessage(t Type) (WeatherMessage, bool) {
+	switch t {
+	case MessageTypeRainStartEvent:
+		return &RainStartEvent{}, true
+	case MessageTypeLightningStrike:
+		return &LightningStrikeEvent{}, true
+	case MessageTypeRapidWind:
+		return &RapidWindEvent{}, true
+	case MessageTypeObservation:
+		return &Observation{}, true
+	case MessageTypeAirObservation:
+		return &AirObservation{}, true
+	case MessageTypeSkyObservation:
+		return &SkyObservation{}, true
+	case MessageTypeDeviceStatus:
+		return &DeviceStatus{}, true
+	case MessageTypeHubStatus:
+		return &HubStatus{}, true
+	case MessageTypeFiveMinuteAggregate, MessageTypeHourlyAggregate, MessageTypeDailyAggregate:
+		return &Aggregate{}, true
+	default:
+		return nil, false
+	}
+}
+
+// path returns the path of the repo file.
+func (r *FileRepo) path(name string) string {
+	return filepath.Join(r.dir, name)
+}
+
+// readJSON decodes the repo file into v. A missing file is left empty.
+func (r *FileRepo) readJSON(name string, v interface{}) error {
+	data, err := os.ReadFile(r.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", name, err)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("error decoding %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// writeJSON replaces the repo file with v encoded as JSON.
+func (r *FileRepo) writeJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding %s: %w", name, err)
+	}
+
+	return r.writeFile(name, data)
+}
+
+// writeFile replaces the repo file with the data. The data is written
+// and synced to a temporary file renamed over the file, and the rename
+// synced, so a crash leaves either the old or the new file.
+func (r *FileRepo) writeFile(name string, data []byte) error {
+	temp, err := os.CreateTemp(r.dir, name+".*")
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+
+	if err := os.Rename(temp.Name(), r.path(name)); err != nil {
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+	syncDir(r.dir)
+
+	return nil
+}
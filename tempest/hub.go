@@ -23,6 +23,15 @@ type Hub struct {
 	// Frequency of the hub's radio.
 	RadioFrequency int `json:"radio_frequency"` // MHz
 
+	// Radio statistics from the hub's last status message.
+	RadioStats RadioStats `json:"radio_stats"`
+
+	// Signal strength of the hub's WiFi connection.
+	RSSI int `json:"rssi"` // dBm
+
 	// Time the hub was last seen on the network.
 	LastReported time.Time `json:"report_time"`
+
+	// True while the hub is reporting on the network.
+	Online bool `json:"online"`
 }
@@ -1,9 +1,215 @@
 package tempest
 
-import "sync"
+import (
+	"net"
+	"sort"
+	"sync"
+)
 
 // HubManager manages Tempest hubs on a network.
+//
+// HubManager is safe for concurrent use. Hub and sensor records are
+// never modified once stored: updates store a new record, so records
+// returned by UpdateHub and UpdateSensor may be shared read-only with
+// other goroutines. Hub, Hubs, Sensor and Sensors return copies the
+// caller may modify.
 type HubManager struct {
-	Hubs map[string]Hub // Map of hub serial numbers to hubs.
-	mu   sync.RWMutex   // Mutex to protect the map.
+	hubs map[string]*Hub // Map of hub serial numbers to hubs.
+	mu   sync.RWMutex    // Mutex to protect the map.
+}
+
+// NewHubManager returns a hub manager without hubs.
+func NewHubManager() *HubManager {
+	return &HubManager{
+		hubs: make(map[string]*Hub),
+	}
+}
+
+// AddHub adds a copy of the hub and its sensors, replacing any hub
+// with the same serial number.
+func (m *HubManager) AddHub(hub *Hub) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.hubs[hub.HubSerialNumber] = hub.clone()
+}
+
+// UpdateHub applies update to a copy of the hub and stores the copy.
+// The hub is added if it is not found. The stored hub is returned.
+func (m *HubManager) UpdateHub(serialNumber string, update func(hub *Hub)) *Hub {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hub := m.copyHub(serialNumber)
+	if update != nil {
+		update(hub)
+	}
+
+	m.hubs[serialNumber] = hub
+
+	return hub
+}
+
+// UpdateSensor applies update to a copy of the sensor and stores the
+// copy on the hub. The hub and sensor are added if they are not found.
+// The stored hub and sensor are returned.
+func (m *HubManager) UpdateSensor(hubSerial string, sensorSerial string, update func(sensor *WeatherSensor)) (*Hub, *WeatherSensor) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hub := m.copyHub(hubSerial)
+
+	sensor := &WeatherSensor{
+		SensorSerial: sensorSerial,
+	}
+	if existing, found := hub.WeatherSensors[sensorSerial]; found {
+		*sensor = *existing
+	}
+
+	if update != nil {
+		update(sensor)
+	}
+
+	hub.WeatherSensors[sensorSerial] = sensor
+	m.hubs[hubSerial] = hub
+
+	return hub, sensor
+}
+
+// Hub returns a copy of the hub with the serial number.
+func (m *HubManager) Hub(serialNumber string) (*Hub, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hub, found := m.hubs[serialNumber]
+	if !found {
+		return nil, false
+	}
+
+	return hub.clone(), true
+}
+
+// Hubs returns copies of all hubs ordered by serial number.
+func (m *HubManager) Hubs() []*Hub {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hubs := make([]*Hub, 0, len(m.hubs))
+	for _, hub := range m.hubs {
+		hubs = append(hubs, hub.clone())
+	}
+
+	sort.Slice(hubs, func(i, j int) bool {
+		return hubs[i].HubSerialNumber < hubs[j].HubSerialNumber
+	})
+
+	return hubs
+}
+
+// RemoveHub removes the hub and its sensors. It returns false if the
+// hub was not found.
+func (m *HubManager) RemoveHub(serialNumber string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, found := m.hubs[serialNumber]; !found {
+		return false
+	}
+
+	delete(m.hubs, serialNumber)
+
+	return true
+}
+
+// Sensor returns a copy of the sensor on the hub.
+func (m *HubManager) Sensor(hubSerial string, sensorSerial string) (*WeatherSensor, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hub, found := m.hubs[hubSerial]
+	if !found {
+		return nil, false
+	}
+
+	sensor, found := hub.WeatherSensors[sensorSerial]
+	if !found {
+		return nil, false
+	}
+
+	sensorCopy := *sensor
+
+	return &sensorCopy, true
+}
+
+// Sensors returns copies of the hub's sensors ordered by serial number.
+func (m *HubManager) Sensors(hubSerial string) []*WeatherSensor {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sensors := make([]*WeatherSensor, 0)
+
+	hub, found := m.hubs[hubSerial]
+	if !found {
+		return sensors
+	}
+
+	for _, sensor := range hub.WeatherSensors {
+		sensorCopy := *sensor
+		sensors = append(sensors, &sensorCopy)
+	}
+
+	sort.Slice(sensors, func(i, j int) bool {
+		return sensors[i].SensorSerial < sensors[j].SensorSerial
+	})
+
+	return sensors
+}
+
+// RemoveSensor removes the sensor from the hub. It returns false if
+// the sensor was not found.
+func (m *HubManager) RemoveSensor(hubSerial string, sensorSerial string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hub, found := m.hubs[hubSerial]
+	if !found {
+		return false
+	}
+
+	if _, found := hub.WeatherSensors[sensorSerial]; !found {
+		return false
+	}
+
+	hub = hub.clone()
+	delete(hub.WeatherSensors, sensorSerial)
+	m.hubs[hubSerial] = hub
+
+	return true
+}
+
+// copyHub returns a copy of the stored hub or a new hub if the hub is
+// not found. The caller must hold the lock.
+func (m *HubManager) copyHub(serialNumber string) *Hub {
+	if hub, found := m.hubs[serialNumber]; found {
+		return hub.clone()
+	}
+
+	return &Hub{
+		HubSerialNumber: serialNumber,
+		WeatherSensors:  make(map[string]*WeatherSensor),
+	}
+}
+
+// clone returns a copy of the hub and its sensors.
+func (h *Hub) clone() *Hub {
+	hub := *h
+	hub.IPAddress = append(net.IP(nil), h.IPAddress...)
+	hub.WeatherSensors = make(map[string]*WeatherSensor, len(h.WeatherSensors))
+
+	for serial, sensor := range h.WeatherSensors {
+		sensorCopy := *sensor
+		hub.WeatherSensors[serial] = &sensorCopy
+	}
+
+	return &hub
 }
@@ -1,7 +1,144 @@
 package tempest
 
-import "testing"
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+)
 
 func TestHubManager_Update(t *testing.T) {
+	m := NewHubManager()
 
+	hub := m.UpdateHub("HB-00000001", func(hub *Hub) {
+		hub.IPAddress = net.IPv4(192, 168, 1, 2)
+	})
+
+	if hub.HubSerialNumber != "HB-00000001" || !hub.IPAddress.Equal(net.IPv4(192, 168, 1, 2)) {
+		t.Errorf("unexpected hub: %+v", hub)
+	}
+
+	updated, sensor := m.UpdateSensor("HB-00000001", "ST-00000512", func(sensor *WeatherSensor) {
+		sensor.Coordinate.Elevation = 100
+	})
+
+	if updated == hub {
+		t.Errorf("expected updates to store a new hub record")
+	}
+
+	if updated.WeatherSensors["ST-00000512"] != sensor {
+		t.Errorf("expected the stored hub to reference the stored sensor")
+	}
+
+	if !updated.IPAddress.Equal(hub.IPAddress) {
+		t.Errorf("expected hub fields to be kept when adding a sensor")
+	}
+
+	if len(hub.WeatherSensors) != 0 {
+		t.Errorf("expected previous hub record to be unchanged")
+	}
+
+	_, sensor = m.UpdateSensor("HB-00000001", "ST-00000512", nil)
+	if sensor.Coordinate.Elevation != 100 {
+		t.Errorf("expected sensor fields to be kept: %+v", sensor)
+	}
+}
+
+func TestHubManager_UpdateSensorAddsHub(t *testing.T) {
+	m := NewHubManager()
+	m.UpdateSensor("HB-00000001", "ST-00000512", nil)
+
+	if _, found := m.Hub("HB-00000001"); !found {
+		t.Errorf("expected hub to be added with the sensor")
+	}
+
+	if _, found := m.Sensor("HB-00000001", "ST-00000512"); !found {
+		t.Errorf("expected sensor to be added")
+	}
+}
+
+func TestHubManager_GetReturnsCopies(t *testing.T) {
+	m := NewHubManager()
+	m.UpdateSensor("HB-00000001", "ST-00000512", nil)
+
+	hub, _ := m.Hub("HB-00000001")
+	hub.FirmwareVersion = "changed"
+	hub.WeatherSensors["ST-00000512"].Coordinate.Elevation = 100
+	delete(hub.WeatherSensors, "ST-00000512")
+
+	stored, _ := m.Hub("HB-00000001")
+	if stored.FirmwareVersion != "" {
+		t.Errorf("expected hub copy changes to not be stored")
+	}
+
+	sensor, found := m.Sensor("HB-00000001", "ST-00000512")
+	if !found || sensor.Coordinate.Elevation != 0 {
+		t.Errorf("expected sensor copy changes to not be stored")
+	}
+}
+
+func TestHubManager_ListAndRemove(t *testing.T) {
+	m := NewHubManager()
+	m.UpdateSensor("HB-00000002", "ST-00000002", nil)
+	m.UpdateSensor("HB-00000002", "ST-00000001", nil)
+	m.AddHub(&Hub{HubSerialNumber: "HB-00000001"})
+
+	hubs := m.Hubs()
+	if len(hubs) != 2 || hubs[0].HubSerialNumber != "HB-00000001" || hubs[1].HubSerialNumber != "HB-00000002" {
+		t.Fatalf("unexpected hubs: %v", hubs)
+	}
+
+	sensors := m.Sensors("HB-00000002")
+	if len(sensors) != 2 || sensors[0].SensorSerial != "ST-00000001" {
+		t.Fatalf("unexpected sensors: %v", sensors)
+	}
+
+	if !m.RemoveSensor("HB-00000002", "ST-00000001") || m.RemoveSensor("HB-00000002", "ST-00000001") {
+		t.Errorf("expected the sensor to be removed once")
+	}
+
+	if len(m.Sensors("HB-00000002")) != 1 {
+		t.Errorf("expected one sensor after removal")
+	}
+
+	if !m.RemoveHub("HB-00000001") || m.RemoveHub("HB-00000001") {
+		t.Errorf("expected the hub to be removed once")
+	}
+
+	if len(m.Hubs()) != 1 {
+		t.Errorf("expected one hub after removal")
+	}
+}
+
+func TestHubManager_Concurrent(t *testing.T) {
+	m := NewHubManager()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				m.UpdateSensor(fmt.Sprintf("HB-%08d", i), fmt.Sprintf("ST-%08d", j), nil)
+			}
+		}(i)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				for _, hub := range m.Hubs() {
+					_ = len(hub.WeatherSensors)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if len(m.Hubs()) != 4 || len(m.Sensors("HB-00000000")) != 100 {
+		t.Errorf("unexpected registry size")
+	}
 }
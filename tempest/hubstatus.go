@@ -0,0 +1,240 @@
+package tempest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResetFlags is the set of reasons reported for the hub's last reset.
+type ResetFlags uint8
+
+// Hub reset flags.
+const (
+	ResetBrownout       ResetFlags = 1 << iota // BOR
+	ResetPin                                   // PIN
+	ResetPower                                 // POR
+	ResetSoftware                              // SFT
+	ResetWatchdog                              // WDG
+	ResetWindowWatchdog                        // WWD
+	ResetLowPower                              // LPW
+	ResetHardFault                             // HFT
+)
+
+// resetFlagNames is the order and abbreviation of each reset flag.
+var resetFlagNames = []struct {
+	flag ResetFlags
+	name string
+}{
+	{ResetBrownout, "BOR"},
+	{ResetPin, "PIN"},
+	{ResetPower, "POR"},
+	{ResetSoftware, "SFT"},
+	{ResetWatchdog, "WDG"},
+	{ResetWindowWatchdog, "WWD"},
+	{ResetLowPower, "LPW"},
+	{ResetHardFault, "HFT"},
+}
+
+// ParseResetFlags parses a comma separated list of reset flag
+// abbreviations (e.g. "BOR,PIN,POR").
+func ParseResetFlags(s string) (ResetFlags, error) {
+	var flags ResetFlags
+
+	for _, abbreviation := range strings.Split(s, ",") {
+		abbreviation = strings.TrimSpace(abbreviation)
+		if abbreviation == "" {
+			continue
+		}
+
+		found := false
+		for _, reset := range resetFlagNames {
+			if reset.name == abbreviation {
+				flags |= reset.flag
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return flags, fmt.Errorf("unknown reset flag: %s", abbreviation)
+		}
+	}
+
+	return flags, nil
+}
+
+// Has returns true if every bit of flag is set.
+func (r ResetFlags) Has(flag ResetFlags) bool {
+	return r&flag == flag
+}
+
+// String returns the reset flag abbreviations separated by commas.
+func (r ResetFlags) String() string {
+	names := make([]string, 0)
+	for _, reset := range resetFlagNames {
+		if r.Has(reset.flag) {
+			names = append(names, reset.name)
+		}
+	}
+
+	return strings.Join(names, ",")
+}
+
+// RadioStatus is the state of the hub's radio.
+type RadioStatus int
+
+// Hub radio states.
+const (
+	RadioOff          RadioStatus = 0
+	RadioOn           RadioStatus = 1
+	RadioActive       RadioStatus = 3
+	RadioBLEConnected RadioStatus = 7
+)
+
+// String returns the name of the radio status.
+func (r RadioStatus) String() string {
+	switch r {
+	case RadioOff:
+		return "off"
+	case RadioOn:
+		return "on"
+	case RadioActive:
+		return "active"
+	case RadioBLEConnected:
+		return "ble connected"
+	}
+
+	return fmt.Sprintf("unknown (%d)", int(r))
+}
+
+// RadioStats are the hub radio statistics reported in a hub status
+// message.
+type RadioStats struct {
+	Version     int         `json:"version"`      // Radio stats version.
+	RebootCount int         `json:"reboot_count"` // Number of radio reboots.
+	I2CErrors   int         `json:"i2c_errors"`   // I2C bus error count.
+	Status      RadioStatus `json:"status"`       // Radio status.
+	NetworkID   int         `json:"network_id"`   // Radio network ID.
+}
+
+const (
+	radioStatsIndexVersion int = iota
+	radioStatsIndexRebootCount
+	radioStatsIndexI2CErrors
+	radioStatsIndexStatus
+	radioStatsIndexNetworkID
+)
+
+// HubStatus represents a hub status message reported by a hub to
+// the network.
+type HubStatus struct {
+	Hub              *Hub          // The hub reporting the status to the network.
+	FirmwareRevision string        // Hub firmware revision.
+	Uptime           time.Duration // Time since the hub was last reset.
+	RSSI             int           // Signal strength of the hub's WiFi connection (dBm).
+	Timestamp        time.Time     // Timestamp(Epoch UTC) of the status.
+	ResetFlags       ResetFlags    // Reasons for the hub's last reset.
+	Seq              int           // Sequence number of the status message.
+	RadioStats       RadioStats    // Hub radio statistics.
+}
+
+// NewHubStatus creates a new hub status with a hub status raw
+// message from the hub.
+func NewHubStatus(raw RawMessage, hub *Hub) (*HubStatus, error) {
+	status := &HubStatus{
+		Hub: hub,
+	}
+
+	// The hub reports its firmware revision as a string.
+	switch firmware := raw["firmware_revision"].(type) {
+	case string:
+		status.FirmwareRevision = firmware
+	case float64:
+		status.FirmwareRevision = strconv.Itoa(int(firmware))
+	default:
+		return nil, fmt.Errorf("hub status firmware_revision not found")
+	}
+
+	uptime, err := raw.number("uptime")
+	if err != nil {
+		return nil, fmt.Errorf("hub status %w", err)
+	}
+	status.Uptime = time.Duration(uptime) * time.Second
+
+	rssi, err := raw.number("rssi")
+	if err != nil {
+		return nil, fmt.Errorf("hub status %w", err)
+	}
+	status.RSSI = int(rssi)
+
+	timestamp, err := raw.number("timestamp")
+	if err != nil {
+		return nil, fmt.Errorf("hub status %w", err)
+	}
+	status.Timestamp = time.Unix(int64(timestamp), 0)
+
+	resetFlags, ok := raw["reset_flags"].(string)
+	if !ok {
+		return nil, fmt.Errorf("hub status reset_flags not found")
+	}
+	status.ResetFlags, err = ParseResetFlags(resetFlags)
+	if err != nil {
+		return nil, fmt.Errorf("hub status %w", err)
+	}
+
+	seq, err := raw.number("seq")
+	if err != nil {
+		return nil, fmt.Errorf("hub status %w", err)
+	}
+	status.Seq = int(seq)
+
+	rawStats, ok := raw["radio_stats"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("hub status radio_stats not found")
+	}
+
+	if len(rawStats) <= radioStatsIndexNetworkID {
+		return nil, fmt.Errorf("hub status radio_stats does not have %d elements", radioStatsIndexNetworkID+1)
+	}
+
+	stats := make([]int, len(rawStats))
+	for i, stat := range rawStats {
+		value, ok := stat.(float64)
+		if !ok {
+			return nil, fmt.Errorf("hub status radio_stats element %d is not a number", i)
+		}
+		stats[i] = int(value)
+	}
+
+	status.RadioStats = RadioStats{
+		Version:     stats[radioStatsIndexVersion],
+		RebootCount: stats[radioStatsIndexRebootCount],
+		I2CErrors:   stats[radioStatsIndexI2CErrors],
+		Status:      RadioStatus(stats[radioStatsIndexStatus]),
+		NetworkID:   stats[radioStatsIndexNetworkID],
+	}
+
+	return status, nil
+}
+
+// updateHub updates the hub's firmware and radio details from the
+// status.
+func (h *HubStatus) updateHub(hub *Hub) {
+	hub.FirmwareVersion = h.FirmwareRevision
+	hub.RadioStats = h.RadioStats
+	hub.RSSI = h.RSSI
+
+	h.Hub = hub
+}
+
+// Type returns the message type for the hub status.
+func (h *HubStatus) Type() Type {
+	return MessageTypeHubStatus
+}
+
+// Time returns the time of the hub status.
+func (h *HubStatus) Time() time.Time {
+	return h.Timestamp
+}
@@ -0,0 +1,63 @@
+package tempest
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNetwork_DecodeHubStatus(t *testing.T) {
+	n := NewNetwork("test")
+	msg := testNetworkMessage(t, `{"serial_number":"HB-00000001","type":"hub_status","firmware_revision":"35","uptime":1670133,"rssi":-62,"timestamp":1495724691,"reset_flags":"BOR,PIN,POR","seq":48,"fs":[1,0,15675411,524288],"radio_stats":[2,1,0,3,2839],"mqtt_stats":[1,0]}`)
+
+	decoded, err := n.decode(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("error decoding hub status: %v", err)
+	}
+
+	status, ok := decoded.(*HubStatus)
+	if !ok {
+		t.Fatalf("unexpected message: %T", decoded)
+	}
+
+	if status.FirmwareRevision != "35" || status.RSSI != -62 || status.Seq != 48 {
+		t.Errorf("unexpected hub status: %+v", status)
+	}
+
+	if status.Uptime != 1670133*time.Second {
+		t.Errorf("unexpected uptime: %v", status.Uptime)
+	}
+
+	if !status.Time().Equal(time.Unix(1495724691, 0)) {
+		t.Errorf("unexpected timestamp: %v", status.Time())
+	}
+
+	if status.ResetFlags != ResetBrownout|ResetPin|ResetPower {
+		t.Errorf("unexpected reset flags: %s", status.ResetFlags)
+	}
+
+	want := RadioStats{Version: 2, RebootCount: 1, I2CErrors: 0, Status: RadioActive, NetworkID: 2839}
+	if status.RadioStats != want {
+		t.Errorf("unexpected radio stats: %+v", status.RadioStats)
+	}
+
+	hub := n.hubs.hubs["HB-00000001"]
+	if hub.FirmwareVersion != "35" || hub.RadioStats != want || hub.RSSI != -62 {
+		t.Errorf("hub not updated from status: %+v", hub)
+	}
+}
+
+func TestParseResetFlags(t *testing.T) {
+	flags, err := ParseResetFlags("SFT,WDG,WWD,LPW,HFT")
+	if err != nil {
+		t.Fatalf("error parsing reset flags: %v", err)
+	}
+
+	if flags.String() != "SFT,WDG,WWD,LPW,HFT" {
+		t.Errorf("unexpected reset flags: %s", flags)
+	}
+
+	if _, err := ParseResetFlags("BOR,XYZ"); err == nil {
+		t.Errorf("expected an error for an unknown reset flag")
+	}
+}
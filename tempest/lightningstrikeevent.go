@@ -1,6 +1,13 @@
 package tempest
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	eventEvent = "evt"
+)
 
 // LightningStrikeEvent represents a lightning strike event reported by
 // a sensor to the hub.
@@ -11,3 +18,59 @@ type LightningStrikeEvent struct {
 	Distance  Distance       // Distance in kilometers from the sensor to the strike.
 	Energy    int            // Energy of the strike. There is no unit of measure for this value.
 }
+
+// NewLightningStrikeEvent creates a new lightning strike event with a
+// lightning strike raw message from the hub.
+func NewLightningStrikeEvent(raw RawMessage, sensor *WeatherSensor, hub *Hub) (*LightningStrikeEvent, error) {
+	event := &LightningStrikeEvent{
+		Sensor: sensor,
+		Hub:    hub,
+	}
+
+	rawEvt, found := raw[eventEvent]
+	if !found {
+		return nil, fmt.Errorf("lightning strike event not found")
+	}
+
+	evt, ok := rawEvt.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("lightning strike event is not a float64 slice")
+	}
+
+	if len(evt) != 3 {
+		return nil, fmt.Errorf("lightning strike event does not have 3 elements")
+	}
+
+	// The first element is the timestamp of the event.
+	if ts, ok := evt[0].(float64); ok {
+		event.EventTime = time.Unix(int64(ts), 0)
+	} else {
+		return nil, fmt.Errorf("lightning strike event timestamp cannot be converted to a float64")
+	}
+
+	// The second element is the distance to the strike.
+	if distance, ok := evt[1].(float64); ok {
+		event.Distance = NewDistance(distance, Kilometers)
+	} else {
+		return nil, fmt.Errorf("lightning strike event distance is not a float64")
+	}
+
+	// The third element is the energy of the strike.
+	if energy, ok := evt[2].(float64); ok {
+		event.Energy = int(energy)
+	} else {
+		return nil, fmt.Errorf("lightning strike event energy is not a float64")
+	}
+
+	return event, nil
+}
+
+// Type returns the message type for the lightning strike event.
+func (l *LightningStrikeEvent) Type() Type {
+	return MessageTypeLightningStrike
+}
+
+// Time returns the time of the lightning strike event.
+func (l *LightningStrikeEvent) Time() time.Time {
+	return l.EventTime
+}
@@ -1 +1,45 @@
 package tempest
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNetwork_DecodeLightningStrike(t *testing.T) {
+	n := NewNetwork("test")
+	msg := testNetworkMessage(t, `{"serial_number":"AR-00004049","type":"evt_strike","hub_sn":"HB-00000001","evt":[1493322445,27,3848]}`)
+
+	decoded, err := n.decode(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("error decoding lightning strike: %v", err)
+	}
+
+	event, ok := decoded.(*LightningStrikeEvent)
+	if !ok {
+		t.Fatalf("unexpected message: %T", decoded)
+	}
+
+	if !event.Time().Equal(time.Unix(1493322445, 0)) {
+		t.Errorf("unexpected event time: %v", event.Time())
+	}
+
+	if event.Distance.Kilometers() != 27 {
+		t.Errorf("unexpected distance: %f", event.Distance.Kilometers())
+	}
+
+	if event.Energy != 3848 {
+		t.Errorf("unexpected energy: %d", event.Energy)
+	}
+
+	if event.Sensor == nil || event.Sensor.SensorSerial != "AR-00004049" {
+		t.Errorf("unexpected sensor: %+v", event.Sensor)
+	}
+}
+
+func TestNewLightningStrikeEvent_Invalid(t *testing.T) {
+	raw := RawMessage{"evt": []interface{}{1493322445.0, 27.0}}
+	if _, err := NewLightningStrikeEvent(raw, nil, nil); err == nil {
+		t.Errorf("expected an error for a short event")
+	}
+}
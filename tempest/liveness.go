@@ -0,0 +1,232 @@
+package tempest
+
+import (
+	"context"
+	"time"
+)
+
+// Liveness message types. These messages are reported by the network
+// when hubs and sensors start or stop reporting; they are not sent by
+// hubs.
+const (
+	MessageTypeHubOnline     Type = "hub_online"
+	MessageTypeHubOffline    Type = "hub_offline"
+	MessageTypeSensorOnline  Type = "sensor_online"
+	MessageTypeSensorOffline Type = "sensor_offline"
+)
+
+// Liveness defaults.
+const (
+	// DefaultMissedReports is the number of report intervals a hub or
+	// sensor may miss before it is marked offline.
+	DefaultMissedReports = 3
+
+	// DefaultHubReportInterval is the interval hubs broadcast status.
+	DefaultHubReportInterval = 10 * time.Second
+
+	// DefaultSensorReportInterval is the interval sensors report
+	// observations until a sensor reports its own interval.
+	DefaultSensorReportInterval = time.Minute
+
+	// DefaultLivenessCheckInterval is how often hubs and sensors are
+	// checked for silence.
+	DefaultLivenessCheckInterval = time.Second
+)
+
+// Liveness configures when hubs and sensors are marked offline.
+// Zero fields use the defaults.
+type Liveness struct {
+	MissedReports        int           // Report intervals missed before marking offline.
+	HubReportInterval    time.Duration // Expected interval between hub reports.
+	SensorReportInterval time.Duration // Sensor report interval until reported by the sensor.
+	CheckInterval        time.Duration // How often to check for silent hubs and sensors.
+}
+
+// WithLiveness configures when the network marks hubs and sensors
+// offline.
+func WithLiveness(liveness Liveness) Option {
+	return func(n *Network) {
+		n.liveness = liveness.withDefaults()
+	}
+}
+
+// withDefaults returns the liveness with defaults for zero fields.
+func (l Liveness) withDefaults() Liveness {
+	if l.MissedReports <= 0 {
+		l.MissedReports = DefaultMissedReports
+	}
+
+	if l.HubReportInterval <= 0 {
+		l.HubReportInterval = DefaultHubReportInterval
+	}
+
+	if l.SensorReportInterval <= 0 {
+		l.SensorReportInterval = DefaultSensorReportInterval
+	}
+
+	if l.CheckInterval <= 0 {
+		l.CheckInterval = DefaultLivenessCheckInterval
+	}
+
+	return l
+}
+
+// hubTimeout returns how long the hub may be silent before it is
+// marked offline.
+func (l Liveness) hubTimeout() time.Duration {
+	return time.Duration(l.MissedReports) * l.HubReportInterval
+}
+
+// sensorTimeout returns how long the sensor may be silent before it
+// is marked offline.
+func (l Liveness) sensorTimeout(sensor *WeatherSensor) time.Duration {
+	interval := sensor.ReportInterval
+	if interval <= 0 {
+		interval = l.SensorReportInterval
+	}
+
+	return time.Duration(l.MissedReports) * interval
+}
+
+// HubOnline is reported when a hub is first seen or reports again
+// after being marked offline.
+type HubOnline struct {
+	Hub       *Hub      // The hub that came online.
+	EventTime time.Time // Time the hub reported.
+}
+
+// Type returns the message type for the hub online event.
+func (h *HubOnline) Type() Type {
+	return MessageTypeHubOnline
+}
+
+// Time returns the time the hub came online.
+func (h *HubOnline) Time() time.Time {
+	return h.EventTime
+}
+
+// HubOffline is reported when a hub has been silent for longer than
+// its allowed number of missed reports.
+type HubOffline struct {
+	Hub       *Hub      // The hub that went offline.
+	EventTime time.Time // Time the hub was marked offline.
+}
+
+// Type returns the message type for the hub offline event.
+func (h *HubOffline) Type() Type {
+	return MessageTypeHubOffline
+}
+
+// Time returns the time the hub was marked offline.
+func (h *HubOffline) Time() time.Time {
+	return h.EventTime
+}
+
+// SensorOnline is reported when a sensor is first seen or reports
+// again after being marked offline.
+type SensorOnline struct {
+	Hub       *Hub           // The hub reporting the sensor.
+	Sensor    *WeatherSensor // The sensor that came online.
+	EventTime time.Time      // Time the sensor reported.
+}
+
+// Type returns the message type for the sensor online event.
+func (s *SensorOnline) Type() Type {
+	return MessageTypeSensorOnline
+}
+
+// Time returns the time the sensor came online.
+func (s *SensorOnline) Time() time.Time {
+	return s.EventTime
+}
+
+// SensorOffline is reported when a sensor has been silent for longer
+// than its allowed number of missed reports.
+type SensorOffline struct {
+	Hub       *Hub           // The hub the sensor last reported through.
+	Sensor    *WeatherSensor // The sensor that went offline.
+	EventTime time.Time      // Time the sensor was marked offline.
+}
+
+// Type returns the message type for the sensor offline event.
+func (s *SensorOffline) Type() Type {
+	return MessageTypeSensorOffline
+}
+
+// Time returns the time the sensor was marked offline.
+func (s *SensorOffline) Time() time.Time {
+	return s.EventTime
+}
+
+// sensorUpdater is implemented by messages that update the sensor
+// that reported them.
+type sensorUpdater interface {
+	// updateSensor updates the sensor with the message.
+	updateSensor(sensor *WeatherSensor)
+}
+
+// watchLiveness marks silent hubs and sensors offline until the
+// context is cancelled.
+func (n *Network) watchLiveness(ctx context.Context, saveCtx context.Context) {
+	ticker := time.NewTicker(n.liveness.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, msg := range n.hubs.markOffline(now, n.liveness) {
+				n.logEvent(msg)
+				n.saveEvent(saveCtx, msg)
+				n.subscriptions.publish(msg)
+			}
+		}
+	}
+}
+
+// markOffline marks online hubs and sensors that have been silent
+// longer than allowed by the liveness offline and returns an offline
+// message for each.
+func (m *HubManager) markOffline(now time.Time, liveness Liveness) []WeatherMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	offline := make([]WeatherMessage, 0)
+
+	for serial, stored := range m.hubs {
+		var hub *Hub
+
+		// Copy the hub on the first change so stored records are
+		// never modified.
+		change := func() {
+			if hub == nil {
+				hub = stored.clone()
+				m.hubs[serial] = hub
+			}
+		}
+
+		for sensorSerial, sensor := range stored.WeatherSensors {
+			if sensor.Online && now.Sub(sensor.LastMessage) > liveness.sensorTimeout(sensor) {
+				change()
+				hub.WeatherSensors[sensorSerial].Online = false
+				offline = append(offline, &SensorOffline{
+					Hub:       hub,
+					Sensor:    hub.WeatherSensors[sensorSerial],
+					EventTime: now,
+				})
+			}
+		}
+
+		if stored.Online && now.Sub(stored.LastReported) > liveness.hubTimeout() {
+			change()
+			hub.Online = false
+			offline = append(offline, &HubOffline{
+				Hub:       hub,
+				EventTime: now,
+			})
+		}
+	}
+
+	return offline
+}
@@ -0,0 +1,82 @@
+package tempest
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// nextMessage returns the next message delivered to the subscription.
+func nextMessage(t *testing.T, sub *Subscription) WeatherMessage {
+	t.Helper()
+
+	select {
+	case msg := <-sub.Messages():
+		return msg
+	default:
+		t.Fatalf("expected a message")
+	}
+
+	return nil
+}
+
+func TestNetwork_Liveness(t *testing.T) {
+	n := NewNetwork("test", WithLiveness(Liveness{MissedReports: 2}))
+	sub := n.Subscribe(MessageTypeHubOnline, MessageTypeHubOffline, MessageTypeSensorOnline, MessageTypeSensorOffline)
+	defer sub.Unsubscribe()
+
+	obs := `{"serial_number":"ST-00146014","type":"obs_st","hub_sn":"HB-00149269","obs":[[1719767641,0.31,1.71,3.15,358,3,995.90,18.68,57.51,159176,12.46,1326,0.000000,0,0,0,2.755,5]],"firmware_revision":176}`
+	if _, err := n.decode(context.Background(), testNetworkMessage(t, obs)); err != nil {
+		t.Fatalf("error decoding observation: %v", err)
+	}
+
+	if msg := nextMessage(t, sub); msg.Type() != MessageTypeHubOnline {
+		t.Errorf("expected hub online, got %s", msg.Type())
+	}
+
+	if msg := nextMessage(t, sub); msg.Type() != MessageTypeSensorOnline {
+		t.Errorf("expected sensor online, got %s", msg.Type())
+	}
+
+	// A second message from an online hub and sensor is not reported.
+	if _, err := n.decode(context.Background(), testNetworkMessage(t, obs)); err != nil {
+		t.Fatalf("error decoding observation: %v", err)
+	}
+
+	if len(sub.Messages()) != 0 {
+		t.Errorf("unexpected liveness messages for online hub and sensor")
+	}
+
+	sensor, _ := n.hubs.Sensor("HB-00149269", "ST-00146014")
+	if sensor.ReportInterval != 5*time.Minute {
+		t.Errorf("unexpected report interval: %v", sensor.ReportInterval)
+	}
+
+	// The hub times out after 2 x 10s, the sensor after 2 x 5m.
+	offline := n.hubs.markOffline(sensor.LastMessage.Add(time.Minute), n.liveness)
+	if len(offline) != 1 || offline[0].Type() != MessageTypeHubOffline {
+		t.Fatalf("expected only the hub to be offline: %v", offline)
+	}
+
+	offline = n.hubs.markOffline(sensor.LastMessage.Add(11*time.Minute), n.liveness)
+	if len(offline) != 1 || offline[0].Type() != MessageTypeSensorOffline {
+		t.Fatalf("expected only the sensor to be offline: %v", offline)
+	}
+
+	if hub, _ := n.hubs.Hub("HB-00149269"); hub.Online || hub.WeatherSensors["ST-00146014"].Online {
+		t.Errorf("expected hub and sensor to be offline")
+	}
+
+	// Reporting again brings the hub and sensor back online.
+	if _, err := n.decode(context.Background(), testNetworkMessage(t, obs)); err != nil {
+		t.Fatalf("error decoding observation: %v", err)
+	}
+
+	if msg := nextMessage(t, sub); msg.Type() != MessageTypeHubOnline {
+		t.Errorf("expected hub online, got %s", msg.Type())
+	}
+
+	if msg := nextMessage(t, sub); msg.Type() != MessageTypeSensorOnline {
+		t.Errorf("expected sensor online, got %s", msg.Type())
+	}
+}
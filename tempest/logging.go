@@ -0,0 +1,80 @@
+package tempest
+
+import (
+	"context"
+	"log/slog"
+	"net"
+)
+
+// Keys of structured log attributes. Message fields are logged under
+// the keys hubs use for them, such as hub_sn and serial_number.
+const (
+	logKeySourceIP = "src_ip"
+	logKeySize     = "size"
+	logKeyError    = "error"
+)
+
+// WithLogger sets the logger the network reports diagnostics to.
+//
+// Every datagram received and message decoded is logged at debug
+// level. Dropped datagrams and messages are logged at warn level, and
+// hubs and sensors coming online or going offline at info level.
+// Networks log to slog.Default() unless configured otherwise.
+func WithLogger(logger *slog.Logger) Option {
+	return func(n *Network) {
+		if logger != nil {
+			n.logger = logger
+		}
+	}
+}
+
+// debugEnabled reports whether debug records are logged, so per
+// datagram attributes are only built when they are needed.
+func (n *Network) debugEnabled() bool {
+	return n.logger.Enabled(context.Background(), slog.LevelDebug)
+}
+
+// logAttrs returns attributes identifying the message and the hub and
+// sensor that reported it. Fields missing from the message are left
+// out.
+func (m networkMessage) logAttrs() []any {
+	attrs := []any{slog.String(logKeySourceIP, ipString(m.hubIp))}
+
+	if msgType, err := m.raw.Type(); err == nil {
+		attrs = append(attrs, slog.String(messageType, string(msgType)))
+	}
+
+	if hub, err := m.raw.HubSerial(); err == nil {
+		attrs = append(attrs, slog.String(hubSerial, hub))
+	}
+
+	if sensor, err := m.raw.SensorSerial(); err == nil {
+		attrs = append(attrs, slog.String(sensorSerial, sensor))
+	}
+
+	return attrs
+}
+
+// logEvent logs a liveness event.
+func (n *Network) logEvent(msg WeatherMessage) {
+	switch m := msg.(type) {
+	case *HubOnline:
+		n.logger.Info("hub online", slog.String(hubSerial, m.Hub.HubSerialNumber), slog.String(logKeySourceIP, ipString(m.Hub.IPAddress)))
+	case *HubOffline:
+		n.logger.Info("hub offline", slog.String(hubSerial, m.Hub.HubSerialNumber), slog.Time("last_reported", m.Hub.LastReported))
+	case *SensorOnline:
+		n.logger.Info("sensor online", slog.String(hubSerial, m.Hub.HubSerialNumber), slog.String(sensorSerial, m.Sensor.SensorSerial))
+	case *SensorOffline:
+		n.logger.Info("sensor offline", slog.String(hubSerial, m.Hub.HubSerialNumber), slog.String(sensorSerial, m.Sensor.SensorSerial), slog.Time("last_message", m.Sensor.LastMessage))
+	}
+}
+
+// ipString returns the IP address as a string, or an empty string
+// for a missing address.
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+
+	return ip.String()
+}
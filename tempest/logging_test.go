@@ -0,0 +1,99 @@
+package tempest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"testing"
+)
+
+// runLogged runs a network logging at the level over the payloads and
+// returns the decoded log records.
+func runLogged(t *testing.T, level slog.Level, payloads ...string) []map[string]interface{} {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buffer, &slog.HandlerOptions{Level: level}))
+
+	datagrams := make(chan Datagram, len(payloads))
+	for _, payload := range payloads {
+		datagrams <- Datagram{Data: []byte(payload), Source: net.IPv4(192, 168, 1, 2)}
+	}
+	close(datagrams)
+
+	n := NewNetwork("test", WithLogger(logger))
+	if err := n.RunSources(context.Background(), NewChannelSource(datagrams)); err != nil {
+		t.Fatal(err)
+	}
+
+	records := make([]map[string]interface{}, 0)
+	scanner := bufio.NewScanner(&buffer)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+
+	return records
+}
+
+// findRecord returns the first record with the message.
+func findRecord(records []map[string]interface{}, msg string) map[string]interface{} {
+	for _, record := range records {
+		if record["msg"] == msg {
+			return record
+		}
+	}
+
+	return nil
+}
+
+func TestNetwork_Logger(t *testing.T) {
+	records := runLogged(t, slog.LevelDebug, testRapidWind, `{"type":`)
+
+	decoded := findRecord(records, "decoded message")
+	if decoded == nil {
+		t.Fatalf("expected a decoded message record, got %v", records)
+	}
+
+	expected := map[string]interface{}{
+		"level":         "DEBUG",
+		"type":          "rapid_wind",
+		"hub_sn":        "HB-00013030",
+		"serial_number": "ST-00000512",
+		"src_ip":        "192.168.1.2",
+	}
+	for key, value := range expected {
+		if decoded[key] != value {
+			t.Errorf("expected %s %v, got %v", key, value, decoded[key])
+		}
+	}
+
+	if malformed := findRecord(records, "dropping malformed datagram"); malformed == nil || malformed["level"] != "WARN" || malformed["error"] == nil {
+		t.Errorf("expected a malformed datagram warning, got %v", malformed)
+	}
+
+	if online := findRecord(records, "hub online"); online == nil || online["level"] != "INFO" {
+		t.Errorf("expected a hub online record, got %v", online)
+	}
+}
+
+func TestNetwork_LoggerLevel(t *testing.T) {
+	records := runLogged(t, slog.LevelInfo, testRapidWind, testRapidWind)
+
+	for _, record := range records {
+		if record["level"] == "DEBUG" {
+			t.Errorf("unexpected debug record: %v", record)
+		}
+	}
+
+	// The hub and sensor come online once.
+	if len(records) != 2 {
+		t.Errorf("expected 2 records, got %v", records)
+	}
+}
@@ -0,0 +1,193 @@
+package tempest
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryRepo is a HubRepo, SensorRepo and MessageRepo keeping hubs,
+// sensors and messages in memory. It lets tests and short-lived
+// programs query what a network has seen without a database.
+//
+// MemoryRepo is safe for concurrent use.
+type MemoryRepo struct {
+	mu       sync.RWMutex
+	hubs     map[string]*Hub
+	sensors  map[string]*WeatherSensor
+	messages []WeatherMessage // Ordered by time.
+}
+
+// NewMemoryRepo returns an empty repo.
+func NewMemoryRepo() *MemoryRepo {
+	return &MemoryRepo{
+		hubs:    make(map[string]*Hub),
+		sensors: make(map[string]*WeatherSensor),
+	}
+}
+
+// LoadHubs returns copies of the saved hubs ordered by serial number.
+func (r *MemoryRepo) LoadHubs(ctx context.Context) ([]*Hub, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	hubs := make([]*Hub, 0, len(r.hubs))
+	for _, hub := range r.hubs {
+		hubs = append(hubs, hub.clone())
+	}
+
+	sort.Slice(hubs, func(i, j int) bool {
+		return hubs[i].HubSerialNumber < hubs[j].HubSerialNumber
+	})
+
+	return hubs, nil
+}
+
+// SaveHub saves a copy of the hub, replacing the hub with the same
+// serial number.
+func (r *MemoryRepo) SaveHub(ctx context.Context, hub *Hub) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.hubs[hub.HubSerialNumber] = hub.clone()
+
+	return nil
+}
+
+// LoadSensors returns copies of the saved sensors ordered by serial
+// number.
+func (r *MemoryRepo) LoadSensors(ctx context.Context) ([]*WeatherSensor, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sensors := make([]*WeatherSensor, 0, len(r.sensors))
+	for _, sensor := range r.sensors {
+		sensorCopy := *sensor
+		sensors = append(sensors, &sensorCopy)
+	}
+
+	sort.Slice(sensors, func(i, j int) bool {
+		return sensors[i].SensorSerial < sensors[j].SensorSerial
+	})
+
+	return sensors, nil
+}
+
+// SaveSensor saves a copy of the sensor, replacing the sensor with the
+// same serial number.
+func (r *MemoryRepo) SaveSensor(ctx context.Context, sensor *WeatherSensor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sensorCopy := *sensor
+	r.sensors[sensor.SensorSerial] = &sensorCopy
+
+	return nil
+}
+
+// StreamMessages calls fn with each saved message selected by the
+// query ordered by time, until fn returns false. The messages are
+// shared and must not be modified. The repo is locked for reading
+// while streaming, so fn must not save or delete messages.
+func (r *MemoryRepo) StreamMessages(ctx context.Context, query MessageQuery, fn func(message WeatherMessage) bool) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, message := range r.messages[r.search(query.Start):] {
+		if !query.End.IsZero() && !message.Time().Before(query.End) {
+			break
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if query.matches(message) && !fn(message) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// LatestMessages returns the latest saved message selected by the
+// query from each sensor, or from each hub for messages reported by
+// hubs themselves, ordered by serial number.
+func (r *MemoryRepo) LatestMessages(ctx context.Context, query MessageQuery) ([]WeatherMessage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	latest := make(map[string]WeatherMessage)
+	keys := make([]string, 0)
+	for i := len(r.messages) - 1; i >= 0; i-- {
+		message := r.messages[i]
+		if !query.matches(message) {
+			continue
+		}
+
+		key := latestKey(messageSerials(message))
+		if _, found := latest[key]; !found {
+			latest[key] = message
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	messages := make([]WeatherMessage, 0, len(keys))
+	for _, key := range keys {
+		messages = append(messages, latest[key])
+	}
+
+	return messages, nil
+}
+
+// SaveMessage saves the message. The message must not be modified
+// after it is saved.
+func (r *MemoryRepo) SaveMessage(ctx context.Context, message WeatherMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Messages normally arrive in order, so append unless the message
+	// is older than the newest saved.
+	i := len(r.messages)
+	if i > 0 && message.Time().Before(r.messages[i-1].Time()) {
+		i = sort.Search(len(r.messages), func(j int) bool {
+			return r.messages[j].Time().After(message.Time())
+		})
+	}
+
+	r.messages = append(r.messages, nil)
+	copy(r.messages[i+1:], r.messages[i:])
+	r.messages[i] = message
+
+	return nil
+}
+
+// DeleteMessages deletes the saved messages selected by the query.
+func (r *MemoryRepo) DeleteMessages(ctx context.Context, query MessageQuery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.messages[:0]
+	for _, message := range r.messages {
+		if !query.matches(message) {
+			kept = append(kept, message)
+		}
+	}
+
+	// Clear the tail so deleted messages can be collected.
+	for i := len(kept); i < len(r.messages); i++ {
+		r.messages[i] = nil
+	}
+	r.messages = kept
+
+	return nil
+}
+
+// search returns the index of the first message at or after the time.
+func (r *MemoryRepo) search(start time.Time) int {
+	return sort.Search(len(r.messages), func(i int) bool {
+		return !r.messages[i].Time().Before(start)
+	})
+}
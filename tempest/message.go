@@ -1,6 +1,13 @@
 package tempest
 
+import "time"
+
+// WeatherMessage is a decoded message reported by a hub or
+// one of its sensors. Subscribers receive WeatherMessages and
+// use a type switch to get at the concrete message type
+// (e.g. *RapidWindEvent or *Observation). WeatherMessage no
+// longer includes Read; see the package documentation.
 type WeatherMessage interface {
-	Type() Type
-	Read(rawMessage []byte) error
+	Type() Type      // Tempest message type.
+	Time() time.Time // Time the message was reported.
 }
@@ -1,11 +1,14 @@
 package tempest
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -24,18 +27,21 @@ type Network struct {
 	NetworkName string // Name of the network.
 
 	// Hubs on the network.
-	// The key is the hub serial number.
 	// The network will add hubs as messages are received.
-	hubs map[string]*Hub
+	hubs *HubManager
 
-	// UDP connection to the network.
-	udpConn *net.UDPConn
+	// Guards the state of the running network.
+	mu sync.Mutex
 
-	// Channel to stop the network.
-	stop chan struct{}
+	// Cancels the running network. Nil when the network is stopped.
+	cancel context.CancelFunc
 
-	// Channel to process raw messages.
-	networkMessage chan networkMessage
+	// Closed when the network last started has stopped. Nil until the
+	// network is started.
+	done chan struct{}
+
+	// Error that stopped the network, kept until Stop returns it.
+	err error
 
 	// MessageRepo to store messages.
 	messageRepo MessageRepo
@@ -45,6 +51,27 @@ type Network struct {
 
 	// HubRepo to store hubs.
 	hubRepo HubRepo
+
+	// Subscribers to decoded messages.
+	subscriptions *broker
+
+	// When hubs and sensors are marked offline.
+	liveness Liveness
+
+	// Queue of messages waiting to be decoded.
+	queue QueueConfig
+
+	// How long saved history is kept. Nil to keep everything.
+	retention *Retention
+
+	// Messages held back while saved history is rolled up.
+	rollUpHold rollUpHold
+
+	// Counters for datagrams received.
+	stats stats
+
+	// Logger for diagnostics.
+	logger *slog.Logger
 }
 
 // networkMessage is a message received on the network.
@@ -53,289 +80,484 @@ type networkMessage struct {
 	hubIp net.IP
 }
 
-// NewNetwork returns a new network with the given name.
-func NewNetwork(name string) *Network {
-	return &Network{
-		NetworkName: name,
-		hubs:        make(map[string]*Hub),
-		stop:        make(chan struct{}),
+// NewNetwork returns a new network with the given name
+// configured by the options.
+func NewNetwork(name string, options ...Option) *Network {
+	n := &Network{
+		NetworkName:   name,
+		hubs:          NewHubManager(),
+		subscriptions: newBroker(),
+		liveness:      Liveness{}.withDefaults(),
+		queue:         QueueConfig{}.withDefaults(),
+		logger:        slog.Default(),
+	}
+
+	for _, option := range options {
+		option(n)
 	}
+
+	return n
 }
 
+// HubManager returns the hubs and sensors on the network. The hub
+// manager is safe to use while the network is running.
+func (n *Network) HubManager() *HubManager {
+	return n.hubs
+}
+
+// Stats returns counters for the datagrams received by the network.
+func (n *Network) Stats() Stats {
+	return n.stats.snapshot()
+}
+
+// Subscribe returns a subscription to decoded messages of the given
+// types. All message types are delivered when no types are given.
+// The subscription buffers DefaultSubscriptionBuffer messages.
+func (n *Network) Subscribe(types ...Type) *Subscription {
+	return n.subscriptions.add(DefaultSubscriptionBuffer, types...)
+}
+
+// SubscribeBuffered is like Subscribe but buffers up to size messages
+// for the subscriber. Messages are dropped for the subscriber when the
+// buffer is full.
+func (n *Network) SubscribeBuffered(size int, types ...Type) *Subscription {
+	return n.subscriptions.add(size, types...)
+}
+
+// SubscribeBlocking is like SubscribeBuffered but never drops messages:
+// when the buffer is full the network waits for the subscriber, slowing
+// how fast it reads its sources. The subscriber must keep reading
+// messages until it unsubscribes, or the network stops processing.
+func (n *Network) SubscribeBlocking(size int, types ...Type) *Subscription {
+	return n.subscriptions.addBlocking(size, types...)
+}
+
+// ErrNetworkRunning is returned when starting a network that is
+// already running.
+var ErrNetworkRunning = errors.New("network already running")
+
 // Start the network activates the network to listen for
-// messages from the network.
-func (n *Network) Start(ip net.IP) error {
-	if err := n.connect(ip); err != nil {
+// messages broadcast by hubs on the interface with the given
+// IP address. Use net.IPv4zero to listen to all interfaces.
+//
+// The network runs until the context is cancelled, Stop is called
+// or listening fails. Stop returns the error that stopped the
+// network. A stopped network may be started again.
+func (n *Network) Start(ctx context.Context, ip net.IP) error {
+	// Check before binding, a running network holds the port.
+	if n.running() {
+		return ErrNetworkRunning
+	}
+
+	source, err := ListenUDP(&net.UDPAddr{IP: ip, Port: Port})
+	if err != nil {
 		return err
 	}
 
-	// Start listening for messages.
-	go func() {
-		if err := n.listen(); err != nil {
-			fmt.Printf("error listening for messages: %v\n", err)
-		}
-	}()
+	if err := n.StartSources(ctx, source); err != nil {
+		source.Close()
+		return err
+	}
+
+	return nil
+}
+
+// StartSources activates the network to read messages from the
+// sources. Messages from every source update the same hubs and are
+// delivered to the same subscribers.
+//
+// The network runs until the context is cancelled, Stop is called,
+// reading a source fails or every source is exhausted. The sources
+// are closed when the network stops.
+func (n *Network) StartSources(ctx context.Context, sources ...Source) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.cancel != nil {
+		return ErrNetworkRunning
+	}
+
+	if err := n.load(ctx); err != nil {
+		return err
+	}
 
-	messageProcessing := make(chan networkMessage)
-	n.networkMessage = messageProcessing
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	n.cancel = cancel
+	n.done = done
+	n.err = nil
 
-	// Process messages.
+	// The network is stopped once serving finishes, however it
+	// finishes, keeping the error for Stop to return.
 	go func() {
-		if err := n.processMessage(messageProcessing); err != nil {
-			fmt.Printf("error processing messages: %v\n", err)
-		}
+		defer close(done)
+
+		err := n.serve(ctx, cancel, sources)
+		cancel()
+
+		n.mu.Lock()
+		n.cancel = nil
+		n.err = err
+		n.mu.Unlock()
 	}()
 
 	return nil
 }
 
-// Stop the network from listening for messages.
-func (n *Network) Stop() error {
-	close(n.stop)
+// Run starts the network and blocks until the context is cancelled
+// or listening fails. Run returns the error that stopped the network,
+// or nil if the context was cancelled.
+func (n *Network) Run(ctx context.Context, ip net.IP) error {
+	if err := n.Start(ctx, ip); err != nil {
+		return err
+	}
 
-	return nil
+	return n.wait()
 }
 
-// Connect to local network.
-// The network will listen for UDP packets on the interface
-// with the given IP address.
-// Use net.IPv4(0, 0, 0, 0) or net.IPv4zero to listen to all
-// interfaces.
-func (n *Network) connect(ip net.IP) error {
-	conn, err := net.ListenUDP("udp",
-		&net.UDPAddr{
-			IP:   ip,
-			Port: 50222,
-		})
-	if err != nil {
-		return fmt.Errorf("failed to connect to network: %w", err)
+// RunSources starts the network with the sources and blocks until the
+// context is cancelled, reading a source fails or every source is
+// exhausted. RunSources returns the error that stopped the network,
+// or nil if the context was cancelled or the sources were exhausted.
+func (n *Network) RunSources(ctx context.Context, sources ...Source) error {
+	if err := n.StartSources(ctx, sources...); err != nil {
+		return err
 	}
 
-	n.udpConn = conn
+	return n.wait()
+}
+
+// wait blocks until the running network stops and returns the
+// error that stopped it.
+func (n *Network) wait() error {
+	<-n.Done()
 
-	return nil
+	return n.Stop()
 }
 
-// handlePartialMessage handles a partial message received from the hub.
-// The message is incomplete and may be missing the closing brace due to
-// a network error.
-func handlePartialMessage(messageBuffer *bytes.Buffer) {
-	var message RawMessage
-	err := json.Unmarshal(messageBuffer.Bytes(), &message)
-	if err == nil {
-		fmt.Printf("recovered partial message: %v\n", message)
-	} else {
-		fmt.Printf("unable to recover partial message: %s", string(messageBuffer.Bytes()))
+// Done returns a channel closed when the network last started has
+// stopped, however it stopped. The channel of a network that has not
+// been started is closed.
+func (n *Network) Done() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.done == nil {
+		done := make(chan struct{})
+		close(done)
+		return done
 	}
+
+	return n.done
 }
 
-// processMessage processes a message received from the network.
-func (n *Network) processMessage(networkMessage <-chan networkMessage) error {
-	for {
-		select {
-		case <-n.stop:
-			fmt.Println("stopping message processing")
+// running returns true if the network has been started and has not
+// stopped.
+func (n *Network) running() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
 
-			return nil
+	return n.cancel != nil
+}
 
-			// Process the message.
-		case msg := <-networkMessage:
-			msgType, err := msg.raw.Type()
-			if err != nil {
-				fmt.Printf("error processing message: %v\n", err)
-				continue
-			}
+// Stop the network from listening for messages.
+// Stop waits for the network to finish processing and returns the
+// error that stopped the network, if any, once.
+func (n *Network) Stop() error {
+	n.mu.Lock()
+	cancel, done := n.cancel, n.done
+	n.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	err := n.err
+	n.err = nil
+
+	return err
+}
+
+// serve reads and processes messages from the sources until the
+// context is cancelled or every source is exhausted. The sources are
+// closed when the context is cancelled to unblock reads.
+func (n *Network) serve(ctx context.Context, cancel context.CancelFunc, sources []Source) error {
+	// Saving continues while the queue drains after cancellation.
+	saveCtx := context.WithoutCancel(ctx)
+
+	messages := newQueue(n.queue, &n.stats, n.logger)
+	listenErrs := make(chan error, len(sources))
+
+	// Listen for messages from every source.
+	var listeners sync.WaitGroup
+	for _, source := range sources {
+		listeners.Add(1)
+
+		go func(source Source) {
+			defer listeners.Done()
 
-			switch msgType {
-			case MessageTypeRapidWind:
-				hubSerialNumber, err := msg.raw.HubSerial()
-				if err != nil {
-					fmt.Printf("error getting hub serial: %v\n", err)
-					continue
-				}
-
-				sensorSerialNumber, err := msg.raw.SensorSerial()
-				if err != nil {
-					fmt.Printf("error getting sensor serial: %v\n", err)
-					continue
-				}
-
-				hub := n.updateHub(hubSerialNumber, msg.hubIp)
-				sensor := n.updateSensor(hubSerialNumber, sensorSerialNumber, msg.hubIp)
-
-				rapidWindEvent, err := NewRapidWindEvent(msg.raw, sensor, hub)
-				if err != nil {
-					fmt.Printf("error creating rapid wind event: %v\n", err)
-					continue
-				}
-
-				fmt.Printf("Wind speed (m/s) - %0f", rapidWindEvent.WindSpeed)
-
-			default:
-				fmt.Printf("unhandled message type: %s\n", msgType)
+			if err := n.listen(ctx, source, messages); err != nil {
+				listenErrs <- err
+				cancel()
 			}
+		}(source)
+	}
+
+	listened := make(chan struct{})
+	go func() {
+		listeners.Wait()
+		messages.close()
+		close(listened)
+	}()
+
+	// Process messages until every listener has stopped.
+	processed := make(chan struct{})
+	go func() {
+		defer close(processed)
+
+		n.processMessage(saveCtx, messages)
+	}()
+
+	// Mark silent hubs and sensors offline.
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+
+		n.watchLiveness(ctx, saveCtx)
+	}()
+
+	// Roll up saved history.
+	rolled := make(chan struct{})
+	go func() {
+		defer close(rolled)
+
+		if n.retention != nil && n.messageRepo != nil {
+			n.watchRetention(ctx, saveCtx)
 		}
+	}()
+
+	select {
+	case <-ctx.Done():
+	case <-listened:
+	}
+
+	closeErrs := make([]error, 0)
+	for _, source := range sources {
+		if err := source.Close(); err != nil {
+			closeErrs = append(closeErrs, fmt.Errorf("error closing source: %w", err))
+		}
+	}
+
+	<-listened
+	<-processed
+	cancel()
+	<-watched
+	<-rolled
+
+	n.saveAll(saveCtx)
+
+	select {
+	case err := <-listenErrs:
+		return err
+	default:
+		return errors.Join(closeErrs...)
 	}
 }
 
-// addHub adds a hub to the network.
-func (n *Network) addHub(serialNumber string, ip net.IP) {
-	n.hubs[serialNumber] = &Hub{
-		HubSerialNumber: serialNumber,
-		WeatherSensors:  make(map[string]*WeatherSensor),
-		LastReported:    time.Now(),
-		IPAddress:       ip,
+// processMessage processes messages received from the network.
+// Processing stops when the queue is closed and empty.
+func (n *Network) processMessage(ctx context.Context, messages *queue) {
+	for msg, ok := messages.pop(); ok; msg, ok = messages.pop() {
+		decoded, err := n.decode(ctx, msg)
+		if err != nil {
+			n.stats.decodeErrors.Add(1)
+			n.logger.Warn("dropping message", append(msg.logAttrs(), slog.Any(logKeyError, err))...)
+			continue
+		}
+
+		n.saveMessage(ctx, decoded)
+		n.stats.decoded.Add(1)
+		if n.debugEnabled() {
+			n.logger.Debug("decoded message", msg.logAttrs()...)
+		}
+
+		n.subscriptions.publish(decoded)
 	}
 }
 
-// updateHub updates the hub on the network.
-func (n *Network) updateHub(serialNumber string, ip net.IP) *Hub {
-	hub, found := n.hubs[serialNumber]
-	if !found {
-		fmt.Printf("hub not found, adding hub: %s\n", serialNumber)
-		n.addHub(serialNumber, ip)
-		return n.hubs[serialNumber]
+// decode converts a message received on the network to a WeatherMessage
+// with the decoder registered for the message type and updates the hub
+// and sensor that reported it.
+func (n *Network) decode(ctx context.Context, msg networkMessage) (WeatherMessage, error) {
+	msgType, err := msg.raw.Type()
+	if err != nil {
+		return nil, err
 	}
 
-	hub.IPAddress = ip
-	hub.LastReported = time.Now()
+	decoder, found := lookupDecoder(msgType)
+	if !found {
+		return nil, fmt.Errorf("unhandled message type: %s", msgType)
+	}
 
-	return hub
-}
+	hub, sensor, err := n.update(ctx, decoder.hub, msg)
+	if err != nil {
+		return nil, err
+	}
 
-// addSensor adds a sensor to the network.
-func (n *Network) addSensor(hubSerial string, sensorSerial string, ip net.IP) {
-	hub, hubFound := n.hubs[hubSerial]
-	if !hubFound {
-		// No hub found, add the hub and sensor.
-		n.addHub(hubSerial, ip)
-		hub = n.hubs[hubSerial]
-
-		hub.WeatherSensors[sensorSerial] = &WeatherSensor{
-			SensorSerial: sensorSerial,
-			LastMessage:  time.Now(),
-		}
+	decoded, err := decoder.decode(msg.raw, sensor, hub)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s message: %w", msgType, err)
+	}
 
-		return
+	// Some messages carry details about the hub or sensor itself.
+	if updater, ok := decoded.(hubUpdater); ok {
+		n.hubs.UpdateHub(hub.HubSerialNumber, updater.updateHub)
 	}
 
-	// Hub found, add the sensor.
-	hub.WeatherSensors[sensorSerial] = &WeatherSensor{
-		SensorSerial: sensorSerial,
-		LastMessage:  time.Now(),
+	if updater, ok := decoded.(sensorUpdater); ok && sensor != nil {
+		n.hubs.UpdateSensor(hub.HubSerialNumber, sensor.SensorSerial, updater.updateSensor)
 	}
+
+	return decoded, nil
 }
 
-// updateSensor updates the sensor on the network.
-func (n *Network) updateSensor(hubSerial string, sensorSerial string, ip net.IP) *WeatherSensor {
-	if hub, hubFound := n.hubs[hubSerial]; hubFound {
-		sensor, sensorFound := hub.WeatherSensors[sensorSerial]
-		if !sensorFound {
-			// No sensor found, add the sensor.
-			n.addSensor(hubSerial, sensorSerial, ip)
-			return n.hubs[hubSerial].WeatherSensors[sensorSerial]
+// hubUpdater is implemented by messages that update the hub that
+// reported them.
+type hubUpdater interface {
+	// updateHub updates the hub with the message and references the
+	// updated hub from the message.
+	updateHub(hub *Hub)
+}
+
+// update records the hub and sensor that reported a message and
+// reports hubs and sensors that have come online.
+// Hubs and sensors coming online are saved to the repos.
+// Messages reported by hubs themselves, like hub status messages, have
+// no sensor.
+func (n *Network) update(ctx context.Context, hubMessage bool, msg networkMessage) (*Hub, *WeatherSensor, error) {
+	hubSerialNumber, err := msg.raw.HubSerial()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting hub serial: %w", err)
+	}
+
+	sensorSerialNumber := ""
+	if !hubMessage {
+		sensorSerialNumber, err = msg.raw.SensorSerial()
+		if err != nil {
+			return nil, nil, fmt.Errorf("error getting sensor serial: %w", err)
 		}
+	}
+
+	now := time.Now()
+	hubOnline := false
+	hub := n.hubs.UpdateHub(hubSerialNumber, func(hub *Hub) {
+		hubOnline = !hub.Online
+		hub.IPAddress = msg.hubIp
+		hub.LastReported = now
+		hub.Online = true
+	})
+
+	if hubOnline {
+		event := &HubOnline{Hub: hub, EventTime: now}
+		n.logEvent(event)
+		n.saveEvent(ctx, event)
+		n.subscriptions.publish(event)
+	}
 
-		sensor.LastMessage = time.Now()
-		return sensor
+	if sensorSerialNumber == "" {
+		return hub, nil, nil
 	}
 
-	// No hub found, add the hub and sensor.
-	n.addSensor(hubSerial, sensorSerial, ip)
+	sensorOnline := false
+	hub, sensor := n.hubs.UpdateSensor(hubSerialNumber, sensorSerialNumber, func(sensor *WeatherSensor) {
+		sensorOnline = !sensor.Online
+		sensor.LastMessage = now
+		sensor.Online = true
+	})
+
+	if sensorOnline {
+		event := &SensorOnline{Hub: hub, Sensor: sensor, EventTime: now}
+		n.logEvent(event)
+		n.saveEvent(ctx, event)
+		n.subscriptions.publish(event)
+	}
 
-	return n.hubs[hubSerial].WeatherSensors[sensorSerial]
+	return hub, sensor, nil
 }
 
-// listen for messages on the network.
-func (n *Network) listen() error {
-	// Create a buffer to read messages into.
-	buffer := make([]byte, 1024)
-	var messageBuffer bytes.Buffer
-	var data []byte
-	openBraces := 0
+// MaxDatagramSize is the largest datagram the network accepts.
+// Tempest hubs broadcast each message in a single datagram well
+// under this size.
+const MaxDatagramSize = 4096
 
-	// Listen for messages.
+// listen for messages from the source.
+// Each datagram holds exactly one message and is decoded on its own.
+// Listening stops without an error when the context is cancelled or
+// the source is exhausted.
+func (n *Network) listen(ctx context.Context, source Source, messages *queue) error {
 	for {
-		select {
-		case <-n.stop:
-			// Stop listening for messages and close the connection.
-			errorClosing := n.udpConn.Close()
-			return errorClosing
-		default:
-			// Read from the connection.
-			err := n.udpConn.SetReadDeadline(time.Now().Add(30 * time.Second))
-			if err != nil {
-				return fmt.Errorf("error setting read deadline: %w", err)
+		datagram, err := source.Read(ctx)
+		if err != nil {
+			// Reads are unblocked by closing the source
+			// when the context is cancelled.
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return nil
 			}
 
-			bytesRead, hubIp, err := n.udpConn.ReadFromUDP(buffer)
-			if err != nil {
-				var e net.Error
-				if errors.As(err, &e) && e.Timeout() {
-					if messageBuffer.Len() > 0 {
-						fmt.Println("connection lost mid-message, attempting to recover")
-						handlePartialMessage(&messageBuffer)
-						messageBuffer.Reset()
-						break
-					}
-				}
-
-				return fmt.Errorf("error reading from UDP connection: %w", err)
-			}
+			return fmt.Errorf("error reading from source: %w", err)
+		}
 
-			// Write the message to the buffer.
-			messageBuffer.Write(buffer[:bytesRead])
-			data = messageBuffer.Bytes()
-			fmt.Printf("received message: %s\n", data)
-
-			// Process the message.
-			for len(data) > 0 {
-				startIndex := bytes.IndexByte(data, '{')
-				if startIndex == -1 {
-					messageBuffer.Reset()
-					break
-				}
-
-				// Scan through the buffer to find the end of the message.
-				for i := startIndex; i < len(data); i++ {
-					if data[i] == '{' {
-						openBraces++
-					} else if data[i] == '}' {
-						openBraces--
-						if openBraces == 0 {
-							message := data[startIndex : i+1]
-							data = data[i+1:]
-
-							// Try to get the message
-							var msg RawMessage
-							err := json.Unmarshal(message, &msg)
-							if err == nil {
-								fmt.Printf("received message: %v\n", msg)
-							} else {
-								fmt.Printf("unable to unmarshal message: %v\n", err)
-							}
-
-							if msgType, err := msg.Type(); err == nil && msgType.Valid() {
-								fmt.Printf("received message type: %s\n", msgType)
-								n.networkMessage <- networkMessage{
-									raw:   msg,
-									hubIp: hubIp.IP,
-								}
-							} else {
-								fmt.Printf("invalid message type: %v\n", err)
-							}
-
-							messageBuffer.Reset()
-							messageBuffer.Write(data)
-							break
-						}
-					}
-				}
-
-				if openBraces > 0 {
-					break
-				}
-			}
+		msg, ok := n.readDatagram(datagram.Data, datagram.Source)
+		if !ok {
+			continue
 		}
+
+		if !messages.push(ctx, msg) {
+			return nil
+		}
+	}
+}
+
+// readDatagram returns the message held by the datagram from the
+// source. Datagrams that do not hold a single message with a valid
+// type are counted and dropped.
+func (n *Network) readDatagram(datagram []byte, source net.IP) (networkMessage, bool) {
+	n.stats.datagrams.Add(1)
+
+	if n.debugEnabled() {
+		n.logger.Debug("received datagram", slog.String(logKeySourceIP, ipString(source)), slog.Int(logKeySize, len(datagram)))
+	}
+
+	if len(datagram) > MaxDatagramSize {
+		n.stats.oversized.Add(1)
+		n.logger.Warn("dropping oversized datagram", slog.String(logKeySourceIP, ipString(source)), slog.Int(logKeySize, len(datagram)))
+		return networkMessage{}, false
 	}
+
+	var raw RawMessage
+	err := json.Unmarshal(datagram, &raw)
+	if err == nil && raw == nil {
+		err = errors.New("datagram is not a JSON object")
+	}
+
+	if err != nil {
+		n.stats.malformed.Add(1)
+		n.logger.Warn("dropping malformed datagram", slog.String(logKeySourceIP, ipString(source)), slog.Any(logKeyError, err))
+		return networkMessage{}, false
+	}
+
+	if _, err := raw.Type(); err != nil {
+		n.stats.invalidType.Add(1)
+		n.logger.Warn("dropping datagram with invalid type", slog.String(logKeySourceIP, ipString(source)), slog.Any(logKeyError, err))
+		return networkMessage{}, false
+	}
+
+	return networkMessage{
+		raw:   raw,
+		hubIp: source,
+	}, true
 }
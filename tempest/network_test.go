@@ -1 +1,269 @@
 package tempest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testNetworkMessage returns a network message for the JSON payload.
+func testNetworkMessage(t *testing.T, payload string) networkMessage {
+	t.Helper()
+
+	var raw RawMessage
+	if err := json.Unmarshal([]byte(payload), &raw); err != nil {
+		t.Fatalf("error unmarshalling test message: %v", err)
+	}
+
+	return networkMessage{raw: raw, hubIp: net.IPv4(192, 168, 1, 2)}
+}
+
+func TestNetwork_DecodeRapidWind(t *testing.T) {
+	n := NewNetwork("test")
+	msg := testNetworkMessage(t, `{"serial_number":"ST-00000512","type":"rapid_wind","hub_sn":"HB-00013030","ob":[1493322445,2.3,128]}`)
+
+	decoded, err := n.decode(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("error decoding rapid wind: %v", err)
+	}
+
+	event, ok := decoded.(*RapidWindEvent)
+	if !ok {
+		t.Fatalf("unexpected message: %T", decoded)
+	}
+
+	if event.WindSpeed != 2.3 || event.WindDirection != 128 {
+		t.Errorf("unexpected rapid wind event: %+v", event)
+	}
+
+	if event.Hub == nil || event.Hub.HubSerialNumber != "HB-00013030" {
+		t.Errorf("unexpected hub: %+v", event.Hub)
+	}
+
+	if event.Sensor == nil || event.Sensor.SensorSerial != "ST-00000512" {
+		t.Errorf("unexpected sensor: %+v", event.Sensor)
+	}
+}
+
+func TestNetwork_DecodeObservation(t *testing.T) {
+	n := NewNetwork("test")
+	msg := testNetworkMessage(t, `{"serial_number":"ST-00146014","type":"obs_st","hub_sn":"HB-00149269","obs":[[1719767641,0.31,1.71,3.15,358,3,995.90,18.68,57.51,159176,12.46,1326,0.000000,0,0,0,2.755,1]],"firmware_revision":176}`)
+
+	decoded, err := n.decode(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("error decoding observation: %v", err)
+	}
+
+	if decoded.Type() != MessageTypeObservation {
+		t.Errorf("unexpected message type: %s", decoded.Type())
+	}
+
+	if _, found := n.hubs.hubs["HB-00149269"].WeatherSensors["ST-00146014"]; !found {
+		t.Errorf("expected sensor to be added to the hub")
+	}
+}
+
+// sendTestDatagram sends the payload to the network's UDP port on
+// the loopback interface.
+func sendTestDatagram(t *testing.T, payload string) {
+	t.Helper()
+
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50222})
+	if err != nil {
+		t.Fatalf("error dialing network: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(payload)); err != nil {
+		t.Fatalf("error sending datagram: %v", err)
+	}
+}
+
+func TestNetwork_StartStopRestart(t *testing.T) {
+	n := NewNetwork("test")
+	sub := n.Subscribe(MessageTypeRapidWind)
+	defer sub.Unsubscribe()
+
+	for i := 0; i < 2; i++ {
+		if err := n.Start(context.Background(), net.IPv4(127, 0, 0, 1)); err != nil {
+			t.Skipf("unable to listen on the tempest port: %v", err)
+		}
+
+		if err := n.Start(context.Background(), net.IPv4(127, 0, 0, 1)); !errors.Is(err, ErrNetworkRunning) {
+			t.Errorf("expected ErrNetworkRunning, got %v", err)
+		}
+
+		sendTestDatagram(t, `{"serial_number":"ST-00000512","type":"rapid_wind","hub_sn":"HB-00013030","ob":[1493322445,2.3,128]}`)
+
+		select {
+		case msg := <-sub.Messages():
+			if msg.Type() != MessageTypeRapidWind {
+				t.Errorf("unexpected message type: %s", msg.Type())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for message")
+		}
+
+		stopped := make(chan error, 1)
+		go func() { stopped <- n.Stop() }()
+
+		select {
+		case err := <-stopped:
+			if err != nil {
+				t.Errorf("unexpected error stopping network: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("stop did not unblock the listener")
+		}
+	}
+}
+
+func TestNetwork_RunCancel(t *testing.T) {
+	n := NewNetwork("test")
+	sub := n.Subscribe(MessageTypeRapidWind)
+	defer sub.Unsubscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	datagrams := make(chan Datagram)
+	result := make(chan error, 1)
+	go func() { result <- n.RunSources(ctx, NewChannelSource(datagrams)) }()
+
+	// The network is running once it delivers a message.
+	datagrams <- Datagram{Data: []byte(testRapidWind), Source: net.IPv4(192, 168, 1, 2)}
+	select {
+	case <-sub.Messages():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+
+	cancel()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("unexpected error from run: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return after cancel")
+	}
+}
+
+func TestNetwork_RestartAfterStopping(t *testing.T) {
+	n := NewNetwork("test")
+
+	// waitStopped waits for the running network to stop by itself.
+	waitStopped := func(done <-chan struct{}) {
+		t.Helper()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("network did not stop")
+		}
+	}
+
+	// A network stopped by its context may be started again without
+	// Stop.
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := n.StartSources(ctx, NewChannelSource(make(chan Datagram))); err != nil {
+		t.Fatal(err)
+	}
+	done := n.Done()
+
+	cancel()
+	waitStopped(done)
+
+	// As may a network whose sources are exhausted.
+	exhausted := make(chan Datagram)
+	close(exhausted)
+	if err := n.StartSources(context.Background(), NewChannelSource(exhausted)); err != nil {
+		t.Fatalf("expected the cancelled network to start, got %v", err)
+	}
+
+	waitStopped(n.Done())
+
+	if err := n.StartSources(context.Background(), NewChannelSource(make(chan Datagram))); err != nil {
+		t.Fatalf("expected the exhausted network to start, got %v", err)
+	}
+
+	select {
+	case <-n.Done():
+		t.Error("expected the running network not to be done")
+	default:
+	}
+
+	if err := n.Stop(); err != nil {
+		t.Errorf("unexpected error stopping network: %v", err)
+	}
+}
+
+func TestNetwork_ReadDatagram(t *testing.T) {
+	source := net.IPv4(192, 168, 1, 2)
+	tests := []struct {
+		name     string
+		datagram string
+		ok       bool
+		want     Stats
+	}{
+		{
+			name:     "valid",
+			datagram: `{"serial_number":"ST-00000512","type":"rapid_wind","hub_sn":"HB-00013030","ob":[1493322445,2.3,128]}`,
+			ok:       true,
+			want:     Stats{Datagrams: 1},
+		},
+		{
+			name:     "brace in string",
+			datagram: `{"serial_number":"ST-{0512}","type":"rapid_wind","hub_sn":"HB-00013030","ob":[1493322445,2.3,128]}`,
+			ok:       true,
+			want:     Stats{Datagrams: 1},
+		},
+		{
+			name:     "truncated",
+			datagram: `{"serial_number":"ST-00000512","type":"rapid_wind","hub_sn":"HB-0001`,
+			want:     Stats{Datagrams: 1, Malformed: 1},
+		},
+		{
+			name:     "concatenated",
+			datagram: `{"type":"rapid_wind"}{"type":"rapid_wind"}`,
+			want:     Stats{Datagrams: 1, Malformed: 1},
+		},
+		{
+			name:     "null",
+			datagram: `null`,
+			want:     Stats{Datagrams: 1, Malformed: 1},
+		},
+		{
+			name:     "unknown type",
+			datagram: `{"serial_number":"ST-00000512","type":"obs_unknown","hub_sn":"HB-00013030"}`,
+			want:     Stats{Datagrams: 1, InvalidType: 1},
+		},
+		{
+			name:     "oversized",
+			datagram: `{"type":"rapid_wind","pad":"` + strings.Repeat("x", MaxDatagramSize) + `"}`,
+			want:     Stats{Datagrams: 1, Oversized: 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n := NewNetwork("test")
+
+			msg, ok := n.readDatagram([]byte(test.datagram), source)
+			if ok != test.ok {
+				t.Fatalf("expected ok %t, got %t", test.ok, ok)
+			}
+
+			if ok && !msg.hubIp.Equal(source) {
+				t.Errorf("unexpected source: %s", msg.hubIp)
+			}
+
+			if got := n.Stats(); got != test.want {
+				t.Errorf("unexpected stats: %+v", got)
+			}
+		})
+	}
+}
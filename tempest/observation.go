@@ -3,6 +3,7 @@ package tempest
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -39,30 +40,80 @@ type Observation struct {
 	Observations []WeatherObservation
 }
 
+// Type returns the message type for the observation.
+func (o *Observation) Type() Type {
+	return MessageTypeObservation
+}
+
+// Time returns the time of the latest observation in the message.
+func (o *Observation) Time() time.Time {
+	var latest time.Time
+	for _, ob := range o.Observations {
+		if ob.EpochSecondsUTC.After(latest) {
+			latest = ob.EpochSecondsUTC
+		}
+	}
+
+	return latest
+}
+
+// updateSensor records the sensor's reporting interval.
+func (o *Observation) updateSensor(sensor *WeatherSensor) {
+	for _, ob := range o.Observations {
+		if ob.Has(FieldReportingInterval) && ob.ReportingInterval > 0 {
+			sensor.ReportInterval = time.Duration(ob.ReportingInterval) * time.Minute
+		}
+	}
+}
+
 // Read parses the observation message.
 func (o *Observation) Read(message []byte) error {
-	var rawMessage map[string]interface{}
+	var rawMessage RawMessage
 	if err := json.Unmarshal(message, &rawMessage); err != nil {
 		return err
 	}
 
+	return o.read(rawMessage)
+}
+
+// read parses the observation from a raw message.
+func (o *Observation) read(rawMessage RawMessage) error {
 	// Clear the observations.
 	o.Observations = make([]WeatherObservation, 0)
 
 	// Record the sensor and hub serial numbers.
 	// These are used to identify the sensor and hub that sent
 	// the observation.
-	o.SensorSerial = rawMessage["serial_number"].(string)
-	o.HubSerial = rawMessage["hub_sn"].(string)
+	sensorSerial, err := rawMessage.SensorSerial()
+	if err != nil {
+		return err
+	}
+	o.SensorSerial = sensorSerial
+
+	hubSerial, err := rawMessage.HubSerial()
+	if err != nil {
+		return err
+	}
+	o.HubSerial = hubSerial
 
 	// A message may have one or more observations.
 	// The majority of the time there is only one observation.
-	obs := rawMessage["obs"].([]interface{})
+	obs, ok := rawMessage["obs"].([]interface{})
+	if !ok {
+		return fmt.Errorf("observations not found")
+	}
+
 	for _, ob := range obs {
-		observation := ob.([]interface{})
+		observation, ok := ob.([]interface{})
+		if !ok || len(observation) <= obsIndexReportingInterval {
+			return fmt.Errorf("observation does not have %d elements", obsIndexReportingInterval+1)
+		}
+
 		weatherObs := WeatherObservation{}
 
 		// Epoch seconds UTC the observation was taken.
+		// Every other field may be null when an instrument fails,
+		// but an observation without a timestamp is unusable.
 		epochUtc, ok := observation[obsIndexTimestampEpochUTC].(float64)
 		if !ok {
 			return fmt.Errorf("unable to read epoch seconds utc")
@@ -70,121 +121,121 @@ func (o *Observation) Read(message []byte) error {
 		weatherObs.EpochSecondsUTC = time.Unix(int64(epochUtc), 0)
 
 		// Wind lull.
-		windLull, ok := observation[obsIndexWindLull].(float64)
-		if !ok {
-			return fmt.Errorf("unable to read wind lull")
+		windLull, err := weatherObs.number(observation, obsIndexWindLull, FieldWindLull)
+		if err != nil {
+			return err
 		}
 		weatherObs.WindLull = NewSpeed(windLull, MetersPerSecond)
 
 		// Wind average.
-		windAverage, ok := observation[obsIndexWindAverage].(float64)
-		if !ok {
-			return fmt.Errorf("unable to read wind average")
+		windAverage, err := weatherObs.number(observation, obsIndexWindAverage, FieldWindAverage)
+		if err != nil {
+			return err
 		}
 		weatherObs.WindAverage = NewSpeed(windAverage, MetersPerSecond)
 
 		// Wind gust.
-		windGust, ok := observation[obsIndexWindGust].(float64)
-		if !ok {
-			return fmt.Errorf("unable to read wind gust")
+		windGust, err := weatherObs.number(observation, obsIndexWindGust, FieldWindGust)
+		if err != nil {
+			return err
 		}
 		weatherObs.WindGust = NewSpeed(windGust, MetersPerSecond)
 
 		// Wind direction.
-		windDirection, ok := observation[obsIndexWindDirection].(float64)
-		if !ok {
-			return fmt.Errorf("unable to read wind direction")
+		windDirection, err := weatherObs.number(observation, obsIndexWindDirection, FieldWindDirection)
+		if err != nil {
+			return err
 		}
 		weatherObs.WindDirection = NewDirection(windDirection, Degrees)
 
 		// Wind sample interval.
-		windSampleInterval, ok := observation[obsIndexWindSampleInterval].(float64)
-		if !ok {
-			return fmt.Errorf("unable to read wind sample interval")
+		windSampleInterval, err := weatherObs.number(observation, obsIndexWindSampleInterval, FieldWindSampleInterval)
+		if err != nil {
+			return err
 		}
 		weatherObs.WindSampleInterval = int(windSampleInterval)
 
 		// Station pressure.
-		stationPressure, ok := observation[obsIndexStationPressure].(float64)
-		if !ok {
-			return fmt.Errorf("unable to read station pressure")
+		stationPressure, err := weatherObs.number(observation, obsIndexStationPressure, FieldStationPressure)
+		if err != nil {
+			return err
 		}
 		weatherObs.StationPressure = NewPressure(stationPressure, Millibar)
 
 		// Air temperature.
-		airTemperature, ok := observation[obsIndexAirTemperature].(float64)
-		if !ok {
-			return fmt.Errorf("unable to read air temperature")
+		airTemperature, err := weatherObs.number(observation, obsIndexAirTemperature, FieldAirTemperature)
+		if err != nil {
+			return err
 		}
 		weatherObs.AirTemperature = NewTemp(airTemperature, Celsius)
 
 		// Relative humidity.
-		relativeHumidity, ok := observation[obsIndexRelativeHumidity].(float64)
-		if !ok {
-			return fmt.Errorf("unable to read relative humidity")
+		relativeHumidity, err := weatherObs.number(observation, obsIndexRelativeHumidity, FieldRelativeHumidity)
+		if err != nil {
+			return err
 		}
 		weatherObs.RelativeHumidity = relativeHumidity
 
 		// Illuminance.
-		illuminance, ok := observation[obsIndexIlluminance].(float64)
-		if !ok {
-			return fmt.Errorf("unable to read illuminance")
+		illuminance, err := weatherObs.number(observation, obsIndexIlluminance, FieldIlluminance)
+		if err != nil {
+			return err
 		}
 		weatherObs.Illuminance = int(illuminance)
 
 		// UV index.
-		uv, ok := observation[obsIndexUV].(float64)
-		if !ok {
-			return fmt.Errorf("unable to read uv index")
+		uv, err := weatherObs.number(observation, obsIndexUV, FieldUV)
+		if err != nil {
+			return err
 		}
 		weatherObs.UV = uv
 
 		// Solar radiation.
-		solarRadiation, ok := observation[obsIndexSolarRadiation].(float64)
-		if !ok {
-			return fmt.Errorf("unable to read solar radiation")
+		solarRadiation, err := weatherObs.number(observation, obsIndexSolarRadiation, FieldSolarRadiation)
+		if err != nil {
+			return err
 		}
 		weatherObs.SolarRadiation = int(solarRadiation)
 
 		// Rain accumulation.
-		rainAccumulation, ok := observation[obsIndexRainAccumulationPastMinute].(float64)
-		if !ok {
-			return fmt.Errorf("unable to read rain accumulation")
+		rainAccumulation, err := weatherObs.number(observation, obsIndexRainAccumulationPastMinute, FieldRainAccumulation)
+		if err != nil {
+			return err
 		}
 		weatherObs.RainAccumulation = rainAccumulation
 
 		// Precipitation type.
-		precipitationType, ok := observation[obsIndexPrecipitationType].(float64)
-		if !ok {
-			return fmt.Errorf("unable to read precipitation type")
+		precipitationType, err := weatherObs.number(observation, obsIndexPrecipitationType, FieldPrecipitationType)
+		if err != nil {
+			return err
 		}
 		weatherObs.PrecipitationType = int(precipitationType)
 
 		// Lightning strike average distance.
-		lightningStrikeAvg, ok := observation[obsIndexLightningStrikeAverageDistance].(float64)
-		if !ok {
-			return fmt.Errorf("unable to read lightning strike average distance")
+		lightningStrikeAvg, err := weatherObs.number(observation, obsIndexLightningStrikeAverageDistance, FieldLightningStrikeAvg)
+		if err != nil {
+			return err
 		}
 		weatherObs.LightningStrikeAvg = NewDistance(lightningStrikeAvg, Kilometers)
 
 		// Lightning strike count.
-		lightningStrikeCnt, ok := observation[obsIndexLightingStrikeCount].(float64)
-		if !ok {
-			return fmt.Errorf("unable to read lightning strike count")
+		lightningStrikeCnt, err := weatherObs.number(observation, obsIndexLightingStrikeCount, FieldLightningStrikeCnt)
+		if err != nil {
+			return err
 		}
 		weatherObs.LightningStrikeCnt = int(lightningStrikeCnt)
 
 		// Battery volts.
-		batteryVolts, ok := observation[obsIndexBatteryVolts].(float64)
-		if !ok {
-			return fmt.Errorf("unable to read battery volts")
+		batteryVolts, err := weatherObs.number(observation, obsIndexBatteryVolts, FieldBatteryVolts)
+		if err != nil {
+			return err
 		}
 		weatherObs.BatteryVolts = batteryVolts
 
 		// Reporting interval.
-		reportingInterval, ok := observation[obsIndexReportingInterval].(float64)
-		if !ok {
-			return fmt.Errorf("unable to read reporting interval")
+		reportingInterval, err := weatherObs.number(observation, obsIndexReportingInterval, FieldReportingInterval)
+		if err != nil {
+			return err
 		}
 		weatherObs.ReportingInterval = int(reportingInterval)
 
@@ -215,4 +266,106 @@ type WeatherObservation struct {
 	LightningStrikeCnt int       // lightning strike count
 	BatteryVolts       float64   // sensor battery voltage
 	ReportingInterval  int       // sensor reporting interval minutes
+
+	// Fields the sensor reported as null. A field is null when the
+	// instrument measuring it has failed and its value is zero.
+	Missing ObservationField
+}
+
+// Has returns true if the sensor reported every one of the fields.
+func (w *WeatherObservation) Has(fields ObservationField) bool {
+	return w.Missing&fields == 0
+}
+
+// Complete returns true if the sensor reported every field.
+func (w *WeatherObservation) Complete() bool {
+	return w.Missing == 0
+}
+
+// number returns the numeric value at index in the observation.
+// A null value is recorded as missing and read as zero.
+func (w *WeatherObservation) number(observation []interface{}, index int, field ObservationField) (float64, error) {
+	return observationNumber(observation, index, field, &w.Missing)
+}
+
+// observationNumber returns the numeric value of the field at index in
+// an observation. A null value is added to missing and read as zero.
+func observationNumber(observation []interface{}, index int, field ObservationField, missing *ObservationField) (float64, error) {
+	switch value := observation[index].(type) {
+	case float64:
+		return value, nil
+	case nil:
+		*missing |= field
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("unable to read %s", field)
+	}
+}
+
+// ObservationField is a set of WeatherObservation, AirReading and
+// SkyReading fields.
+type ObservationField uint32
+
+// Weather observation fields.
+const (
+	FieldWindLull ObservationField = 1 << iota
+	FieldWindAverage
+	FieldWindGust
+	FieldWindDirection
+	FieldWindSampleInterval
+	FieldStationPressure
+	FieldAirTemperature
+	FieldRelativeHumidity
+	FieldIlluminance
+	FieldUV
+	FieldSolarRadiation
+	FieldRainAccumulation
+	FieldPrecipitationType
+	FieldLightningStrikeAvg
+	FieldLightningStrikeCnt
+	FieldBatteryVolts
+	FieldReportingInterval
+
+	// Fields only reported by legacy sensors. These follow the fields
+	// saved by an ObservationStore, which are never reordered.
+	FieldLocalDayRainAccumulation
+)
+
+// observationFieldNames is the order and name of each field. The first
+// storeFields are the fields saved by an ObservationStore, in the order
+// they are saved.
+var observationFieldNames = []struct {
+	field ObservationField
+	name  string
+}{
+	{FieldWindLull, "wind lull"},
+	{FieldWindAverage, "wind average"},
+	{FieldWindGust, "wind gust"},
+	{FieldWindDirection, "wind direction"},
+	{FieldWindSampleInterval, "wind sample interval"},
+	{FieldStationPressure, "station pressure"},
+	{FieldAirTemperature, "air temperature"},
+	{FieldRelativeHumidity, "relative humidity"},
+	{FieldIlluminance, "illuminance"},
+	{FieldUV, "uv index"},
+	{FieldSolarRadiation, "solar radiation"},
+	{FieldRainAccumulation, "rain accumulation"},
+	{FieldPrecipitationType, "precipitation type"},
+	{FieldLightningStrikeAvg, "lightning strike average distance"},
+	{FieldLightningStrikeCnt, "lightning strike count"},
+	{FieldBatteryVolts, "battery volts"},
+	{FieldReportingInterval, "reporting interval"},
+	{FieldLocalDayRainAccumulation, "local day rain accumulation"},
+}
+
+// String returns the names of the fields separated by commas.
+func (f ObservationField) String() string {
+	names := make([]string, 0)
+	for _, field := range observationFieldNames {
+		if f&field.field != 0 {
+			names = append(names, field.name)
+		}
+	}
+
+	return strings.Join(names, ",")
 }
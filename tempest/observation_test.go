@@ -102,3 +102,50 @@ func TestObservation_Read(t *testing.T) {
 		t.Errorf("unexpected reporting interval: %d", obs1.ReportingInterval)
 	}
 }
+
+func TestObservation_ReadNullFields(t *testing.T) {
+	// The temperature/humidity instrument and the lightning sensor
+	// have failed and report null.
+	rawMessage := `{"serial_number":"ST-00146014","type":"obs_st","hub_sn":"HB-00149269","obs":[[1719767641,0.31,1.71,3.15,358,3,995.90,null,null,159176,12.46,1326,0.25,1,null,null,2.755,1]],"firmware_revision":176}`
+	obs := Observation{}
+	if err := obs.Read([]byte(rawMessage)); err != nil {
+		t.Fatalf("error reading observation: %v", err)
+	}
+
+	obs1 := obs.Observations[0]
+	if obs1.Has(FieldAirTemperature) || obs1.Has(FieldRelativeHumidity) {
+		t.Errorf("expected temperature and humidity to be missing")
+	}
+
+	if obs1.Has(FieldLightningStrikeAvg | FieldLightningStrikeCnt) {
+		t.Errorf("expected lightning fields to be missing")
+	}
+
+	if obs1.Complete() {
+		t.Errorf("expected observation to be incomplete")
+	}
+
+	if !obs1.Has(FieldWindAverage | FieldRainAccumulation) {
+		t.Errorf("unexpected missing fields: %s", obs1.Missing)
+	}
+
+	if obs1.WindAverage.MetersPerSecond() != 1.71 {
+		t.Errorf("unexpected wind average: %f", obs1.WindAverage.MetersPerSecond())
+	}
+
+	if obs1.RainAccumulation != 0.25 {
+		t.Errorf("unexpected rain accumulation: %f", obs1.RainAccumulation)
+	}
+
+	if obs1.Missing.String() != "air temperature,relative humidity,lightning strike average distance,lightning strike count" {
+		t.Errorf("unexpected missing fields: %s", obs1.Missing)
+	}
+}
+
+func TestObservation_ReadInvalidField(t *testing.T) {
+	rawMessage := `{"serial_number":"ST-00146014","type":"obs_st","hub_sn":"HB-00149269","obs":[[1719767641,0.31,1.71,3.15,358,3,995.90,"warm",57.51,159176,12.46,1326,0,0,0,0,2.755,1]]}`
+	obs := Observation{}
+	if err := obs.Read([]byte(rawMessage)); err == nil {
+		t.Errorf("expected an error for a non-numeric field")
+	}
+}
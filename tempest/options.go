@@ -0,0 +1,4 @@
+package tempest
+
+// An Option configures a Network.
+type Option func(n *Network)
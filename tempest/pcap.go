@@ -0,0 +1,460 @@
+package tempest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"math/bits"
+	"net"
+	"time"
+)
+
+// Link layer types in pcap and pcapng captures.
+const (
+	linkTypeNull     = 0   // BSD loopback.
+	linkTypeEthernet = 1   // Ethernet.
+	linkTypeRaw      = 101 // Raw IPv4 or IPv6.
+	linkTypeLinuxSLL = 113 // Linux cooked capture.
+	linkTypeLinuxSL2 = 276 // Linux cooked capture v2.
+)
+
+// Ethernet types of the network layer protocols.
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+)
+
+// Limits on lengths read from captures, so a corrupt length cannot
+// exhaust memory.
+const (
+	pcapMaxSnaplen       = 262144   // Largest packet libpcap captures.
+	pcapngMaxBlockLength = 16 << 20 // Largest block, as for Wireshark.
+)
+
+// protocolUDP is the IP protocol number for UDP.
+const protocolUDP = 17
+
+// errNotTempest is returned for packets that are not UDP datagrams
+// sent to the Tempest port.
+var errNotTempest = errors.New("not a tempest datagram")
+
+// pcapReader reads datagrams from a pcap capture.
+type pcapReader struct {
+	reader    io.Reader
+	order     binary.ByteOrder
+	nanos     bool // Timestamps have nanosecond resolution.
+	linkType  uint32
+	snaplen   uint32 // Largest packet in the capture.
+	header    [16]byte
+	remaining int
+}
+
+// newPcapReader reads the pcap global header and returns a reader for
+// the capture's packets.
+func newPcapReader(reader io.Reader) (*pcapReader, error) {
+	var header [24]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, fmt.Errorf("error reading pcap header: %w", err)
+	}
+
+	p := &pcapReader{reader: reader}
+
+	switch binary.BigEndian.Uint32(header[0:4]) {
+	case 0xa1b2c3d4:
+		p.order = binary.BigEndian
+	case 0xd4c3b2a1:
+		p.order = binary.LittleEndian
+	case 0xa1b23c4d:
+		p.order = binary.BigEndian
+		p.nanos = true
+	case 0x4d3cb2a1:
+		p.order = binary.LittleEndian
+		p.nanos = true
+	default:
+		return nil, fmt.Errorf("not a pcap capture")
+	}
+
+	p.linkType = p.order.Uint32(header[20:24]) & 0x0fffffff
+
+	// Some writers leave the snapshot length zero or larger than any
+	// packet captured.
+	p.snaplen = p.order.Uint32(header[16:20])
+	if p.snaplen == 0 || p.snaplen > pcapMaxSnaplen {
+		p.snaplen = pcapMaxSnaplen
+	}
+
+	return p, nil
+}
+
+// next returns the next Tempest datagram in the capture.
+func (p *pcapReader) next() (Datagram, error) {
+	for {
+		if _, err := io.ReadFull(p.reader, p.header[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return Datagram{}, fmt.Errorf("truncated pcap record header")
+			}
+
+			return Datagram{}, err
+		}
+
+		seconds := int64(p.order.Uint32(p.header[0:4]))
+		fraction := int64(p.order.Uint32(p.header[4:8]))
+		length := p.order.Uint32(p.header[8:12])
+		if length > p.snaplen {
+			return Datagram{}, fmt.Errorf("invalid pcap record length %d", length)
+		}
+
+		packet := make([]byte, length)
+		if _, err := io.ReadFull(p.reader, packet); err != nil {
+			return Datagram{}, fmt.Errorf("truncated pcap record: %w", err)
+		}
+
+		if !p.nanos {
+			fraction *= int64(time.Microsecond)
+		}
+
+		datagram, err := tempestDatagram(p.linkType, packet)
+		if errors.Is(err, errNotTempest) {
+			continue
+		}
+
+		datagram.Received = time.Unix(seconds, fraction)
+
+		return datagram, nil
+	}
+}
+
+// pcapng block types.
+const (
+	pcapngSectionHeader        = 0x0a0d0d0a
+	pcapngInterfaceDescription = 0x00000001
+	pcapngEnhancedPacket       = 0x00000006
+)
+
+// pcapngInterface is an interface described in a pcapng section.
+type pcapngInterface struct {
+	linkType  uint16
+	tsresol   byte   // Timestamp resolution option.
+	perSecond uint64 // Timestamp units per second, or zero when too many for a uint64.
+}
+
+// pcapngReader reads datagrams from a pcapng capture.
+type pcapngReader struct {
+	reader     io.Reader
+	order      binary.ByteOrder
+	interfaces []pcapngInterface
+}
+
+// newPcapngReader returns a reader for the pcapng capture.
+func newPcapngReader(reader io.Reader) *pcapngReader {
+	return &pcapngReader{
+		reader: reader,
+		order:  binary.LittleEndian,
+	}
+}
+
+// next returns the next Tempest datagram in the capture.
+func (p *pcapngReader) next() (Datagram, error) {
+	for {
+		blockType, body, err := p.readBlock()
+		if err != nil {
+			return Datagram{}, err
+		}
+
+		switch blockType {
+		case pcapngInterfaceDescription:
+			if len(body) < 8 {
+				return Datagram{}, fmt.Errorf("truncated pcapng interface description")
+			}
+
+			tsresol := p.tsresol(body[8:])
+			p.interfaces = append(p.interfaces, pcapngInterface{
+				linkType:  p.order.Uint16(body[0:2]),
+				tsresol:   tsresol,
+				perSecond: pcapngUnitsPerSecond(tsresol),
+			})
+
+		case pcapngEnhancedPacket:
+			if len(body) < 20 {
+				return Datagram{}, fmt.Errorf("truncated pcapng enhanced packet")
+			}
+
+			id := p.order.Uint32(body[0:4])
+			if int(id) >= len(p.interfaces) {
+				return Datagram{}, fmt.Errorf("pcapng packet for unknown interface %d", id)
+			}
+			iface := p.interfaces[id]
+
+			timestamp := uint64(p.order.Uint32(body[4:8]))<<32 | uint64(p.order.Uint32(body[8:12]))
+			length := p.order.Uint32(body[12:16])
+			if int(length) > len(body)-20 {
+				return Datagram{}, fmt.Errorf("truncated pcapng packet data")
+			}
+
+			datagram, err := tempestDatagram(uint32(iface.linkType), body[20:20+length])
+			if errors.Is(err, errNotTempest) {
+				continue
+			}
+
+			datagram.Received = iface.received(timestamp)
+
+			return datagram, nil
+		}
+	}
+}
+
+// readBlock reads the next block and returns its type and body.
+// Section headers set the byte order and reset the interfaces.
+func (p *pcapngReader) readBlock() (uint32, []byte, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(p.reader, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, fmt.Errorf("truncated pcapng block header")
+		}
+
+		return 0, nil, err
+	}
+
+	blockType := binary.LittleEndian.Uint32(header[0:4])
+	if blockType == pcapngSectionHeader {
+		// The byte order magic follows the header and determines
+		// how the block length is read.
+		var magic [4]byte
+		if _, err := io.ReadFull(p.reader, magic[:]); err != nil {
+			return 0, nil, fmt.Errorf("truncated pcapng section header")
+		}
+
+		switch binary.LittleEndian.Uint32(magic[:]) {
+		case 0x1a2b3c4d:
+			p.order = binary.LittleEndian
+		case 0x4d3c2b1a:
+			p.order = binary.BigEndian
+		default:
+			return 0, nil, fmt.Errorf("invalid pcapng byte order magic")
+		}
+
+		p.interfaces = nil
+
+		length := p.order.Uint32(header[4:8])
+		if length < 16 || length > pcapngMaxBlockLength {
+			return 0, nil, fmt.Errorf("invalid pcapng section header length %d", length)
+		}
+
+		if _, err := io.CopyN(io.Discard, p.reader, int64(length-12)); err != nil {
+			return 0, nil, fmt.Errorf("truncated pcapng section header")
+		}
+
+		return pcapngSectionHeader, nil, nil
+	}
+
+	blockType = p.order.Uint32(header[0:4])
+	length := p.order.Uint32(header[4:8])
+	if length < 12 || length > pcapngMaxBlockLength || length%4 != 0 {
+		return 0, nil, fmt.Errorf("invalid pcapng block length %d", length)
+	}
+
+	// The body is followed by a trailing copy of the block length.
+	body := make([]byte, length-8)
+	if _, err := io.ReadFull(p.reader, body); err != nil {
+		return 0, nil, fmt.Errorf("truncated pcapng block")
+	}
+
+	return blockType, body[:len(body)-4], nil
+}
+
+// tsresol returns the if_tsresol option from the interface description
+// options, defaulting to microseconds.
+func (p *pcapngReader) tsresol(options []byte) byte {
+	for len(options) >= 4 {
+		code := p.order.Uint16(options[0:2])
+		length := int(p.order.Uint16(options[2:4]))
+		if code == 0 || len(options) < 4+length {
+			break
+		}
+
+		if code == 9 && length == 1 {
+			return options[4]
+		}
+
+		options = options[4+(length+3)&^3:]
+	}
+
+	return 6
+}
+
+// pcapngUnitsPerSecond returns the number of timestamp units per second
+// for the if_tsresol option, or zero if there are too many for a uint64.
+// The high bit selects a power of two, otherwise a power of ten, of
+// seconds.
+func pcapngUnitsPerSecond(tsresol byte) uint64 {
+	exponent := uint(tsresol & 0x7f)
+	if tsresol&0x80 != 0 {
+		if exponent >= 64 {
+			return 0
+		}
+
+		return 1 << exponent
+	}
+
+	perSecond := uint64(1)
+	for i := uint(0); i < exponent; i++ {
+		hi, lo := bits.Mul64(perSecond, 10)
+		if hi != 0 {
+			return 0
+		}
+		perSecond = lo
+	}
+
+	return perSecond
+}
+
+// received returns the time of a timestamp on the interface, truncated to
+// the nanosecond.
+func (i pcapngInterface) received(timestamp uint64) time.Time {
+	if i.perSecond != 0 {
+		seconds, units := timestamp/i.perSecond, timestamp%i.perSecond
+
+		// units < perSecond, so the quotient fits in 64 bits.
+		hi, lo := bits.Mul64(units, uint64(time.Second))
+		nanos, _ := bits.Div64(hi, lo, i.perSecond)
+
+		return time.Unix(int64(seconds), int64(nanos))
+	}
+
+	// A unit is shorter than 2^-64 seconds, so the timestamp is under
+	// a second.
+	perSecond := big.NewInt(2)
+	if i.tsresol&0x80 == 0 {
+		perSecond.SetInt64(10)
+	}
+	perSecond.Exp(perSecond, big.NewInt(int64(i.tsresol&0x7f)), nil)
+
+	nanos := new(big.Int).SetUint64(timestamp)
+	nanos.Mul(nanos, big.NewInt(int64(time.Second)))
+	nanos.Quo(nanos, perSecond)
+
+	return time.Unix(0, nanos.Int64())
+}
+
+// tempestDatagram returns the UDP payload of a packet sent to the
+// Tempest port with its source address.
+func tempestDatagram(linkType uint32, packet []byte) (Datagram, error) {
+	var etherType uint16
+
+	switch linkType {
+	case linkTypeEthernet:
+		if len(packet) < 14 {
+			return Datagram{}, errNotTempest
+		}
+
+		etherType = binary.BigEndian.Uint16(packet[12:14])
+		packet = packet[14:]
+
+		// Skip a VLAN tag.
+		if etherType == etherTypeVLAN {
+			if len(packet) < 4 {
+				return Datagram{}, errNotTempest
+			}
+
+			etherType = binary.BigEndian.Uint16(packet[2:4])
+			packet = packet[4:]
+		}
+
+	case linkTypeRaw:
+		if len(packet) == 0 {
+			return Datagram{}, errNotTempest
+		}
+
+		switch packet[0] >> 4 {
+		case 4:
+			etherType = etherTypeIPv4
+		case 6:
+			etherType = etherTypeIPv6
+		}
+
+	case linkTypeLinuxSLL:
+		if len(packet) < 16 {
+			return Datagram{}, errNotTempest
+		}
+
+		etherType = binary.BigEndian.Uint16(packet[14:16])
+		packet = packet[16:]
+
+	case linkTypeLinuxSL2:
+		if len(packet) < 20 {
+			return Datagram{}, errNotTempest
+		}
+
+		etherType = binary.BigEndian.Uint16(packet[0:2])
+		packet = packet[20:]
+
+	case linkTypeNull:
+		if len(packet) < 4 {
+			return Datagram{}, errNotTempest
+		}
+
+		// The address family is in the capturing host's byte order.
+		family := binary.LittleEndian.Uint32(packet[0:4])
+		if family > 0xffff {
+			family = binary.BigEndian.Uint32(packet[0:4])
+		}
+
+		switch family {
+		case 2:
+			etherType = etherTypeIPv4
+		case 24, 28, 30:
+			etherType = etherTypeIPv6
+		}
+		packet = packet[4:]
+
+	default:
+		return Datagram{}, errNotTempest
+	}
+
+	var source net.IP
+	var udp []byte
+
+	switch etherType {
+	case etherTypeIPv4:
+		if len(packet) < 20 || packet[0]>>4 != 4 {
+			return Datagram{}, errNotTempest
+		}
+
+		headerLength := int(packet[0]&0x0f) * 4
+		fragment := binary.BigEndian.Uint16(packet[6:8])
+		if packet[9] != protocolUDP || len(packet) < headerLength || fragment&0x3fff != 0 {
+			return Datagram{}, errNotTempest
+		}
+
+		source = net.IP(append([]byte(nil), packet[12:16]...))
+		udp = packet[headerLength:]
+
+	case etherTypeIPv6:
+		if len(packet) < 40 || packet[6] != protocolUDP {
+			return Datagram{}, errNotTempest
+		}
+
+		source = net.IP(append([]byte(nil), packet[8:24]...))
+		udp = packet[40:]
+
+	default:
+		return Datagram{}, errNotTempest
+	}
+
+	if len(udp) < 8 || binary.BigEndian.Uint16(udp[2:4]) != Port {
+		return Datagram{}, errNotTempest
+	}
+
+	length := int(binary.BigEndian.Uint16(udp[4:6]))
+	if length < 8 || length > len(udp) {
+		return Datagram{}, errNotTempest
+	}
+
+	return Datagram{
+		Data:   udp[8:length],
+		Source: source,
+	}, nil
+}
@@ -1,5 +1,7 @@
 package tempest
 
+import "encoding/json"
+
 type PressureUnit int
 
 const (
@@ -23,6 +25,29 @@ func NewPressure(pressure float64, unit PressureUnit) Pressure {
 	}
 }
 
+// pressureJSON is the JSON form of a pressure reading.
+type pressureJSON struct {
+	Pressure float64
+	Unit     PressureUnit
+}
+
+// MarshalJSON encodes the pressure reading and its unit.
+func (p Pressure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(pressureJSON{Pressure: p.pressure, Unit: p.unit})
+}
+
+// UnmarshalJSON decodes a pressure reading encoded by MarshalJSON.
+func (p *Pressure) UnmarshalJSON(data []byte) error {
+	var decoded pressureJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	*p = NewPressure(decoded.Pressure, decoded.Unit)
+
+	return nil
+}
+
 // InHg returns the pressure in inches of mercury.
 func (p *Pressure) InHg() float64 {
 	switch p.unit {
@@ -0,0 +1,246 @@
+package tempest
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// OverflowPolicy decides what happens to messages received while the
+// processing queue is full.
+type OverflowPolicy int
+
+// Overflow policies.
+const (
+	// OverflowBlock stops reading sources until the queue has room.
+	// No message is lost, but a UDP socket drops datagrams unseen
+	// while it is not read.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropOldest drops the oldest queued message to make room.
+	OverflowDropOldest
+
+	// OverflowDropNewest drops the message received.
+	OverflowDropNewest
+
+	// OverflowCoalesce makes room by dropping queued rapid wind
+	// messages, which are superseded every few seconds. A rapid wind
+	// message replaces the one queued for the same sensor. The message
+	// received is dropped when no rapid wind message is queued.
+	OverflowCoalesce
+)
+
+// overflowPolicyNames are the names of the overflow policies.
+var overflowPolicyNames = map[OverflowPolicy]string{
+	OverflowBlock:      "block",
+	OverflowDropOldest: "drop-oldest",
+	OverflowDropNewest: "drop-newest",
+	OverflowCoalesce:   "coalesce",
+}
+
+// ParseOverflowPolicy parses the name of an overflow policy
+// (e.g. "drop-oldest").
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	for policy, name := range overflowPolicyNames {
+		if name == s {
+			return policy, nil
+		}
+	}
+
+	return OverflowBlock, fmt.Errorf("unknown overflow policy: %s", s)
+}
+
+// String returns the name of the overflow policy.
+func (p OverflowPolicy) String() string {
+	if name, found := overflowPolicyNames[p]; found {
+		return name
+	}
+
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+// DefaultQueueSize is the number of messages queued for processing by
+// default.
+const DefaultQueueSize = 1024
+
+// QueueConfig configures the queue of messages read from sources
+// waiting to be decoded. Zero fields use the defaults.
+type QueueConfig struct {
+	Size     int            // Messages queued before the overflow policy applies.
+	Overflow OverflowPolicy // What to do when the queue is full.
+}
+
+// WithQueue configures the queue of messages waiting to be decoded.
+func WithQueue(config QueueConfig) Option {
+	return func(n *Network) {
+		n.queue = config.withDefaults()
+	}
+}
+
+// withDefaults returns the configuration with defaults for zero fields.
+func (c QueueConfig) withDefaults() QueueConfig {
+	if c.Size <= 0 {
+		c.Size = DefaultQueueSize
+	}
+
+	return c
+}
+
+// queue is a bounded queue of messages between the listeners reading
+// sources and the processor decoding messages.
+type queue struct {
+	config QueueConfig
+	stats  *stats
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	changed  *sync.Cond // Signalled when messages are pushed or popped.
+	messages []networkMessage
+	closed   bool
+}
+
+// newQueue returns an empty queue counting drops in the stats.
+func newQueue(config QueueConfig, stats *stats, logger *slog.Logger) *queue {
+	q := &queue{
+		config:   config,
+		stats:    stats,
+		logger:   logger,
+		messages: make([]networkMessage, 0, config.Size),
+	}
+	q.changed = sync.NewCond(&q.mu)
+
+	return q
+}
+
+// push queues the message, applying the overflow policy when the queue
+// is full. push returns false if the context was cancelled while
+// waiting for room.
+func (q *queue) push(ctx context.Context, msg networkMessage) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.messages) < q.config.Size {
+		q.append(msg)
+		return true
+	}
+
+	switch q.config.Overflow {
+	case OverflowDropOldest:
+		q.drop(q.messages[0], "oldest")
+		q.messages = q.messages[1:]
+
+	case OverflowDropNewest:
+		q.drop(msg, "newest")
+		return true
+
+	case OverflowCoalesce:
+		if !q.coalesce(msg) {
+			q.drop(msg, "newest")
+		}
+		return true
+
+	default:
+		// Wake this push when the context is cancelled.
+		stop := context.AfterFunc(ctx, func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+
+			q.changed.Broadcast()
+		})
+		defer stop()
+
+		for len(q.messages) >= q.config.Size {
+			if ctx.Err() != nil {
+				return false
+			}
+
+			q.changed.Wait()
+		}
+	}
+
+	q.append(msg)
+	return true
+}
+
+// append adds the message to the end of the queue.
+func (q *queue) append(msg networkMessage) {
+	q.messages = append(q.messages, msg)
+	q.changed.Broadcast()
+}
+
+// coalesce makes room for the message by dropping a queued rapid wind
+// message, replacing the one from the message's sensor if queued.
+// coalesce returns false if no rapid wind message is queued.
+func (q *queue) coalesce(msg networkMessage) bool {
+	msgType, _ := msg.raw.Type()
+	sensor, _ := msg.raw.SensorSerial()
+
+	oldest := -1
+	for i, queued := range q.messages {
+		if queuedType, _ := queued.raw.Type(); queuedType != MessageTypeRapidWind {
+			continue
+		}
+
+		if oldest < 0 {
+			oldest = i
+		}
+
+		// Keep the newer wind reading in the older one's place.
+		if queuedSensor, _ := queued.raw.SensorSerial(); msgType == MessageTypeRapidWind && queuedSensor == sensor {
+			q.stats.coalesced.Add(1)
+			q.messages[i] = msg
+			return true
+		}
+	}
+
+	if oldest < 0 {
+		return false
+	}
+
+	q.stats.coalesced.Add(1)
+	q.messages = append(q.messages[:oldest], q.messages[oldest+1:]...)
+	q.append(msg)
+
+	return true
+}
+
+// drop counts and logs a message dropped from the full queue.
+func (q *queue) drop(msg networkMessage, which string) {
+	q.stats.queueDropped.Add(1)
+
+	if q.logger.Enabled(context.Background(), slog.LevelDebug) {
+		q.logger.Debug("dropping "+which+" message from full queue", msg.logAttrs()...)
+	}
+}
+
+// pop returns the oldest queued message, waiting for one to be pushed.
+// pop returns false once the queue is closed and empty.
+func (q *queue) pop() (networkMessage, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.messages) == 0 {
+		if q.closed {
+			return networkMessage{}, false
+		}
+
+		q.changed.Wait()
+	}
+
+	msg := q.messages[0]
+	q.messages[0] = networkMessage{}
+	q.messages = q.messages[1:]
+	q.changed.Broadcast()
+
+	return msg, true
+}
+
+// close stops the queue once the queued messages have been popped.
+func (q *queue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.changed.Broadcast()
+}
@@ -0,0 +1,157 @@
+package tempest
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// queueMessage returns a message of the type from the sensor.
+func queueMessage(msgType Type, sensor string) networkMessage {
+	return networkMessage{raw: RawMessage{
+		messageType:  string(msgType),
+		hubSerial:    "HB-00000001",
+		sensorSerial: sensor,
+	}}
+}
+
+// drain pops every queued message of a closed queue.
+func drain(q *queue) []networkMessage {
+	q.close()
+
+	messages := make([]networkMessage, 0)
+	for msg, ok := q.pop(); ok; msg, ok = q.pop() {
+		messages = append(messages, msg)
+	}
+
+	return messages
+}
+
+func TestQueue_Overflow(t *testing.T) {
+	wind := queueMessage(MessageTypeRapidWind, "ST-1")
+	otherWind := queueMessage(MessageTypeRapidWind, "ST-2")
+	observation := queueMessage(MessageTypeObservation, "ST-1")
+	status := queueMessage(MessageTypeDeviceStatus, "ST-1")
+
+	tests := []struct {
+		policy    OverflowPolicy
+		pushed    []networkMessage
+		expected  []networkMessage
+		dropped   uint64
+		coalesced uint64
+	}{
+		{OverflowDropOldest, []networkMessage{wind, observation, status}, []networkMessage{observation, status}, 1, 0},
+		{OverflowDropNewest, []networkMessage{wind, observation, status}, []networkMessage{wind, observation}, 1, 0},
+		{OverflowCoalesce, []networkMessage{otherWind, wind, status}, []networkMessage{wind, status}, 0, 1},
+		{OverflowCoalesce, []networkMessage{observation, status, wind}, []networkMessage{observation, status}, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			var counters stats
+			q := newQueue(QueueConfig{Size: 2, Overflow: tt.policy}, &counters, slog.Default())
+
+			for _, msg := range tt.pushed {
+				if !q.push(context.Background(), msg) {
+					t.Fatal("push failed")
+				}
+			}
+
+			messages := drain(q)
+			if len(messages) != len(tt.expected) {
+				t.Fatalf("expected %d messages, got %d", len(tt.expected), len(messages))
+			}
+
+			for i, msg := range messages {
+				msgType, _ := msg.raw.Type()
+				sensor, _ := msg.raw.SensorSerial()
+				expectedType, _ := tt.expected[i].raw.Type()
+				expectedSensor, _ := tt.expected[i].raw.SensorSerial()
+				if msgType != expectedType || sensor != expectedSensor {
+					t.Errorf("expected %s from %s at %d, got %s from %s", expectedType, expectedSensor, i, msgType, sensor)
+				}
+			}
+
+			if stats := counters.snapshot(); stats.QueueDropped != tt.dropped || stats.Coalesced != tt.coalesced {
+				t.Errorf("expected %d dropped and %d coalesced, got %+v", tt.dropped, tt.coalesced, stats)
+			}
+		})
+	}
+}
+
+func TestQueue_CoalesceReplacesSensorWind(t *testing.T) {
+	var counters stats
+	q := newQueue(QueueConfig{Size: 2, Overflow: OverflowCoalesce}, &counters, slog.Default())
+
+	older := queueMessage(MessageTypeRapidWind, "ST-1")
+	newer := queueMessage(MessageTypeRapidWind, "ST-1")
+	newer.raw["ob"] = []interface{}{1.0, 2.0, 3.0}
+
+	for _, msg := range []networkMessage{older, queueMessage(MessageTypeObservation, "ST-1"), newer} {
+		q.push(context.Background(), msg)
+	}
+
+	messages := drain(q)
+	if len(messages) != 2 || messages[0].raw["ob"] == nil {
+		t.Errorf("expected the newer wind first, got %v", messages)
+	}
+}
+
+func TestQueue_Block(t *testing.T) {
+	var counters stats
+	q := newQueue(QueueConfig{Size: 1}, &counters, slog.Default())
+	q.push(context.Background(), queueMessage(MessageTypeRapidWind, "ST-1"))
+
+	// A push to the full queue waits for a pop.
+	pushed := make(chan bool)
+	go func() {
+		pushed <- q.push(context.Background(), queueMessage(MessageTypeObservation, "ST-1"))
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatal("push to a full queue did not block")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	q.pop()
+	if !<-pushed {
+		t.Error("expected push to succeed after pop")
+	}
+
+	// A push waiting for room stops when the context is cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		pushed <- q.push(ctx, queueMessage(MessageTypeObservation, "ST-1"))
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case ok := <-pushed:
+		if ok {
+			t.Error("expected cancelled push to fail")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cancelled push did not return")
+	}
+
+	if stats := counters.snapshot(); stats.QueueDropped != 0 {
+		t.Errorf("expected no drops when blocking, got %+v", stats)
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for _, policy := range []OverflowPolicy{OverflowBlock, OverflowDropOldest, OverflowDropNewest, OverflowCoalesce} {
+		parsed, err := ParseOverflowPolicy(policy.String())
+		if err != nil || parsed != policy {
+			t.Errorf("expected %s, got %s (%v)", policy, parsed, err)
+		}
+	}
+
+	if _, err := ParseOverflowPolicy("spill"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
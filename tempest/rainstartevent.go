@@ -1,12 +1,48 @@
 package tempest
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
-// RainStartEvent represents a rain start event.
+// RainStartEvent represents a rain start event reported by a sensor
+// to the hub.
 type RainStartEvent struct {
-	SensorSerialNumber string    `json:"serial_number"` // Serial number of the sensor reporting the event to the hub
-	Hub                Hub       `json:"hub"`           // The hub reporting the event to the network.
-	EventTime          time.Time `json:"evt"`           // Timestamp(Epoch UTC) of the event.
+	Sensor    *WeatherSensor // Sensor reporting the event to the hub.
+	Hub       *Hub           // The hub reporting the event to the network.
+	EventTime time.Time      // Timestamp(Epoch UTC) of the event.
+}
+
+// NewRainStartEvent creates a new rain start event with a rain
+// start raw message from the hub.
+func NewRainStartEvent(raw RawMessage, sensor *WeatherSensor, hub *Hub) (*RainStartEvent, error) {
+	event := &RainStartEvent{
+		Sensor: sensor,
+		Hub:    hub,
+	}
+
+	rawEvt, found := raw[eventEvent]
+	if !found {
+		return nil, fmt.Errorf("rain start event not found")
+	}
+
+	evt, ok := rawEvt.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("rain start event is not a float64 slice")
+	}
+
+	if len(evt) != 1 {
+		return nil, fmt.Errorf("rain start event does not have 1 element")
+	}
+
+	// The only element is the timestamp of the event.
+	if ts, ok := evt[0].(float64); ok {
+		event.EventTime = time.Unix(int64(ts), 0)
+	} else {
+		return nil, fmt.Errorf("rain start event timestamp cannot be converted to a float64")
+	}
+
+	return event, nil
 }
 
 // Type returns the message type for the rain start event.
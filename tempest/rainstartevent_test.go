@@ -1 +1,36 @@
 package tempest
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNetwork_DecodeRainStart(t *testing.T) {
+	n := NewNetwork("test")
+	msg := testNetworkMessage(t, `{"serial_number":"SK-00008453","type":"evt_precip","hub_sn":"HB-00000001","evt":[1493322445]}`)
+
+	decoded, err := n.decode(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("error decoding rain start: %v", err)
+	}
+
+	event, ok := decoded.(*RainStartEvent)
+	if !ok {
+		t.Fatalf("unexpected message: %T", decoded)
+	}
+
+	if !event.Time().Equal(time.Unix(1493322445, 0)) {
+		t.Errorf("unexpected event time: %v", event.Time())
+	}
+
+	// The event must reference the hub and sensor held by the network.
+	hub := n.hubs.hubs["HB-00000001"]
+	if event.Hub != hub {
+		t.Errorf("event hub is not the network's hub")
+	}
+
+	if event.Sensor != hub.WeatherSensors["SK-00008453"] {
+		t.Errorf("event sensor is not the network's sensor")
+	}
+}
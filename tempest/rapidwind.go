@@ -63,3 +63,13 @@ func NewRapidWindEvent(raw RawMessage, sensor *WeatherSensor, hub *Hub) (*RapidW
 
 	return event, nil
 }
+
+// Type returns the message type for the rapid wind event.
+func (r *RapidWindEvent) Type() Type {
+	return MessageTypeRapidWind
+}
+
+// Time returns the time of the rapid wind event.
+func (r *RapidWindEvent) Time() time.Time {
+	return r.EventTime
+}
@@ -24,9 +24,9 @@ func (m RawMessage) HubSerial() (string, error) {
 		return "", fmt.Errorf("hub serial is not a string")
 	}
 
-	// A hub status message will have the hub serial number under the key
-	// "serial_number".
-	if msgType, err := m.Type(); err == nil && msgType == MessageTypeHubStatus {
+	// Messages reported by hubs themselves, like hub status messages,
+	// have the hub serial number under the key "serial_number".
+	if m.hubMessage() {
 		if hub, found := m[sensorSerial]; found {
 			if serial, ok := hub.(string); ok {
 				return serial, nil
@@ -39,16 +39,32 @@ func (m RawMessage) HubSerial() (string, error) {
 	return "", fmt.Errorf("hub serial not found")
 }
 
+// hubMessage returns true if the message type is registered as reported
+// by hubs themselves.
+func (m RawMessage) hubMessage() bool {
+	msgType, err := m.Type()
+	if err != nil {
+		return false
+	}
+
+	decoder, found := lookupDecoder(msgType)
+	return found && decoder.hub
+}
+
 // SensorSerial returns the raw message's weather sensor serial number.
 func (m RawMessage) SensorSerial() (string, error) {
-	// A hub status message will not have a sensor serial number.
-	if msgType, err := m.Type(); err == nil && msgType == MessageTypeHubStatus {
-		return "", fmt.Errorf("weather sensor serial not available in hub status message")
-	} else if err != nil {
+	msgType, err := m.Type()
+	if err != nil {
 		return "", fmt.Errorf("error getting message type: %v", err)
 	}
 
-	// Valid message type that is not a hub status message.
+	// Messages reported by hubs themselves, like hub status, will not
+	// have a sensor serial number.
+	if m.hubMessage() {
+		return "", fmt.Errorf("weather sensor serial not available in %s message", msgType)
+	}
+
+	// Valid message type reported by a sensor.
 	if sensor, found := m[sensorSerial]; found {
 		if serial, ok := sensor.(string); ok {
 			return serial, nil
@@ -85,3 +101,18 @@ func (m RawMessage) Type() (Type, error) {
 	// Message type not found.
 	return "", fmt.Errorf("message type not found")
 }
+
+// number returns the raw message's numeric value for the key.
+func (m RawMessage) number(key string) (float64, error) {
+	value, found := m[key]
+	if !found {
+		return 0, fmt.Errorf("%s not found", key)
+	}
+
+	number, ok := value.(float64)
+	if !ok {
+		return 0, fmt.Errorf("%s is not a number", key)
+	}
+
+	return number, nil
+}
@@ -0,0 +1,299 @@
+package tempest
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A Record is a datagram saved to a capture file. Capture files hold
+// one JSON encoded record per line (JSON Lines).
+type Record struct {
+	Received  time.Time `json:"received"`         // Time the datagram was received.
+	Source    string    `json:"source"`           // IP address of the hub that sent the datagram.
+	HubSerial string    `json:"hub_sn,omitempty"` // Serial number of the hub, if the datagram has one.
+	Datagram  string    `json:"datagram"`         // The datagram as received.
+}
+
+// RecorderConfig configures where a Recorder writes capture files and
+// when it starts a new file.
+type RecorderConfig struct {
+	Dir     string        // Directory for capture files.
+	Prefix  string        // Capture file name prefix. Defaults to "tempest".
+	MaxSize int64         // Start a new file after writing this many bytes. Zero for no limit.
+	MaxAge  time.Duration // Start a new file after this long. Zero for no limit.
+	Gzip    bool          // Compress capture files with gzip.
+
+	// Write buffered records to the file at least this often, so a crash
+	// loses at most this long of records. Zero writes every record as it
+	// is recorded. Compressed files compress better with an interval.
+	FlushInterval time.Duration
+}
+
+// Recorder writes datagrams to rotating JSON Lines capture files.
+// A Recorder is safe for concurrent use.
+type Recorder struct {
+	config RecorderConfig
+
+	mu      sync.Mutex
+	file    *os.File
+	gzip    *gzip.Writer
+	writer  *bufio.Writer
+	opened  time.Time // Receive time of the first record in the file.
+	written int64     // Uncompressed bytes written to the file.
+	files   int       // Number of files opened.
+
+	flushTimer *time.Timer // Flushes buffered records, nil if none are buffered.
+	flushErr   error       // Error flushing in the background, returned by the next Record.
+}
+
+// NewRecorder returns a recorder writing capture files to the config's
+// directory. The directory is created if it does not exist. The first
+// file is created when the first datagram is recorded.
+func NewRecorder(config RecorderConfig) (*Recorder, error) {
+	if config.Prefix == "" {
+		config.Prefix = "tempest"
+	}
+
+	if config.Dir == "" {
+		config.Dir = "."
+	}
+
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating capture directory: %w", err)
+	}
+
+	return &Recorder{config: config}, nil
+}
+
+// NewRecord returns the record for the datagram.
+func NewRecord(datagram Datagram) Record {
+	record := Record{
+		Received: datagram.Received,
+		Source:   datagram.Source.String(),
+		Datagram: string(datagram.Data),
+	}
+
+	if record.Received.IsZero() {
+		record.Received = time.Now()
+	}
+
+	var raw RawMessage
+	if err := json.Unmarshal(datagram.Data, &raw); err == nil {
+		if serial, err := raw.HubSerial(); err == nil {
+			record.HubSerial = serial
+		}
+	}
+
+	return record
+}
+
+// Record writes the datagram to the current capture file, starting a
+// new file when the current file reaches its size or age limit.
+func (r *Recorder) Record(datagram Datagram) error {
+	record := NewRecord(datagram)
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error encoding record: %w", err)
+	}
+	line = append(line, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.shouldRotate(record.Received) {
+		if err := r.rotate(record.Received); err != nil {
+			return err
+		}
+	}
+
+	if err := r.flushErr; err != nil {
+		r.flushErr = nil
+		return fmt.Errorf("error writing record: %w", err)
+	}
+
+	if _, err := r.writer.Write(line); err != nil {
+		return fmt.Errorf("error writing record: %w", err)
+	}
+	r.written += int64(len(line))
+
+	if r.config.FlushInterval <= 0 {
+		if err := r.flush(); err != nil {
+			return fmt.Errorf("error writing record: %w", err)
+		}
+	} else if r.flushTimer == nil {
+		r.flushTimer = time.AfterFunc(r.config.FlushInterval, r.flushBuffered)
+	}
+
+	return nil
+}
+
+// Flush writes buffered records to the current capture file.
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.flush()
+}
+
+// Close flushes and closes the current capture file.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.flushTimer != nil {
+		r.flushTimer.Stop()
+		r.flushTimer = nil
+	}
+
+	return r.closeFile()
+}
+
+// flushBuffered writes the records buffered since the flush interval
+// started, keeping an error for the next Record to return.
+func (r *Recorder) flushBuffered() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.flushTimer = nil
+	if err := r.flush(); err != nil && r.flushErr == nil {
+		r.flushErr = err
+	}
+}
+
+// flush writes buffered records to the current capture file, if any.
+// The caller must hold the lock.
+func (r *Recorder) flush() error {
+	if r.writer == nil {
+		return nil
+	}
+
+	if err := r.writer.Flush(); err != nil {
+		return err
+	}
+
+	if r.gzip != nil {
+		return r.gzip.Flush()
+	}
+
+	return nil
+}
+
+// shouldRotate returns true if a record received at the time must be
+// written to a new file. The caller must hold the lock.
+func (r *Recorder) shouldRotate(received time.Time) bool {
+	if r.file == nil {
+		return true
+	}
+
+	if r.config.MaxSize > 0 && r.written >= r.config.MaxSize {
+		return true
+	}
+
+	return r.config.MaxAge > 0 && received.Sub(r.opened) >= r.config.MaxAge
+}
+
+// rotate closes the current file and opens a new file named for the
+// time. The caller must hold the lock.
+func (r *Recorder) rotate(received time.Time) error {
+	if err := r.closeFile(); err != nil {
+		return err
+	}
+
+	r.files++
+	name := fmt.Sprintf("%s-%s-%04d.jsonl", r.config.Prefix, received.UTC().Format("20060102T150405Z"), r.files)
+	if r.config.Gzip {
+		name += ".gz"
+	}
+
+	file, err := os.OpenFile(filepath.Join(r.config.Dir, name), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("error creating capture file: %w", err)
+	}
+
+	var w io.Writer = file
+	if r.config.Gzip {
+		r.gzip = gzip.NewWriter(file)
+		w = r.gzip
+	}
+
+	r.file = file
+	r.writer = bufio.NewWriter(w)
+	r.opened = received
+	r.written = 0
+
+	return nil
+}
+
+// closeFile flushes and closes the current file, if any. The caller
+// must hold the lock.
+func (r *Recorder) closeFile() error {
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.writer.Flush()
+	if r.gzip != nil {
+		if gzErr := r.gzip.Close(); err == nil {
+			err = gzErr
+		}
+	}
+
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	r.file = nil
+	r.gzip = nil
+	r.writer = nil
+
+	if err != nil {
+		return fmt.Errorf("error closing capture file: %w", err)
+	}
+
+	return nil
+}
+
+// RecordingSource records every datagram read from a source before
+// returning it.
+type RecordingSource struct {
+	source   Source
+	recorder *Recorder
+}
+
+// NewRecordingSource returns a source that records the datagrams read
+// from source with the recorder. Recording errors are returned from
+// Read and stop the network reading the source.
+func NewRecordingSource(source Source, recorder *Recorder) *RecordingSource {
+	return &RecordingSource{
+		source:   source,
+		recorder: recorder,
+	}
+}
+
+// Read returns the next datagram from the source after recording it.
+func (r *RecordingSource) Read(ctx context.Context) (Datagram, error) {
+	datagram, err := r.source.Read(ctx)
+	if err != nil {
+		return datagram, err
+	}
+
+	if err := r.recorder.Record(datagram); err != nil {
+		return Datagram{}, err
+	}
+
+	return datagram, nil
+}
+
+// Close closes the source. The recorder is not closed.
+func (r *RecordingSource) Close() error {
+	return r.source.Close()
+}
@@ -0,0 +1,196 @@
+package tempest
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// readCaptureNames returns the capture file names in dir in order.
+func readCaptureNames(t *testing.T, dir string) []string {
+	t.Helper()
+
+	names, err := filepath.Glob(filepath.Join(dir, "*.jsonl*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// readCaptures returns the records in the capture files in dir in
+// file name order.
+func readCaptures(t *testing.T, dir string) [][]Record {
+	t.Helper()
+
+	names := readCaptureNames(t, dir)
+
+	files := make([][]Record, 0)
+	for _, name := range names {
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var scanner *bufio.Scanner
+		if filepath.Ext(name) == ".gz" {
+			// A file not yet written to has no gzip header.
+			gz, err := gzip.NewReader(file)
+			if errors.Is(err, io.EOF) {
+				file.Close()
+				files = append(files, make([]Record, 0))
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			scanner = bufio.NewScanner(gz)
+		} else {
+			scanner = bufio.NewScanner(file)
+		}
+
+		records := make([]Record, 0)
+		for scanner.Scan() {
+			var record Record
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				t.Fatalf("error decoding record: %v", err)
+			}
+			records = append(records, record)
+		}
+
+		file.Close()
+		files = append(files, records)
+	}
+
+	return files
+}
+
+func TestRecorder_Record(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := NewRecorder(RecorderConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := time.Unix(1493322445, 0).UTC()
+	datagram := Datagram{
+		Data:     []byte(`{"serial_number":"ST-00000512","type":"rapid_wind","hub_sn":"HB-00013030","ob":[1493322445,2.3,128]}`),
+		Source:   net.IPv4(192, 168, 1, 2),
+		Received: received,
+	}
+
+	if err := recorder.Record(datagram); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := recorder.Record(Datagram{Data: []byte(`not json`), Source: net.IPv4(192, 168, 1, 3), Received: received}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readCaptures(t, dir)
+	if len(files) != 1 || len(files[0]) != 2 {
+		t.Fatalf("unexpected capture files: %v", files)
+	}
+
+	record := files[0][0]
+	if !record.Received.Equal(received) || record.Source != "192.168.1.2" || record.HubSerial != "HB-00013030" {
+		t.Errorf("unexpected record: %+v", record)
+	}
+
+	if record.Datagram != string(datagram.Data) {
+		t.Errorf("unexpected datagram: %s", record.Datagram)
+	}
+
+	if files[0][1].Datagram != "not json" || files[0][1].HubSerial != "" {
+		t.Errorf("unexpected malformed record: %+v", files[0][1])
+	}
+}
+
+func TestRecorder_ReadWhileRecording(t *testing.T) {
+	data := []byte(`{"serial_number":"ST-00000512","type":"rapid_wind","hub_sn":"HB-00013030","ob":[1493322445,2.3,128]}`)
+
+	for _, config := range []RecorderConfig{{}, {Gzip: true}, {Gzip: true, FlushInterval: 10 * time.Millisecond}} {
+		dir := t.TempDir()
+		config.Dir = dir
+		recorder, err := NewRecorder(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 3; i++ {
+			if err := recorder.Record(Datagram{Data: data, Source: net.IPv4(192, 168, 1, 2), Received: time.Unix(int64(1493322445+i), 0)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Records are in the file while it is still being recorded to,
+		// once the flush interval has passed.
+		deadline := time.Now().Add(5 * time.Second)
+		files := readCaptures(t, dir)
+		for (len(files) != 1 || len(files[0]) != 3) && time.Now().Before(deadline) && config.FlushInterval > 0 {
+			time.Sleep(config.FlushInterval)
+			files = readCaptures(t, dir)
+		}
+
+		if len(files) != 1 || len(files[0]) != 3 {
+			t.Errorf("%+v: expected 3 records while recording, got %v", config, files)
+		}
+
+		if err := recorder.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRecorder_Rotate(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := NewRecorder(RecorderConfig{Dir: dir, MaxAge: time.Minute, MaxSize: 1024, Gzip: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1493322445, 0)
+	data := []byte(`{"serial_number":"ST-00000512","type":"rapid_wind","hub_sn":"HB-00013030","ob":[1493322445,2.3,128]}`)
+
+	// Rotate on age: 30 records 3 seconds apart span 90 seconds.
+	for i := 0; i < 30; i++ {
+		received := start.Add(time.Duration(i) * 3 * time.Second)
+		if err := recorder.Record(Datagram{Data: data, Source: net.IPv4(192, 168, 1, 2), Received: received}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readCaptures(t, dir)
+	if len(files) < 2 {
+		t.Fatalf("expected the recorder to rotate, got %d files", len(files))
+	}
+
+	total := 0
+	for _, records := range files {
+		total += len(records)
+		if len(records) > 0 && records[len(records)-1].Received.Sub(records[0].Received) >= time.Minute {
+			t.Errorf("file spans more than the max age")
+		}
+	}
+
+	if total != 30 {
+		t.Errorf("expected 30 records, got %d", total)
+	}
+}
@@ -0,0 +1,242 @@
+package tempest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ReplaySpeedUnlimited replays datagrams as fast as they can be read.
+const ReplaySpeedUnlimited = 0
+
+// ReplaySource reads captured datagrams and replays them to a Network
+// through the same decoding path as live traffic.
+//
+// Captures are JSON Lines files written by a Recorder, or pcap and
+// pcapng files holding UDP datagrams sent to Port. Gzip compressed
+// captures are decompressed. Replayed datagrams keep the time they
+// were originally received.
+type ReplaySource struct {
+	packets packetReader
+	closer  io.Closer
+	pacer   pacer
+
+	closed chan struct{}
+	once   sync.Once
+}
+
+// packetReader reads datagrams from a capture format.
+type packetReader interface {
+	next() (Datagram, error)
+}
+
+// NewReplaySource returns a source replaying the capture read from the
+// reader. The speed is a multiple of the original pace: 1 replays at
+// the original pace, 10 at ten times the pace and ReplaySpeedUnlimited
+// as fast as possible.
+func NewReplaySource(reader io.Reader, speed float64) (*ReplaySource, error) {
+	if speed < 0 {
+		return nil, fmt.Errorf("replay speed must not be negative: %f", speed)
+	}
+
+	packets, err := newPacketReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	source := &ReplaySource{
+		packets: packets,
+		pacer:   pacer{speed: speed},
+		closed:  make(chan struct{}),
+	}
+
+	if closer, ok := reader.(io.Closer); ok {
+		source.closer = closer
+	}
+
+	return source, nil
+}
+
+// OpenReplay returns a source replaying the capture file at the speed.
+// The file is closed when the source is closed.
+func OpenReplay(name string, speed float64) (*ReplaySource, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("error opening capture: %w", err)
+	}
+
+	source, err := NewReplaySource(file, speed)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return source, nil
+}
+
+// Read returns the next captured datagram once it is due at the
+// replay speed.
+func (r *ReplaySource) Read(ctx context.Context) (Datagram, error) {
+	select {
+	case <-r.closed:
+		return Datagram{}, io.EOF
+	default:
+	}
+
+	datagram, err := r.packets.next()
+	if err != nil {
+		return Datagram{}, err
+	}
+
+	if err := r.pacer.wait(ctx, r.closed, datagram.Received); err != nil {
+		return Datagram{}, err
+	}
+
+	return datagram, nil
+}
+
+// pacer paces datagrams at a multiple of the pace they were received.
+type pacer struct {
+	speed float64
+
+	// Receive and wall clock time of the first datagram.
+	first     time.Time
+	firstWall time.Time
+}
+
+// wait blocks until a datagram received at the time is due. Wait
+// returns io.EOF if closed is closed while waiting.
+func (p *pacer) wait(ctx context.Context, closed <-chan struct{}, received time.Time) error {
+	if p.speed == ReplaySpeedUnlimited || received.IsZero() {
+		return nil
+	}
+
+	if p.first.IsZero() {
+		p.first = received
+		p.firstWall = time.Now()
+		return nil
+	}
+
+	offset := time.Duration(float64(received.Sub(p.first)) / p.speed)
+	wait := time.Until(p.firstWall.Add(offset))
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-closed:
+		return io.EOF
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops the replay and closes the capture reader if it is an
+// io.Closer.
+func (r *ReplaySource) Close() error {
+	var err error
+	r.once.Do(func() {
+		close(r.closed)
+		if r.closer != nil {
+			err = r.closer.Close()
+		}
+	})
+
+	return err
+}
+
+// Capture file magic numbers.
+var (
+	gzipMagic   = []byte{0x1f, 0x8b}
+	pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+	pcapMagics  = [][]byte{
+		{0xa1, 0xb2, 0xc3, 0xd4}, // Big endian, microseconds.
+		{0xd4, 0xc3, 0xb2, 0xa1}, // Little endian, microseconds.
+		{0xa1, 0xb2, 0x3c, 0x4d}, // Big endian, nanoseconds.
+		{0x4d, 0x3c, 0xb2, 0xa1}, // Little endian, nanoseconds.
+	}
+)
+
+// newPacketReader returns a reader for the capture format detected
+// from the first bytes of the reader.
+func newPacketReader(reader io.Reader) (packetReader, error) {
+	buffered := bufio.NewReader(reader)
+
+	// Captures shorter than the magic number are read as JSON Lines.
+	magic, err := buffered.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error reading capture: %w", err)
+	}
+
+	if bytes.HasPrefix(magic, gzipMagic) {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("error reading gzip capture: %w", err)
+		}
+
+		return newPacketReader(gz)
+	}
+
+	if bytes.Equal(magic, pcapngMagic) {
+		return newPcapngReader(buffered), nil
+	}
+
+	for _, pcapMagic := range pcapMagics {
+		if bytes.Equal(magic, pcapMagic) {
+			return newPcapReader(buffered)
+		}
+	}
+
+	scanner := bufio.NewScanner(buffered)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	return &captureReader{scanner: scanner}, nil
+}
+
+// captureReader reads records from a JSON Lines capture.
+type captureReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// next returns the datagram in the next record.
+func (c *captureReader) next() (Datagram, error) {
+	for c.scanner.Scan() {
+		c.line++
+
+		line := bytes.TrimSpace(c.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			return Datagram{}, fmt.Errorf("error decoding capture line %d: %w", c.line, err)
+		}
+
+		return Datagram{
+			Data:     []byte(record.Datagram),
+			Source:   net.ParseIP(record.Source),
+			Received: record.Received,
+		}, nil
+	}
+
+	if err := c.scanner.Err(); err != nil {
+		return Datagram{}, fmt.Errorf("error reading capture: %w", err)
+	}
+
+	return Datagram{}, io.EOF
+}
@@ -0,0 +1,343 @@
+package tempest
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRapidWind = `{"serial_number":"ST-00000512","type":"rapid_wind","hub_sn":"HB-00013030","ob":[1493322445,2.3,128]}`
+
+// testEthernetPacket returns an Ethernet frame holding an IPv4 UDP
+// datagram with the payload sent from the source to the port.
+func testEthernetPacket(source net.IP, port uint16, payload string) []byte {
+	udp := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint16(udp[0:2], 50222)
+	binary.BigEndian.PutUint16(udp[2:4], port)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(len(udp)))
+	copy(udp[8:], payload)
+
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], uint16(20+len(udp)))
+	ip[8] = 64
+	ip[9] = protocolUDP
+	copy(ip[12:16], source.To4())
+	copy(ip[16:20], net.IPv4bcast.To4())
+
+	frame := make([]byte, 14)
+	binary.BigEndian.PutUint16(frame[12:14], etherTypeIPv4)
+
+	return append(append(frame, ip...), udp...)
+}
+
+func TestReplaySource_Capture(t *testing.T) {
+	received := time.Unix(1493322445, 500).UTC()
+	var capture bytes.Buffer
+	capture.WriteString(`{"received":"` + received.Format(time.RFC3339Nano) + `","source":"192.168.1.2","hub_sn":"HB-00013030","datagram":` + `"{\"serial_number\":\"ST-00000512\",\"type\":\"rapid_wind\",\"hub_sn\":\"HB-00013030\",\"ob\":[1493322445,2.3,128]}"}` + "\n")
+
+	source, err := NewReplaySource(&capture, ReplaySpeedUnlimited)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	datagram, err := source.Read(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(datagram.Data) != testRapidWind || !datagram.Source.Equal(net.IPv4(192, 168, 1, 2)) || !datagram.Received.Equal(received) {
+		t.Errorf("unexpected datagram: %+v", datagram)
+	}
+
+	if _, err := source.Read(context.Background()); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestReplaySource_Pcap(t *testing.T) {
+	var capture bytes.Buffer
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], 65535)
+	binary.LittleEndian.PutUint32(header[20:24], linkTypeEthernet)
+	capture.Write(header)
+
+	packets := [][]byte{
+		testEthernetPacket(net.IPv4(192, 168, 1, 9), 53, "dns"),
+		testEthernetPacket(net.IPv4(192, 168, 1, 2), Port, testRapidWind),
+	}
+
+	for i, packet := range packets {
+		record := make([]byte, 16)
+		binary.LittleEndian.PutUint32(record[0:4], uint32(1493322445+i))
+		binary.LittleEndian.PutUint32(record[4:8], 250000)
+		binary.LittleEndian.PutUint32(record[8:12], uint32(len(packet)))
+		binary.LittleEndian.PutUint32(record[12:16], uint32(len(packet)))
+		capture.Write(record)
+		capture.Write(packet)
+	}
+
+	source, err := NewReplaySource(&capture, ReplaySpeedUnlimited)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	datagram, err := source.Read(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(datagram.Data) != testRapidWind || !datagram.Source.Equal(net.IPv4(192, 168, 1, 2)) {
+		t.Errorf("unexpected datagram: %s from %s", datagram.Data, datagram.Source)
+	}
+
+	if !datagram.Received.Equal(time.Unix(1493322446, 250000000)) {
+		t.Errorf("unexpected receive time: %v", datagram.Received)
+	}
+
+	if _, err := source.Read(context.Background()); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+// pcapngBlock returns a little endian pcapng block with the body.
+func pcapngBlock(blockType uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+
+	block := make([]byte, 8, 12+len(body))
+	binary.LittleEndian.PutUint32(block[0:4], blockType)
+	binary.LittleEndian.PutUint32(block[4:8], uint32(12+len(body)))
+	block = append(block, body...)
+
+	return binary.LittleEndian.AppendUint32(block, uint32(12+len(body)))
+}
+
+// pcapngCapture returns a pcapng capture of a rapid wind datagram on an
+// Ethernet interface with the timestamp resolution.
+func pcapngCapture(tsresol byte, timestamp uint64) []byte {
+	var capture bytes.Buffer
+
+	section := make([]byte, 16)
+	binary.LittleEndian.PutUint32(section[0:4], 0x1a2b3c4d)
+	binary.LittleEndian.PutUint16(section[4:6], 1)
+	binary.LittleEndian.PutUint64(section[8:16], ^uint64(0))
+	capture.Write(pcapngBlock(pcapngSectionHeader, section))
+
+	iface := make([]byte, 8)
+	binary.LittleEndian.PutUint16(iface[0:2], linkTypeEthernet)
+	iface = append(iface, 9, 0, 1, 0, tsresol, 0, 0, 0, 0, 0, 0, 0)
+	capture.Write(pcapngBlock(pcapngInterfaceDescription, iface))
+
+	packet := testEthernetPacket(net.IPv4(192, 168, 1, 2), Port, testRapidWind)
+	enhanced := make([]byte, 20)
+	binary.LittleEndian.PutUint32(enhanced[4:8], uint32(timestamp>>32))
+	binary.LittleEndian.PutUint32(enhanced[8:12], uint32(timestamp))
+	binary.LittleEndian.PutUint32(enhanced[12:16], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(enhanced[16:20], uint32(len(packet)))
+	capture.Write(pcapngBlock(pcapngEnhancedPacket, append(enhanced, packet...)))
+
+	return capture.Bytes()
+}
+
+func TestReplaySource_Pcapng(t *testing.T) {
+	// Nanosecond timestamps.
+	capture := pcapngCapture(9, uint64(time.Unix(1493322445, 123).UnixNano()))
+	source, err := NewReplaySource(bytes.NewReader(capture), ReplaySpeedUnlimited)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	datagram, err := source.Read(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(datagram.Data) != testRapidWind || !datagram.Received.Equal(time.Unix(1493322445, 123)) {
+		t.Errorf("unexpected datagram: %s at %v", datagram.Data, datagram.Received)
+	}
+
+	if _, err := source.Read(context.Background()); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestReplaySource_PcapngResolution(t *testing.T) {
+	tests := []struct {
+		name      string
+		tsresol   byte
+		timestamp uint64
+		expected  time.Time
+	}{
+		{"microseconds", 6, 1493322445_123456, time.Unix(1493322445, 123456000)},
+		{"nanoseconds", 9, 1493322445_123456789, time.Unix(1493322445, 123456789)},
+		{"picoseconds", 12, 1493322_123456789012, time.Unix(1493322, 123456789)},
+		{"power of two", 0x80 | 20, 1493322445<<20 | 1<<19 | 1, time.Unix(1493322445, 500000953)},
+		{"power of two below a nanosecond", 0x80 | 40, 3<<40 | 1<<38, time.Unix(3, 250000000)},
+		{"beyond a uint64", 20, 5e18, time.Unix(0, 50000000)},
+		{"beyond a uint64 power of two", 0x80 | 70, 1 << 63, time.Unix(0, 7812500)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source, err := NewReplaySource(bytes.NewReader(pcapngCapture(test.tsresol, test.timestamp)), ReplaySpeedUnlimited)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			datagram, err := source.Read(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !datagram.Received.Equal(test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, datagram.Received)
+			}
+		})
+	}
+}
+
+func TestReplaySource_InvalidLength(t *testing.T) {
+	pcap := make([]byte, 24+16)
+	binary.LittleEndian.PutUint32(pcap[0:4], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint32(pcap[16:20], 65535)
+	binary.LittleEndian.PutUint32(pcap[20:24], linkTypeEthernet)
+	binary.LittleEndian.PutUint32(pcap[24+8:24+12], 1<<31)
+
+	section := make([]byte, 16)
+	binary.LittleEndian.PutUint32(section[0:4], 0x1a2b3c4d)
+	block := make([]byte, 8)
+	binary.LittleEndian.PutUint32(block[0:4], pcapngEnhancedPacket)
+	binary.LittleEndian.PutUint32(block[4:8], 1<<31)
+	pcapng := append(pcapngBlock(pcapngSectionHeader, section), block...)
+
+	// Lengths larger than a packet or block can be are rejected before
+	// they are read.
+	for name, capture := range map[string][]byte{"pcap": pcap, "pcapng": pcapng} {
+		source, err := NewReplaySource(bytes.NewReader(capture), ReplaySpeedUnlimited)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := source.Read(context.Background()); err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Errorf("%s: expected an invalid length error, got %v", name, err)
+		}
+	}
+}
+
+func TestReplaySource_Speed(t *testing.T) {
+	capture := strings.Join([]string{
+		`{"received":"2017-04-27T19:47:25Z","source":"192.168.1.2","datagram":"{}"}`,
+		`{"received":"2017-04-27T19:47:26Z","source":"192.168.1.2","datagram":"{}"}`,
+	}, "\n")
+
+	// One second apart at ten times the speed is 100ms apart.
+	source, err := NewReplaySource(strings.NewReader(capture), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if _, err := source.Read(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond || elapsed > time.Second {
+		t.Errorf("unexpected replay pace: %v", elapsed)
+	}
+}
+
+func TestNetwork_RunReplay(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := NewRecorder(RecorderConfig{Dir: dir, Gzip: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		datagram := Datagram{Data: []byte(testRapidWind), Source: net.IPv4(192, 168, 1, 2), Received: time.Unix(int64(1493322445+i), 0)}
+		if err := recorder.Record(datagram); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names := readCaptureNames(t, dir)
+	source, err := OpenReplay(names[0], ReplaySpeedUnlimited)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewNetwork("test")
+	sub := n.Subscribe(MessageTypeRapidWind)
+	defer sub.Unsubscribe()
+
+	if err := n.RunSources(context.Background(), source); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sub.Messages()) != 3 {
+		t.Errorf("expected 3 replayed messages, got %d", len(sub.Messages()))
+	}
+}
+
+func TestNetwork_ReplayBlocking(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := NewRecorder(RecorderConfig{Dir: dir, FlushInterval: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const count = 2000
+	for i := 0; i < count; i++ {
+		datagram := Datagram{Data: []byte(testRapidWind), Source: net.IPv4(192, 168, 1, 2), Received: time.Unix(int64(1493322445+i), 0)}
+		if err := recorder.Record(datagram); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := OpenReplay(readCaptureNames(t, dir)[0], ReplaySpeedUnlimited)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A blocking subscriber receives every message however slowly it
+	// reads them.
+	n := NewNetwork("test")
+	sub := n.SubscribeBlocking(0, MessageTypeRapidWind)
+	received := make(chan int)
+	go func() {
+		messages := 0
+		for range sub.Messages() {
+			messages++
+		}
+		received <- messages
+	}()
+
+	if err := n.RunSources(context.Background(), source); err != nil {
+		t.Fatal(err)
+	}
+	sub.Unsubscribe()
+
+	if messages := <-received; messages != count || sub.Dropped() != 0 {
+		t.Errorf("expected %d replayed messages, got %d with %d dropped", count, messages, sub.Dropped())
+	}
+}
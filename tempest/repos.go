@@ -2,9 +2,16 @@ package tempest
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"time"
 )
 
+// ErrUnsupportedMessage is returned by a MessageRepo asked to save a
+// message of a type it does not store.
+var ErrUnsupportedMessage = errors.New("unsupported message type")
+
 // HubRepo is an interface for storing and loading Tempest hubs.
 type HubRepo interface {
 	LoadHubs(ctx context.Context) ([]*Hub, error)
@@ -13,12 +20,329 @@ type HubRepo interface {
 
 // SensorRepo is an interface for storing and loading weather sensors.
 type SensorRepo interface {
-	LoadSensors() ([]*WeatherSensor, error)
-	SaveSensor(*WeatherSensor) error
+	LoadSensors(ctx context.Context) ([]*WeatherSensor, error)
+	SaveSensor(ctx context.Context, sensor *WeatherSensor) error
 }
 
+// MessageRepo is an interface for storing and querying decoded
+// messages.
+//
+// StreamMessages calls fn with each message selected by the query
+// ordered by time, until fn returns false. Messages with the same time
+// are streamed in the same order on every call, which QueryMessages
+// relies on to page through messages.
+//
+// LatestMessages returns the latest message selected by the query from
+// each sensor, or from each hub for messages reported by hubs
+// themselves, ordered by serial number.
 type MessageRepo interface {
-	LoadMessages(ctx context.Context, start, end time.Time, msg Type)
-	SaveMessage(ctx context.Context, message WeatherMessage)
-	DeleteMessages(ctx context.Context, start, end time.Time, msg Type)
+	SaveMessage(ctx context.Context, message WeatherMessage) error
+	StreamMessages(ctx context.Context, query MessageQuery, fn func(message WeatherMessage) bool) error
+	LatestMessages(ctx context.Context, query MessageQuery) ([]WeatherMessage, error)
+	DeleteMessages(ctx context.Context, query MessageQuery) error
+}
+
+// A MessageQuery selects stored messages. Zero fields select every
+// message.
+type MessageQuery struct {
+	Start        time.Time // Messages at or after the start time.
+	End          time.Time // Messages before the end time.
+	Types        []Type    // Messages of any of the types.
+	HubSerial    string    // Messages reported by the hub.
+	SensorSerial string    // Messages reported by the sensor.
+}
+
+// match returns true if a message with the type, time and serial
+// numbers is selected by the query.
+func (q MessageQuery) match(msgType Type, t time.Time, hub string, sensor string) bool {
+	if !q.Start.IsZero() && t.Before(q.Start) {
+		return false
+	}
+
+	if !q.End.IsZero() && !t.Before(q.End) {
+		return false
+	}
+
+	if q.HubSerial != "" && hub != q.HubSerial {
+		return false
+	}
+
+	if q.SensorSerial != "" && sensor != q.SensorSerial {
+		return false
+	}
+
+	if len(q.Types) == 0 {
+		return true
+	}
+
+	for _, selected := range q.Types {
+		if msgType == selected {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matches returns true if the message is selected by the query.
+func (q MessageQuery) matches(message WeatherMessage) bool {
+	hub, sensor := messageSerials(message)
+	return q.match(message.Type(), message.Time(), hub, sensor)
+}
+
+// messageSerials returns the serial numbers of the hub and sensor that
+// reported the message. The sensor serial number is empty for messages
+// reported by hubs themselves and both are empty for message types
+// registered outside this package.
+func messageSerials(message WeatherMessage) (hub string, sensor string) {
+	var h *Hub
+	var s *WeatherSensor
+
+	switch m := message.(type) {
+	case *Observation:
+		return m.HubSerial, m.SensorSerial
+	case *AirObservation:
+		return m.HubSerial, m.SensorSerial
+	case *SkyObservation:
+		return m.HubSerial, m.SensorSerial
+	case *RapidWindEvent:
+		h, s = m.Hub, m.Sensor
+	case *LightningStrikeEvent:
+		h, s = m.Hub, m.Sensor
+	case *RainStartEvent:
+		h, s = m.Hub, m.Sensor
+	case *DeviceStatus:
+		h, s = m.Hub, m.Sensor
+	case *HubStatus:
+		h = m.Hub
+	case *HubOnline:
+		h = m.Hub
+	case *HubOffline:
+		h = m.Hub
+	case *SensorOnline:
+		h, s = m.Hub, m.Sensor
+	case *SensorOffline:
+		h, s = m.Hub, m.Sensor
+	case *Aggregate:
+		return m.HubSerial, m.SensorSerial
+	}
+
+	if h != nil {
+		hub = h.HubSerialNumber
+	}
+
+	if s != nil {
+		sensor = s.SensorSerial
+	}
+
+	return hub, sensor
+}
+
+// latestKey returns the key LatestMessages keeps the latest message
+// for: the sensor serial number, or the hub serial number for messages
+// reported by hubs themselves.
+func latestKey(hub string, sensor string) string {
+	if sensor != "" {
+		return sensor
+	}
+
+	return hub
+}
+
+// A MessagePage is a page of messages returned by QueryMessages.
+type MessagePage struct {
+	Messages []WeatherMessage // Messages ordered by time.
+	Next     string           // Cursor of the next page, empty on the last page.
+}
+
+// QueryMessages returns a page of up to limit messages selected by the
+// query from the repo. Pass an empty cursor for the first page and the
+// page's Next cursor for the following pages. A limit of zero returns
+// every message in one page.
+func QueryMessages(ctx context.Context, repo MessageRepo, query MessageQuery, limit int, cursor string) (MessagePage, error) {
+	// The cursor is the time of the last message returned and the
+	// number of messages returned at that time.
+	var last time.Time
+	var atLast int
+	if cursor != "" {
+		var nanos int64
+		if _, err := fmt.Sscanf(cursor, "%d-%d", &nanos, &atLast); err != nil || atLast < 0 {
+			return MessagePage{}, fmt.Errorf("invalid cursor: %s", cursor)
+		}
+
+		last = time.Unix(0, nanos)
+		if query.Start.Before(last) {
+			query.Start = last
+		}
+	}
+
+	page := MessagePage{Messages: make([]WeatherMessage, 0)}
+	skip := atLast
+	more := false
+	err := repo.StreamMessages(ctx, query, func(message WeatherMessage) bool {
+		if skip > 0 && message.Time().Equal(last) {
+			skip--
+			return true
+		}
+
+		if limit > 0 && len(page.Messages) == limit {
+			more = true
+			return false
+		}
+
+		page.Messages = append(page.Messages, message)
+		if message.Time().Equal(last) {
+			atLast++
+		} else {
+			last, atLast = message.Time(), 1
+		}
+
+		return true
+	})
+	if err != nil {
+		return MessagePage{}, err
+	}
+
+	if more {
+		page.Next = fmt.Sprintf("%d-%d", last.UnixNano(), atLast)
+	}
+
+	return page, nil
+}
+
+// WithHubRepo sets the repo the network loads known hubs from when it
+// starts and saves hubs to as they are discovered.
+func WithHubRepo(repo HubRepo) Option {
+	return func(n *Network) {
+		n.hubRepo = repo
+	}
+}
+
+// WithSensorRepo sets the repo the network loads known sensors from
+// when it starts and saves sensors to as they are discovered. Loaded
+// sensors are only added to hubs loaded from the hub repo.
+func WithSensorRepo(repo SensorRepo) Option {
+	return func(n *Network) {
+		n.sensorRepo = repo
+	}
+}
+
+// WithMessageRepo sets the repo the network saves decoded messages to.
+// Messages of types the repo does not store are skipped.
+func WithMessageRepo(repo MessageRepo) Option {
+	return func(n *Network) {
+		n.messageRepo = repo
+	}
+}
+
+// load adds the hubs and sensors saved in the repos that the network
+// does not know yet. Loaded hubs and sensors are offline until they
+// report.
+func (n *Network) load(ctx context.Context) error {
+	if n.hubRepo == nil {
+		return nil
+	}
+
+	hubs, err := n.hubRepo.LoadHubs(ctx)
+	if err != nil {
+		return fmt.Errorf("error loading hubs: %w", err)
+	}
+
+	sensors := make(map[string]*WeatherSensor)
+	if n.sensorRepo != nil {
+		loaded, err := n.sensorRepo.LoadSensors(ctx)
+		if err != nil {
+			return fmt.Errorf("error loading sensors: %w", err)
+		}
+
+		for _, sensor := range loaded {
+			sensors[sensor.SensorSerial] = sensor
+		}
+	}
+
+	for _, hub := range hubs {
+		if _, found := n.hubs.Hub(hub.HubSerialNumber); found {
+			continue
+		}
+
+		hub.Online = false
+		for serial, sensor := range hub.WeatherSensors {
+			if saved, found := sensors[serial]; found {
+				sensor = saved
+				hub.WeatherSensors[serial] = sensor
+			}
+			sensor.Online = false
+		}
+
+		n.hubs.AddHub(hub)
+	}
+
+	return nil
+}
+
+// saveHub saves the hub to the hub repo.
+func (n *Network) saveHub(ctx context.Context, hub *Hub) {
+	if n.hubRepo == nil {
+		return
+	}
+
+	if err := n.hubRepo.SaveHub(ctx, hub); err != nil {
+		n.logger.Warn("error saving hub", slog.String(hubSerial, hub.HubSerialNumber), slog.Any(logKeyError, err))
+	}
+}
+
+// saveSensor saves the sensor to the sensor repo.
+func (n *Network) saveSensor(ctx context.Context, sensor *WeatherSensor) {
+	if n.sensorRepo == nil {
+		return
+	}
+
+	if err := n.sensorRepo.SaveSensor(ctx, sensor); err != nil {
+		n.logger.Warn("error saving sensor", slog.String(sensorSerial, sensor.SensorSerial), slog.Any(logKeyError, err))
+	}
+}
+
+// saveMessage saves the decoded message to the message repo.
+func (n *Network) saveMessage(ctx context.Context, message WeatherMessage) {
+	if n.messageRepo == nil {
+		return
+	}
+
+	n.rollUpHold.save(message, func(message WeatherMessage) {
+		err := n.messageRepo.SaveMessage(ctx, message)
+		if errors.Is(err, ErrUnsupportedMessage) {
+			// Repos may only keep some message types.
+			n.logger.Debug("message not saved", slog.String(messageType, string(message.Type())))
+		} else if err != nil {
+			n.logger.Warn("error saving message", slog.String(messageType, string(message.Type())), slog.Any(logKeyError, err))
+		}
+	})
+}
+
+// saveEvent saves the hub and sensor of a liveness event.
+func (n *Network) saveEvent(ctx context.Context, msg WeatherMessage) {
+	switch m := msg.(type) {
+	case *HubOnline:
+		n.saveHub(ctx, m.Hub)
+	case *HubOffline:
+		n.saveHub(ctx, m.Hub)
+	case *SensorOnline:
+		n.saveHub(ctx, m.Hub)
+		n.saveSensor(ctx, m.Sensor)
+	case *SensorOffline:
+		n.saveHub(ctx, m.Hub)
+		n.saveSensor(ctx, m.Sensor)
+	}
+}
+
+// saveAll saves every hub and sensor, recording their last reports
+// when the network stops.
+func (n *Network) saveAll(ctx context.Context) {
+	for _, hub := range n.hubs.Hubs() {
+		n.saveHub(ctx, hub)
+
+		for _, sensor := range hub.WeatherSensors {
+			n.saveSensor(ctx, sensor)
+		}
+	}
 }
@@ -1 +1,430 @@
 package tempest
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testWind returns a rapid wind event from the sensor at the second.
+func testWind(sensor string, second int64) *RapidWindEvent {
+	return &RapidWindEvent{
+		Sensor:    &WeatherSensor{SensorSerial: sensor},
+		Hub:       &Hub{HubSerialNumber: "HB-00013030"},
+		EventTime: time.Unix(second, 0).UTC(),
+		WindSpeed: float64(second % 10),
+	}
+}
+
+// loadMessages returns every message selected by the query.
+func loadMessages(t *testing.T, repo MessageRepo, query MessageQuery) []WeatherMessage {
+	t.Helper()
+
+	page, err := QueryMessages(context.Background(), repo, query, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return page.Messages
+}
+
+// testMessageRepo checks saving, querying and deleting messages.
+func testMessageRepo(t *testing.T, repo MessageRepo) {
+	ctx := context.Background()
+
+	// Saved out of order.
+	for _, second := range []int64{100, 102, 101, 103} {
+		if err := repo.SaveMessage(ctx, testWind("ST-00000512", second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := repo.SaveMessage(ctx, testWind("ST-00000513", 101)); err != nil {
+		t.Fatal(err)
+	}
+
+	status := &HubStatus{Hub: &Hub{HubSerialNumber: "HB-00013030"}, Timestamp: time.Unix(101, 0).UTC(), FirmwareRevision: "171"}
+	if err := repo.SaveMessage(ctx, status); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := loadMessages(t, repo, MessageQuery{
+		Start:        time.Unix(101, 0),
+		End:          time.Unix(103, 0),
+		Types:        []Type{MessageTypeRapidWind},
+		SensorSerial: "ST-00000512",
+	})
+	if len(messages) != 2 || !messages[0].Time().Equal(time.Unix(101, 0)) || !messages[1].Time().Equal(time.Unix(102, 0)) {
+		t.Fatalf("unexpected messages: %v", messages)
+	}
+
+	if wind := messages[0].(*RapidWindEvent); wind.WindSpeed != 1 || wind.Hub.HubSerialNumber != "HB-00013030" {
+		t.Errorf("unexpected wind: %+v", wind)
+	}
+
+	if hub := loadMessages(t, repo, MessageQuery{HubSerial: "HB-00013030"}); len(hub) != 6 {
+		t.Errorf("expected 6 messages from the hub, got %d", len(hub))
+	}
+
+	if other := loadMessages(t, repo, MessageQuery{HubSerial: "HB-00000001"}); len(other) != 0 {
+		t.Errorf("expected no messages from another hub, got %d", len(other))
+	}
+
+	// The latest message from each sensor and the hub.
+	latest, err := repo.LatestMessages(ctx, MessageQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(latest) != 3 || latest[0].Type() != MessageTypeHubStatus || !latest[1].Time().Equal(time.Unix(103, 0)) || !latest[2].Time().Equal(time.Unix(101, 0)) {
+		t.Errorf("unexpected latest messages: %v", latest)
+	}
+
+	if err := repo.DeleteMessages(ctx, MessageQuery{End: time.Unix(102, 0), Types: []Type{MessageTypeRapidWind}}); err != nil {
+		t.Fatal(err)
+	}
+
+	remaining := loadMessages(t, repo, MessageQuery{})
+	if len(remaining) != 3 || remaining[0].Type() != MessageTypeHubStatus {
+		t.Errorf("unexpected messages after delete: %v", remaining)
+	}
+}
+
+// testQueryPages checks paging through messages with the same time.
+func testQueryPages(t *testing.T, repo MessageRepo) {
+	ctx := context.Background()
+
+	expected := make([]WeatherMessage, 0)
+	for second := int64(100); second < 104; second++ {
+		for _, sensor := range []string{"ST-00000001", "ST-00000002", "ST-00000003"} {
+			wind := testWind(sensor, second)
+			if err := repo.SaveMessage(ctx, wind); err != nil {
+				t.Fatal(err)
+			}
+			expected = append(expected, wind)
+		}
+	}
+
+	paged := make([]WeatherMessage, 0)
+	cursor := ""
+	for pages := 1; ; pages++ {
+		page, err := QueryMessages(ctx, repo, MessageQuery{}, 5, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(page.Messages) > 5 {
+			t.Fatalf("page of %d messages exceeds the limit", len(page.Messages))
+		}
+
+		paged = append(paged, page.Messages...)
+		if page.Next == "" {
+			if pages != 3 {
+				t.Errorf("expected 3 pages, got %d", pages)
+			}
+			break
+		}
+		cursor = page.Next
+	}
+
+	if !reflect.DeepEqual(paged, expected) {
+		t.Errorf("paged messages differ from the saved messages: %v", paged)
+	}
+
+	if _, err := QueryMessages(ctx, repo, MessageQuery{}, 5, "page 2"); err == nil {
+		t.Error("expected an error for an invalid cursor")
+	}
+}
+
+func TestMemoryRepo_Messages(t *testing.T) {
+	testMessageRepo(t, NewMemoryRepo())
+}
+
+func TestMemoryRepo_Pages(t *testing.T) {
+	testQueryPages(t, NewMemoryRepo())
+}
+
+func TestFileRepo_Messages(t *testing.T) {
+	repo, err := OpenFileRepo(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	testMessageRepo(t, repo)
+}
+
+func TestFileRepo_Pages(t *testing.T) {
+	repo, err := OpenFileRepo(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	testQueryPages(t, repo)
+}
+
+func TestFileRepo_StreamChunks(t *testing.T) {
+	// Streaming in chunks smaller than the messages saved keeps them
+	// in time order, including messages saved with the same time.
+	for name, test := range map[string]func(*testing.T, MessageRepo){
+		"messages": testMessageRepo,
+		"pages":    testQueryPages,
+	} {
+		t.Run(name, func(t *testing.T) {
+			repo, err := OpenFileRepo(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer repo.Close()
+
+			repo.chunk = 2
+			test(t, repo)
+		})
+	}
+}
+
+func TestFileRepo_Reopen(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	repo, err := OpenFileRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hub := &Hub{HubSerialNumber: "HB-00013030", FirmwareVersion: "171", WeatherSensors: map[string]*WeatherSensor{
+		"ST-00000512": {SensorSerial: "ST-00000512", ReportInterval: time.Minute},
+	}}
+	if err := repo.SaveHub(ctx, hub); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.SaveSensor(ctx, hub.WeatherSensors["ST-00000512"]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.SaveMessage(ctx, testWind("ST-00000512", 100)); err != nil {
+		t.Fatal(err)
+	}
+
+	repo.Close()
+
+	// Messages load with the saved hub and sensor.
+	savedWind := func(second int64) *RapidWindEvent {
+		event := testWind("ST-00000512", second)
+		event.Hub, event.Sensor = hub, hub.WeatherSensors["ST-00000512"]
+		return event
+	}
+
+	// A save cut short by a crash is removed.
+	messages, err := os.OpenFile(filepath.Join(dir, fileRepoMessages), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages.WriteString(`{"type":"rapid_wind","ti`)
+	messages.Close()
+
+	repo, err = OpenFileRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	hubs, err := repo.LoadHubs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hubs) != 1 || !reflect.DeepEqual(hubs[0], hub) {
+		t.Errorf("unexpected hubs: %+v", hubs)
+	}
+
+	sensors, err := repo.LoadSensors(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sensors) != 1 || sensors[0].ReportInterval != time.Minute {
+		t.Errorf("unexpected sensors: %+v", sensors)
+	}
+
+	loaded := loadMessages(t, repo, MessageQuery{})
+	if len(loaded) != 1 || !reflect.DeepEqual(loaded[0], savedWind(100)) {
+		t.Errorf("unexpected messages: %+v", loaded)
+	}
+
+	// Messages saved after the torn line load.
+	if err := repo.SaveMessage(ctx, testWind("ST-00000512", 101)); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded = loadMessages(t, repo, MessageQuery{})
+	if len(loaded) != 2 || !reflect.DeepEqual(loaded[1], savedWind(101)) {
+		t.Errorf("unexpected messages after save: %+v", loaded)
+	}
+
+	if err := repo.DeleteMessages(ctx, MessageQuery{End: time.Unix(101, 0)}); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded = loadMessages(t, repo, MessageQuery{})
+	if len(loaded) != 1 || !reflect.DeepEqual(loaded[0], savedWind(101)) {
+		t.Errorf("unexpected messages after delete: %+v", loaded)
+	}
+}
+
+func TestNetwork_Repos(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// run runs a network saving to the repo in dir over the simulator.
+	run := func(sim *Simulator) (*Network, *Subscription) {
+		repo, err := OpenFileRepo(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer repo.Close()
+
+		n := NewNetwork("test", WithHubRepo(repo), WithSensorRepo(repo), WithMessageRepo(repo))
+		sub := n.SubscribeBuffered(4096, MessageTypeHubOnline)
+		t.Cleanup(sub.Unsubscribe)
+
+		if err := n.RunSources(context.Background(), sim); err != nil {
+			t.Fatal(err)
+		}
+
+		return n, sub
+	}
+
+	first := NewSimulator(SimulatorConfig{Hubs: 2, Start: start, Duration: 2 * time.Minute, Seed: 1})
+	n, _ := run(first)
+
+	repo, err := OpenFileRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every decoded message is saved and loads as it was decoded.
+	messages := loadMessages(t, repo, MessageQuery{})
+	repo.Close()
+
+	if stats := n.Stats(); uint64(len(messages)) != stats.Decoded {
+		t.Errorf("expected %d saved messages, got %d", stats.Decoded, len(messages))
+	}
+
+	types := map[Type]bool{}
+	for _, message := range messages {
+		types[message.Type()] = true
+	}
+
+	for _, messageType := range []Type{MessageTypeRapidWind, MessageTypeObservation, MessageTypeDeviceStatus, MessageTypeHubStatus} {
+		if !types[messageType] {
+			t.Errorf("expected saved %s messages", messageType)
+		}
+	}
+
+	// A new network knows the saved hubs before they report.
+	second := NewSimulator(SimulatorConfig{Hubs: 2, Start: start.Add(time.Hour), Duration: time.Nanosecond, Seed: 1})
+	restarted, sub := run(second)
+
+	hubs := restarted.HubManager().Hubs()
+	if len(hubs) != 2 || hubs[0].FirmwareVersion != "171" || len(hubs[0].WeatherSensors) != 1 {
+		t.Errorf("expected saved hubs to load, got %+v", hubs)
+	}
+
+	// Loaded hubs are offline until they report.
+	if len(sub.Messages()) != 1 {
+		t.Errorf("expected 1 hub to come online, got %d", len(sub.Messages()))
+	}
+}
+
+func TestFileRepo_Observation(t *testing.T) {
+	ctx := context.Background()
+
+	repo, err := OpenFileRepo(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	observation := &Observation{
+		SensorSerial: "ST-00000512",
+		HubSerial:    "HB-00013030",
+		Observations: []WeatherObservation{{
+			EpochSecondsUTC:  time.Unix(100, 0).UTC(),
+			WindAverage:      NewSpeed(2.3, MetersPerSecond),
+			WindDirection:    NewDirection(128.0, Degrees),
+			StationPressure:  NewPressure(1017.6, Millibar),
+			AirTemperature:   NewTemp(22.4, Celsius),
+			RelativeHumidity: 50.2,
+			Missing:          FieldUV,
+		}},
+	}
+	if err := repo.SaveMessage(ctx, observation); err != nil {
+		t.Fatal(err)
+	}
+
+	// Readings with unexported fields keep their values.
+	loaded := loadMessages(t, repo, MessageQuery{})
+	if len(loaded) != 1 || !reflect.DeepEqual(loaded[0], observation) {
+		t.Errorf("expected %+v, got %+v", observation, loaded)
+	}
+}
+
+func TestFileRepo_Records(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	repo, err := OpenFileRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	sensor := &WeatherSensor{SensorSerial: "ST-00000512", ReportInterval: time.Minute}
+	hub := &Hub{HubSerialNumber: "HB-00013030", FirmwareVersion: "171", WeatherSensors: map[string]*WeatherSensor{
+		sensor.SensorSerial: sensor,
+	}}
+
+	event := testWind(sensor.SensorSerial, 100)
+	event.Hub, event.Sensor = hub, sensor
+	if err := repo.SaveMessage(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the serial numbers of the hub and sensor are saved.
+	data, err := os.ReadFile(filepath.Join(dir, fileRepoMessages))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(data, []byte("firmware_revision")) || bytes.Contains(data, []byte("report_interval")) {
+		t.Errorf("expected hub and sensor records not to be saved with the message: %s", data)
+	}
+
+	// Messages load with the records saved since.
+	updated := hub.clone()
+	updated.FirmwareVersion = "172"
+	if err := repo.SaveHub(ctx, updated); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.SaveSensor(ctx, sensor); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := loadMessages(t, repo, MessageQuery{})
+	if len(loaded) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(loaded))
+	}
+
+	loadedEvent := loaded[0].(*RapidWindEvent)
+	if !reflect.DeepEqual(loadedEvent.Hub, updated) || !reflect.DeepEqual(loadedEvent.Sensor, sensor) {
+		t.Errorf("expected the saved records, got hub %+v and sensor %+v", loadedEvent.Hub, loadedEvent.Sensor)
+	}
+}
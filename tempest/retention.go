@@ -0,0 +1,663 @@
+package tempest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Aggregate message types. These messages are saved by RollUp when it
+// rolls up stored history; they are not sent by hubs.
+const (
+	MessageTypeFiveMinuteAggregate Type = "aggregate_5m"
+	MessageTypeHourlyAggregate     Type = "aggregate_1h"
+	MessageTypeDailyAggregate      Type = "aggregate_1d"
+)
+
+// Retention defaults.
+const (
+	// DefaultRawRetention is how long observations and rapid wind are
+	// kept before they are rolled up into five-minute aggregates.
+	DefaultRawRetention = 7 * 24 * time.Hour
+
+	// DefaultFiveMinuteRetention is how long five-minute aggregates are
+	// kept before they are rolled up into hourly aggregates.
+	DefaultFiveMinuteRetention = 90 * 24 * time.Hour
+
+	// DefaultHourlyRetention is how long hourly aggregates are kept
+	// before they are rolled up into daily aggregates.
+	DefaultHourlyRetention = 2 * 365 * 24 * time.Hour
+
+	// DefaultRetentionInterval is how often the network rolls up
+	// stored history.
+	DefaultRetentionInterval = time.Hour
+)
+
+// Retention configures how long stored history is kept at each
+// resolution. Zero fields use the defaults, except Daily: daily
+// aggregates are kept forever unless Daily is set.
+type Retention struct {
+	Raw        time.Duration // How long to keep observations and rapid wind.
+	FiveMinute time.Duration // How long to keep five-minute aggregates.
+	Hourly     time.Duration // How long to keep hourly aggregates.
+	Daily      time.Duration // How long to keep daily aggregates, zero for forever.
+	Interval   time.Duration // How often the network rolls up history.
+
+	// Repo to save aggregates to. Defaults to the repo rolled up, which
+	// must then store aggregates.
+	Aggregates MessageRepo
+}
+
+// WithRetention rolls up the history saved to the network's message
+// repo when the network starts and at the retention interval while it
+// runs.
+func WithRetention(retention Retention) Option {
+	return func(n *Network) {
+		retention = retention.withDefaults()
+		n.retention = &retention
+	}
+}
+
+// withDefaults returns the retention with defaults for zero fields.
+func (r Retention) withDefaults() Retention {
+	if r.Raw <= 0 {
+		r.Raw = DefaultRawRetention
+	}
+
+	if r.FiveMinute <= 0 {
+		r.FiveMinute = DefaultFiveMinuteRetention
+	}
+
+	if r.Hourly <= 0 {
+		r.Hourly = DefaultHourlyRetention
+	}
+
+	if r.Interval <= 0 {
+		r.Interval = DefaultRetentionInterval
+	}
+
+	return r
+}
+
+// Validate returns an error unless each resolution is kept longer than
+// the finer resolution it is rolled up from, after applying defaults.
+func (r Retention) Validate() error {
+	r = r.withDefaults()
+
+	tiers := []struct {
+		name      string
+		retention time.Duration
+	}{
+		{"raw", r.Raw},
+		{"five-minute", r.FiveMinute},
+		{"hourly", r.Hourly},
+		{"daily", r.Daily},
+	}
+
+	for i := 1; i < len(tiers); i++ {
+		// Daily aggregates are kept forever unless Daily is set.
+		if tiers[i].retention == 0 {
+			continue
+		}
+
+		if previous := tiers[i-1]; tiers[i].retention <= previous.retention {
+			return fmt.Errorf("%s retention %s must be longer than %s retention %s", tiers[i].name, tiers[i].retention, previous.name, previous.retention)
+		}
+	}
+
+	return nil
+}
+
+// rollUpBatch is about how many messages are rolled up before what was
+// rolled up is saved, so a roll up stopped early keeps its progress.
+const rollUpBatch = 4096
+
+// aggregateTiers are the resolutions history is rolled up into, from
+// the finest.
+var aggregateTiers = []struct {
+	msgType    Type
+	resolution time.Duration
+}{
+	{MessageTypeFiveMinuteAggregate, 5 * time.Minute},
+	{MessageTypeHourlyAggregate, time.Hour},
+	{MessageTypeDailyAggregate, 24 * time.Hour},
+}
+
+// Aggregate summarises the observations and rapid wind reported by a
+// sensor over a period. Periods start at multiples of the resolution
+// since the zero time, so days are UTC days.
+type Aggregate struct {
+	HubSerial    string
+	SensorSerial string
+	Start        time.Time      // Start of the period.
+	Resolution   time.Duration  // Length of the period.
+	Observations int            // Number of observations summarised.
+	Temperature  AggregateStats // Air temperature in degrees Celsius.
+	Humidity     AggregateStats // Relative humidity percentage.
+	Pressure     AggregateStats // Station pressure in millibars.
+	Wind         AggregateStats // Observations' average wind speed in meters per second.
+	WindGust     float64        // Highest gust or rapid wind speed in meters per second.
+	Rain         float64        // Rain accumulation in millimeters.
+	Strikes      int            // Lightning strike count.
+}
+
+// AggregateStats summarises the readings of a field over a period.
+// Missing readings are not counted.
+type AggregateStats struct {
+	Min   float64
+	Max   float64
+	Mean  float64
+	Count int // Number of readings.
+}
+
+// Type returns the aggregate message type of the resolution.
+func (a *Aggregate) Type() Type {
+	switch {
+	case a.Resolution >= 24*time.Hour:
+		return MessageTypeDailyAggregate
+	case a.Resolution >= time.Hour:
+		return MessageTypeHourlyAggregate
+	default:
+		return MessageTypeFiveMinuteAggregate
+	}
+}
+
+// Time returns the start of the period.
+func (a *Aggregate) Time() time.Time {
+	return a.Start
+}
+
+// addObservation adds an observation to the aggregate.
+func (a *Aggregate) addObservation(ob WeatherObservation) {
+	a.Observations++
+
+	if ob.Has(FieldAirTemperature) {
+		a.Temperature.add(ob.AirTemperature.C())
+	}
+
+	if ob.Has(FieldRelativeHumidity) {
+		a.Humidity.add(ob.RelativeHumidity)
+	}
+
+	if ob.Has(FieldStationPressure) {
+		a.Pressure.add(ob.StationPressure.Millibar())
+	}
+
+	if ob.Has(FieldWindAverage) {
+		a.Wind.add(ob.WindAverage.MetersPerSecond())
+	}
+
+	if ob.Has(FieldWindGust) {
+		a.WindGust = max(a.WindGust, ob.WindGust.MetersPerSecond())
+	}
+
+	if ob.Has(FieldRainAccumulation) {
+		a.Rain += ob.RainAccumulation
+	}
+
+	if ob.Has(FieldLightningStrikeCnt) {
+		a.Strikes += ob.LightningStrikeCnt
+	}
+}
+
+// merge adds an aggregate of the same or a shorter period to the
+// aggregate.
+func (a *Aggregate) merge(other *Aggregate) {
+	a.Observations += other.Observations
+	a.Temperature.merge(other.Temperature)
+	a.Humidity.merge(other.Humidity)
+	a.Pressure.merge(other.Pressure)
+	a.Wind.merge(other.Wind)
+	a.WindGust = max(a.WindGust, other.WindGust)
+	a.Rain += other.Rain
+	a.Strikes += other.Strikes
+}
+
+// add adds a reading to the stats.
+func (s *AggregateStats) add(value float64) {
+	s.merge(AggregateStats{Min: value, Max: value, Mean: value, Count: 1})
+}
+
+// merge adds the readings summarised by other to the stats.
+func (s *AggregateStats) merge(other AggregateStats) {
+	if other.Count == 0 {
+		return
+	}
+
+	if s.Count == 0 {
+		*s = other
+		return
+	}
+
+	count := s.Count + other.Count
+	s.Min = min(s.Min, other.Min)
+	s.Max = max(s.Max, other.Max)
+	s.Mean = (s.Mean*float64(s.Count) + other.Mean*float64(other.Count)) / float64(count)
+	s.Count = count
+}
+
+// RollUp rolls up the history saved to the repo that is older than the
+// retention allows at each resolution. Observations and rapid wind are
+// rolled up into five-minute aggregates, five-minute aggregates into
+// hourly aggregates and hourly aggregates into daily aggregates, and
+// what was rolled up is deleted. Daily aggregates older than the daily
+// retention are deleted. Repos that can be compacted, like
+// ObservationStore, are then compacted to free the space of what was
+// deleted.
+//
+// Only whole periods are rolled up. Messages of periods rolled up
+// before, like late or backfilled messages, are merged into the saved
+// aggregates. If what was rolled up cannot be deleted, the aggregates
+// are restored so rolling up again does not count it twice; a roll up
+// stopped by a crash between the two may count it twice.
+//
+// History is rolled up a few thousand messages at a time, saving each
+// batch before the next is read, so a roll up stopped by cancelling the
+// context keeps what it has rolled up and rolling up again resumes it.
+// Messages older than the raw retention must not be saved while rolling
+// up, or they may be deleted without being rolled up. A network rolling
+// up its own history holds such messages back until they can be saved.
+func RollUp(ctx context.Context, repo MessageRepo, retention Retention, now time.Time) error {
+	return rollUp(ctx, repo, retention, now, nil)
+}
+
+// rollUp rolls up the history saved to the repo, holding back messages
+// the roll up of observations and rapid wind may delete.
+func rollUp(ctx context.Context, repo MessageRepo, retention Retention, now time.Time, hold *rollUpHold) error {
+	if err := retention.Validate(); err != nil {
+		return err
+	}
+
+	retention = retention.withDefaults()
+	aggregates := retention.Aggregates
+	if aggregates == nil {
+		aggregates = repo
+	}
+
+	from := repo
+	sources := []Type{MessageTypeObservation, MessageTypeRapidWind}
+	keep := []time.Duration{retention.Raw, retention.FiveMinute, retention.Hourly}
+	for i, tier := range aggregateTiers {
+		cutoff := now.Add(-keep[i]).Truncate(tier.resolution)
+		if i == 0 {
+			hold.hold(MessageQuery{End: cutoff, Types: sources})
+		}
+
+		err := rollUpTier(ctx, from, sources, aggregates, tier.resolution, cutoff)
+		if i == 0 {
+			hold.release()
+		}
+		if err != nil {
+			return fmt.Errorf("error rolling up %s: %w", tier.msgType, err)
+		}
+
+		from, sources = aggregates, []Type{tier.msgType}
+	}
+
+	if retention.Daily > 0 {
+		query := MessageQuery{End: now.Add(-retention.Daily), Types: []Type{MessageTypeDailyAggregate}}
+		if err := aggregates.DeleteMessages(context.WithoutCancel(ctx), query); err != nil {
+			return fmt.Errorf("error deleting %s: %w", MessageTypeDailyAggregate, err)
+		}
+	}
+
+	repos := []MessageRepo{repo}
+	if retention.Aggregates != nil {
+		repos = append(repos, retention.Aggregates)
+	}
+
+	for _, r := range repos {
+		if c, ok := r.(compacter); ok {
+			if err := c.Compact(ctx); err != nil {
+				return fmt.Errorf("error compacting history: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// compacter is a MessageRepo that keeps deleted messages until it is
+// compacted.
+type compacter interface {
+	Compact(ctx context.Context) error
+}
+
+// aggregateKey identifies the aggregate of a sensor's period.
+type aggregateKey struct {
+	hub    string
+	sensor string
+	start  int64 // Unix nanoseconds.
+}
+
+// key returns the key of the aggregate's period.
+func (a *Aggregate) key() aggregateKey {
+	return aggregateKey{hub: a.HubSerial, sensor: a.SensorSerial, start: a.Start.UnixNano()}
+}
+
+// rollUpTier merges aggregates of the resolution summarising the
+// messages of the source types before the cutoff into the saved
+// aggregates, then deletes the messages, a batch at a time.
+func rollUpTier(ctx context.Context, from MessageRepo, sources []Type, to MessageRepo, resolution time.Duration, cutoff time.Time) error {
+	query := MessageQuery{End: cutoff, Types: sources}
+	for {
+		next, err := rollUpTierBatch(ctx, from, query, to, resolution)
+		if err != nil || next.IsZero() {
+			return err
+		}
+
+		query.Start = next
+	}
+}
+
+// rollUpTierBatch rolls up a batch of the messages selected by the
+// query, ending at the end of a period. It returns the end of the batch,
+// or the zero time if every message selected was rolled up.
+//
+// Only reading is stopped by cancelling the context: once the batch is
+// read, it is saved and deleted even if the context is cancelled, so
+// what is saved stays consistent.
+func rollUpTierBatch(ctx context.Context, from MessageRepo, query MessageQuery, to MessageRepo, resolution time.Duration) (time.Time, error) {
+	built := make(map[aggregateKey]*Aggregate)
+
+	// aggregate returns the aggregate of the sensor's period at t.
+	aggregate := func(hub string, sensor string, t time.Time) *Aggregate {
+		start := t.Truncate(resolution)
+		key := aggregateKey{hub: hub, sensor: sensor, start: start.UnixNano()}
+		if a, found := built[key]; found {
+			return a
+		}
+
+		a := &Aggregate{HubSerial: hub, SensorSerial: sensor, Start: start.UTC(), Resolution: resolution}
+		built[key] = a
+
+		return a
+	}
+
+	// Messages saved while rolling up are not rolled up, so only the
+	// messages up to the last one streamed are deleted.
+	var last time.Time
+	streamed := 0
+
+	// Once the batch is full, the rest of the period is streamed.
+	var end time.Time
+	err := from.StreamMessages(ctx, query, func(message WeatherMessage) bool {
+		if !end.IsZero() && !message.Time().Before(end) {
+			return false
+		}
+
+		if streamed == 0 || message.Time().After(last) {
+			last = message.Time()
+		}
+
+		streamed++
+		if streamed == rollUpBatch {
+			end = message.Time().Truncate(resolution).Add(resolution)
+		}
+
+		// Messages that cannot be attributed to a sensor are dropped.
+		hub, sensor := messageSerials(message)
+		if sensor == "" {
+			return true
+		}
+
+		switch m := message.(type) {
+		case *Observation:
+			for _, ob := range m.Observations {
+				aggregate(hub, sensor, ob.EpochSecondsUTC).addObservation(ob)
+			}
+		case *RapidWindEvent:
+			a := aggregate(hub, sensor, m.EventTime)
+			a.WindGust = max(a.WindGust, m.WindSpeed)
+		case *Aggregate:
+			aggregate(hub, sensor, m.Start).merge(m)
+		}
+
+		return true
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if streamed == 0 {
+		return time.Time{}, nil
+	}
+	query.End = last.Add(time.Nanosecond)
+
+	// The batch is read, so finish saving it.
+	ctx = context.WithoutCancel(ctx)
+
+	aggregates := make([]*Aggregate, 0, len(built))
+	for _, a := range built {
+		aggregates = append(aggregates, a)
+	}
+	sortAggregates(aggregates)
+
+	runs := aggregateRuns(aggregates)
+	saved, err := loadAggregates(ctx, to, runs)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for _, a := range aggregates {
+		if s, found := saved[a.key()]; found {
+			a.merge(s)
+		}
+	}
+
+	if err := replaceAggregates(ctx, to, runs, aggregates); err != nil {
+		return time.Time{}, err
+	}
+
+	if err := from.DeleteMessages(ctx, query); err != nil {
+		restored := make([]*Aggregate, 0, len(saved))
+		for _, a := range saved {
+			restored = append(restored, a)
+		}
+		sortAggregates(restored)
+
+		if restoreErr := replaceAggregates(ctx, to, runs, restored); restoreErr != nil {
+			return time.Time{}, errors.Join(err, fmt.Errorf("error restoring aggregates: %w", restoreErr))
+		}
+
+		return time.Time{}, err
+	}
+
+	if streamed < rollUpBatch {
+		return time.Time{}, nil
+	}
+
+	return end, nil
+}
+
+// sortAggregates sorts the aggregates by start, then by sensor.
+func sortAggregates(aggregates []*Aggregate) {
+	sort.Slice(aggregates, func(i, j int) bool {
+		if !aggregates[i].Start.Equal(aggregates[j].Start) {
+			return aggregates[i].Start.Before(aggregates[j].Start)
+		}
+
+		return latestKey(aggregates[i].HubSerial, aggregates[i].SensorSerial) < latestKey(aggregates[j].HubSerial, aggregates[j].SensorSerial)
+	})
+}
+
+// aggregateRuns returns queries selecting the saved aggregates of the
+// periods of the aggregates, one for each run of consecutive periods of
+// a sensor.
+func aggregateRuns(aggregates []*Aggregate) []MessageQuery {
+	runs := make(map[aggregateKey]MessageQuery)
+	order := make([]aggregateKey, 0)
+	ends := make(map[aggregateKey]aggregateKey)
+	for _, a := range aggregates {
+		key := a.key()
+		end := a.Start.Add(a.Resolution)
+
+		// Extend the run ending where this period starts.
+		if first, found := ends[key]; found {
+			run := runs[first]
+			run.End = end
+			runs[first] = run
+			delete(ends, key)
+			ends[aggregateKey{hub: a.HubSerial, sensor: a.SensorSerial, start: end.UnixNano()}] = first
+			continue
+		}
+
+		runs[key] = MessageQuery{Start: a.Start, End: end, Types: []Type{a.Type()}, HubSerial: a.HubSerial, SensorSerial: a.SensorSerial}
+		order = append(order, key)
+		ends[aggregateKey{hub: a.HubSerial, sensor: a.SensorSerial, start: end.UnixNano()}] = key
+	}
+
+	queries := make([]MessageQuery, 0, len(order))
+	for _, key := range order {
+		queries = append(queries, runs[key])
+	}
+
+	return queries
+}
+
+// loadAggregates returns copies of the saved aggregates selected by the
+// runs by period.
+func loadAggregates(ctx context.Context, repo MessageRepo, runs []MessageQuery) (map[aggregateKey]*Aggregate, error) {
+	saved := make(map[aggregateKey]*Aggregate)
+	for _, run := range runs {
+		err := repo.StreamMessages(ctx, run, func(message WeatherMessage) bool {
+			m, ok := message.(*Aggregate)
+			if !ok {
+				return true
+			}
+
+			if a, found := saved[m.key()]; found {
+				a.merge(m)
+				return true
+			}
+
+			a := *m
+			saved[a.key()] = &a
+
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return saved, nil
+}
+
+// replaceAggregates replaces the saved aggregates selected by the runs
+// with the aggregates.
+func replaceAggregates(ctx context.Context, repo MessageRepo, runs []MessageQuery, aggregates []*Aggregate) error {
+	for _, run := range runs {
+		if err := repo.DeleteMessages(ctx, run); err != nil {
+			return err
+		}
+	}
+
+	for _, a := range aggregates {
+		if err := repo.SaveMessage(ctx, a); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rollUpHold holds back saving messages selected by a roll up in
+// progress, which could otherwise be deleted without being rolled up.
+type rollUpHold struct {
+	mu    sync.Mutex
+	query *MessageQuery    // Messages held back, nil to hold none.
+	held  []WeatherMessage // Messages held back until saved.
+}
+
+// hold holds back messages selected by the query until released.
+func (h *rollUpHold) hold(query MessageQuery) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.query = &query
+}
+
+// release stops holding back messages. The messages held are kept
+// until taken.
+func (h *rollUpHold) release() {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.query = nil
+}
+
+// save calls save with the message unless it is held back. The hold is
+// locked while saving, so a roll up cannot start reading before
+// the message is saved.
+func (h *rollUpHold) save(message WeatherMessage, save func(message WeatherMessage)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.query != nil && h.query.matches(message) {
+		h.held = append(h.held, message)
+		return
+	}
+
+	save(message)
+}
+
+// take returns and forgets the messages held back.
+func (h *rollUpHold) take() []WeatherMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	held := h.held
+	h.held = nil
+
+	return held
+}
+
+// watchRetention rolls up the message repo's history when the network
+// starts and at the retention interval until the context is cancelled.
+// Cancelling the context stops a roll up in progress, which resumes
+// when the network next rolls up history.
+func (n *Network) watchRetention(ctx context.Context, saveCtx context.Context) {
+	if err := n.retention.Validate(); err != nil {
+		n.logger.Warn("not rolling up history", slog.Any(logKeyError, err))
+		return
+	}
+
+	ticker := time.NewTicker(n.retention.Interval)
+	defer ticker.Stop()
+
+	for {
+		err := rollUp(ctx, n.messageRepo, *n.retention, time.Now(), &n.rollUpHold)
+
+		// Save the messages held back while rolling up.
+		for _, message := range n.rollUpHold.take() {
+			n.saveMessage(saveCtx, message)
+		}
+
+		if errors.Is(err, ErrUnsupportedMessage) {
+			n.logger.Warn("message repo cannot save aggregates, set the retention's aggregates repo", slog.Any(logKeyError, err))
+			return
+		}
+		if err != nil && ctx.Err() == nil {
+			n.logger.Warn("error rolling up history", slog.Any(logKeyError, err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
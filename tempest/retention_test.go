@@ -0,0 +1,486 @@
+package tempest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// retentionStart is the start of the history saved by saveHistory.
+var retentionStart = time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// saveHistory saves three days of observations every minute and rapid
+// wind every half minute from a sensor, if the repo saves rapid wind. It rains a tenth of a
+// millimeter and lightning strikes once every minute, and the
+// temperature rises a degree an hour from zero each day.
+func saveHistory(t *testing.T, repo MessageRepo) {
+	t.Helper()
+	ctx := context.Background()
+
+	for at := retentionStart; at.Before(retentionStart.Add(72 * time.Hour)); at = at.Add(30 * time.Second) {
+		wind := testWind("ST-00000512", at.Unix())
+		wind.WindSpeed = 4
+		if at.Hour() == 12 && at.Minute() == 0 {
+			wind.WindSpeed = 12
+		}
+
+		if err := repo.SaveMessage(ctx, wind); err != nil && !errors.Is(err, ErrUnsupportedMessage) {
+			t.Fatal(err)
+		}
+
+		if at.Second() != 0 {
+			continue
+		}
+
+		observation := testObservation("ST-00000512", at.Unix())
+		ob := &observation.Observations[0]
+		ob.AirTemperature = NewTemp(float64(at.Hour()), Celsius)
+		ob.RainAccumulation = 0.1
+		ob.LightningStrikeCnt = 1
+		ob.WindGust = NewSpeed(6, MetersPerSecond)
+		if at.Minute() == 30 {
+			ob.Missing = FieldAirTemperature
+		}
+
+		if err := repo.SaveMessage(ctx, observation); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// countTypes returns the number of saved messages of each type.
+func countTypes(t *testing.T, repo MessageRepo) map[Type]int {
+	t.Helper()
+
+	counts := make(map[Type]int)
+	for _, message := range loadMessages(t, repo, MessageQuery{}) {
+		counts[message.Type()]++
+	}
+
+	return counts
+}
+
+// testRollUp checks rolling up history saved to the repo.
+func testRollUp(t *testing.T, repo MessageRepo) {
+	ctx := context.Background()
+	saveHistory(t, repo)
+
+	now := retentionStart.Add(72 * time.Hour)
+	retention := Retention{Raw: 24 * time.Hour, FiveMinute: 36 * time.Hour, Hourly: 48 * time.Hour}
+
+	// Each resolution is kept for its retention, so the first day is
+	// daily, the first half of the second day hourly, the second half
+	// five-minute and the third day raw.
+	expected := map[Type]int{
+		MessageTypeDailyAggregate:      1,
+		MessageTypeHourlyAggregate:     12,
+		MessageTypeFiveMinuteAggregate: 12 * 12,
+		MessageTypeObservation:         24 * 60,
+		MessageTypeRapidWind:           24 * 60 * 2,
+	}
+
+	// Rolling up again changes nothing.
+	for run := 0; run < 2; run++ {
+		if err := RollUp(ctx, repo, retention, now); err != nil {
+			t.Fatal(err)
+		}
+
+		counts := countTypes(t, repo)
+		for messageType, count := range expected {
+			if counts[messageType] != count {
+				t.Errorf("run %d: expected %d %s messages, got %d", run, count, messageType, counts[messageType])
+			}
+		}
+	}
+
+	daily := loadMessages(t, repo, MessageQuery{Types: []Type{MessageTypeDailyAggregate}})[0].(*Aggregate)
+	if daily.SensorSerial != "ST-00000512" || !daily.Start.Equal(retentionStart) || daily.Resolution != 24*time.Hour {
+		t.Errorf("unexpected daily aggregate: %+v", daily)
+	}
+
+	if daily.Observations != 1440 || daily.Strikes != 1440 || daily.Rain < 143.99 || daily.Rain > 144.01 || daily.WindGust != 12 {
+		t.Errorf("unexpected daily totals: %+v", daily)
+	}
+
+	// Each hour's temperature is missing for one observation.
+	if temp := daily.Temperature; temp.Min != 0 || temp.Max != 23 || temp.Count != 1440-24 || temp.Mean < 11.49 || temp.Mean > 11.51 {
+		t.Errorf("unexpected daily temperature: %+v", temp)
+	}
+
+	hourly := loadMessages(t, repo, MessageQuery{Types: []Type{MessageTypeHourlyAggregate}})[0].(*Aggregate)
+	if !hourly.Start.Equal(retentionStart.Add(24*time.Hour)) || hourly.Observations != 60 || hourly.Temperature.Max != 0 {
+		t.Errorf("unexpected hourly aggregate: %+v", hourly)
+	}
+
+	// Daily aggregates are deleted after their retention.
+	retention.Daily = 60 * time.Hour
+	if err := RollUp(ctx, repo, retention, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if counts := countTypes(t, repo); counts[MessageTypeDailyAggregate] != 0 {
+		t.Errorf("expected daily aggregates to be deleted, got %d", counts[MessageTypeDailyAggregate])
+	}
+}
+
+func TestRollUp_MemoryRepo(t *testing.T) {
+	testRollUp(t, NewMemoryRepo())
+}
+
+func TestRollUp_FileRepo(t *testing.T) {
+	repo, err := OpenFileRepo(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	testRollUp(t, repo)
+}
+
+// failingDeleteRepo is a MessageRepo failing the first delete of
+// observations and rapid wind.
+type failingDeleteRepo struct {
+	*MemoryRepo
+	failed bool
+}
+
+func (r *failingDeleteRepo) DeleteMessages(ctx context.Context, query MessageQuery) error {
+	if !r.failed && len(query.Types) == 2 {
+		r.failed = true
+		return errors.New("disk full")
+	}
+
+	return r.MemoryRepo.DeleteMessages(ctx, query)
+}
+
+func TestRollUp_Interrupted(t *testing.T) {
+	ctx := context.Background()
+	repo := &failingDeleteRepo{MemoryRepo: NewMemoryRepo()}
+	saveHistory(t, repo)
+
+	now := retentionStart.Add(72 * time.Hour)
+	retention := Retention{Raw: 24 * time.Hour}
+
+	// The aggregates are saved but the raw history is kept.
+	if err := RollUp(ctx, repo, retention, now); err == nil {
+		t.Fatal("expected the roll up to fail")
+	}
+
+	if err := RollUp(ctx, repo, retention, now); err != nil {
+		t.Fatal(err)
+	}
+
+	aggregates := loadMessages(t, repo, MessageQuery{Types: []Type{MessageTypeFiveMinuteAggregate}})
+	if len(aggregates) != 2*24*12 {
+		t.Fatalf("expected %d five-minute aggregates, got %d", 2*24*12, len(aggregates))
+	}
+
+	for _, message := range aggregates {
+		if a := message.(*Aggregate); a.Observations != 5 {
+			t.Fatalf("expected 5 observations in each aggregate, got %+v", a)
+		}
+	}
+}
+
+// savingRepo is a MessageRepo saving a message after streaming its
+// messages, through the network if it has one.
+type savingRepo struct {
+	*MemoryRepo
+	message WeatherMessage
+	network *Network
+}
+
+func (r *savingRepo) StreamMessages(ctx context.Context, query MessageQuery, fn func(message WeatherMessage) bool) error {
+	if err := r.MemoryRepo.StreamMessages(ctx, query, fn); err != nil {
+		return err
+	}
+
+	if r.message == nil {
+		return nil
+	}
+
+	message := r.message
+	r.message = nil
+	if r.network != nil {
+		r.network.saveMessage(ctx, message)
+		return nil
+	}
+
+	return r.SaveMessage(ctx, message)
+}
+
+func TestRollUp_SavedWhileRolling(t *testing.T) {
+	ctx := context.Background()
+	repo := &savingRepo{MemoryRepo: NewMemoryRepo()}
+	saveHistory(t, repo)
+
+	// An observation saved after the first batch is streamed is newer
+	// than what the batch rolled up, though older than the cutoff, and
+	// is rolled up with a later batch.
+	now := retentionStart.Add(72 * time.Hour)
+	saved := testObservation("ST-00000512", retentionStart.Add(48*time.Hour-10*time.Second).Unix())
+	repo.message = saved
+
+	if err := RollUp(ctx, repo, Retention{Raw: 24 * time.Hour}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	period := MessageQuery{Start: retentionStart.Add(48*time.Hour - 5*time.Minute), End: retentionStart.Add(48 * time.Hour), Types: []Type{MessageTypeFiveMinuteAggregate}}
+	aggregates := loadMessages(t, repo, period)
+	if len(aggregates) != 1 || aggregates[0].(*Aggregate).Observations != 6 {
+		t.Errorf("expected the saved observation to be rolled up, got %+v", aggregates)
+	}
+}
+
+func TestNetwork_RollUpHold(t *testing.T) {
+	ctx := context.Background()
+	repo := &savingRepo{MemoryRepo: NewMemoryRepo()}
+	saveHistory(t, repo)
+
+	// An observation the network saves while rolling up, older than
+	// what the first batch rolled up, is held back until the roll up is
+	// done rather than deleted with the batch.
+	n := NewNetwork("test", WithMessageRepo(repo))
+	late := testObservation("ST-00000512", retentionStart.Add(10*time.Second).Unix())
+	repo.message, repo.network = late, n
+
+	now := retentionStart.Add(72 * time.Hour)
+	if err := rollUp(ctx, repo, Retention{Raw: 24 * time.Hour}, now, &n.rollUpHold); err != nil {
+		t.Fatal(err)
+	}
+
+	held := n.rollUpHold.take()
+	if len(held) != 1 || held[0] != WeatherMessage(late) {
+		t.Fatalf("expected the late observation to be held, got %v", held)
+	}
+
+	for _, message := range held {
+		n.saveMessage(ctx, message)
+	}
+
+	kept := loadMessages(t, repo, MessageQuery{End: retentionStart.Add(48 * time.Hour), Types: []Type{MessageTypeObservation}})
+	if len(kept) != 1 || kept[0] != WeatherMessage(late) {
+		t.Errorf("expected the late observation to be saved, got %+v", kept)
+	}
+}
+
+// cancellingRepo is a MessageRepo cancelling a context after the first
+// delete of observations and rapid wind.
+type cancellingRepo struct {
+	*MemoryRepo
+	cancel context.CancelFunc
+}
+
+func (r *cancellingRepo) DeleteMessages(ctx context.Context, query MessageQuery) error {
+	if err := r.MemoryRepo.DeleteMessages(ctx, query); err != nil {
+		return err
+	}
+
+	if len(query.Types) == 2 {
+		r.cancel()
+	}
+
+	return nil
+}
+
+func TestRollUp_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	repo := &cancellingRepo{MemoryRepo: NewMemoryRepo(), cancel: cancel}
+	saveHistory(t, repo)
+
+	// The first batch is rolled up before the roll up stops.
+	now := retentionStart.Add(72 * time.Hour)
+	retention := Retention{Raw: 24 * time.Hour}
+	if err := RollUp(ctx, repo, retention, now); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the roll up to be cancelled, got %v", err)
+	}
+
+	counts := countTypes(t, repo)
+	if counts[MessageTypeFiveMinuteAggregate] == 0 || counts[MessageTypeObservation] == 3*24*60 || counts[MessageTypeObservation] <= 24*60 {
+		t.Fatalf("expected part of the history to be rolled up, got %v", counts)
+	}
+
+	// Rolling up again resumes without counting anything twice.
+	if err := RollUp(context.Background(), repo, retention, now); err != nil {
+		t.Fatal(err)
+	}
+
+	aggregates := loadMessages(t, repo, MessageQuery{Types: []Type{MessageTypeFiveMinuteAggregate}})
+	if len(aggregates) != 2*24*12 {
+		t.Fatalf("expected %d five-minute aggregates, got %d", 2*24*12, len(aggregates))
+	}
+
+	for _, message := range aggregates {
+		if a := message.(*Aggregate); a.Observations != 5 {
+			t.Fatalf("expected 5 observations in each aggregate, got %+v", a)
+		}
+	}
+}
+
+func TestRollUp_Late(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo()
+	saveHistory(t, repo)
+
+	now := retentionStart.Add(72 * time.Hour)
+	retention := Retention{Raw: 24 * time.Hour}
+	if err := RollUp(ctx, repo, retention, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// An observation saved after its period was rolled up is merged into
+	// the period's aggregate.
+	late := testObservation("ST-00000512", retentionStart.Add(10*time.Second).Unix())
+	late.Observations[0].AirTemperature = NewTemp(-5, Celsius)
+	if err := repo.SaveMessage(ctx, late); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RollUp(ctx, repo, retention, now); err != nil {
+		t.Fatal(err)
+	}
+
+	aggregates := loadMessages(t, repo, MessageQuery{End: retentionStart.Add(5 * time.Minute), Types: []Type{MessageTypeFiveMinuteAggregate}})
+	if len(aggregates) != 1 {
+		t.Fatalf("expected 1 aggregate of the period, got %d", len(aggregates))
+	}
+
+	if a := aggregates[0].(*Aggregate); a.Observations != 6 || a.Temperature.Min != -5 || a.Temperature.Count != 6 {
+		t.Errorf("expected the late observation to be merged, got %+v", a)
+	}
+
+	if counts := countTypes(t, repo); counts[MessageTypeFiveMinuteAggregate] != 2*24*12 {
+		t.Errorf("expected %d five-minute aggregates, got %v", 2*24*12, counts)
+	}
+}
+
+func TestRetention_Validate(t *testing.T) {
+	tests := []struct {
+		retention Retention
+		valid     bool
+	}{
+		{Retention{}, true},
+		{Retention{Raw: time.Hour, FiveMinute: 2 * time.Hour, Hourly: 3 * time.Hour, Daily: 4 * time.Hour}, true},
+		{Retention{Raw: 24 * time.Hour, FiveMinute: 12 * time.Hour}, false},
+		{Retention{Raw: 100 * 24 * time.Hour}, false},
+		{Retention{Hourly: time.Hour}, false},
+		{Retention{Daily: DefaultHourlyRetention}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.retention.Validate(); (err == nil) != test.valid {
+			t.Errorf("%+v: expected valid %t, got %v", test.retention, test.valid, err)
+		}
+	}
+
+	// Invalid retention is not rolled up.
+	repo := NewMemoryRepo()
+	saveHistory(t, repo)
+
+	retention := Retention{Raw: 24 * time.Hour, FiveMinute: 12 * time.Hour}
+	if err := RollUp(context.Background(), repo, retention, retentionStart.Add(72*time.Hour)); err == nil {
+		t.Error("expected an invalid retention error")
+	}
+
+	if counts := countTypes(t, repo); counts[MessageTypeFiveMinuteAggregate] != 0 {
+		t.Errorf("expected no aggregates, got %v", counts)
+	}
+}
+
+func TestRollUp_Store(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := openStore(t, dir)
+	saveHistory(t, store)
+	size := dirSize(t, dir)
+
+	now := retentionStart.Add(72 * time.Hour)
+
+	// The store only saves observations, so aggregates need a repo.
+	if err := RollUp(ctx, store, Retention{Raw: 24 * time.Hour}, now); !errors.Is(err, ErrUnsupportedMessage) {
+		t.Errorf("expected ErrUnsupportedMessage, got %v", err)
+	}
+
+	aggregates := NewMemoryRepo()
+	if err := RollUp(ctx, store, Retention{Raw: 24 * time.Hour, Aggregates: aggregates}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if raw := loadMessages(t, store, MessageQuery{}); len(raw) != 24*60 {
+		t.Errorf("expected a day of observations, got %d", len(raw))
+	}
+
+	if counts := countTypes(t, aggregates); counts[MessageTypeFiveMinuteAggregate] != 2*24*12 {
+		t.Errorf("expected %d five-minute aggregates, got %v", 2*24*12, counts)
+	}
+
+	// The store is compacted, removing what was rolled up from disk.
+	if rolled := dirSize(t, dir); rolled >= size/2 {
+		t.Errorf("expected the store to shrink from %d bytes, got %d bytes", size, rolled)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dir, storeTombstones)); err != nil || len(data) != 0 {
+		t.Errorf("expected no tombstones, got %d bytes (%v)", len(data), err)
+	}
+}
+
+// dirSize returns the total size of the files in the directory.
+func dirSize(t *testing.T, dir string) int64 {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var size int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += info.Size()
+	}
+
+	return size
+}
+
+func TestNetwork_Retention(t *testing.T) {
+	repo := NewMemoryRepo()
+	saveHistory(t, repo)
+
+	// The network rolls up history when it starts. The history is older
+	// than every retention, so only daily aggregates are kept. The roll
+	// up stops with the network, so keep it running until done.
+	sim := NewSimulator(SimulatorConfig{Hubs: 1, Start: time.Now(), Duration: time.Minute, Seed: 1})
+	n := NewNetwork("test", WithMessageRepo(repo), WithRetention(Retention{}))
+	if err := n.StartSources(context.Background(), sim, NewChannelSource(make(chan Datagram))); err != nil {
+		t.Fatal(err)
+	}
+
+	var counts map[Type]int
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		counts = countTypes(t, repo)
+		if counts[MessageTypeDailyAggregate] == 3 && counts[MessageTypeObservation] > 0 && counts[MessageTypeRapidWind] > 0 {
+			break
+		}
+	}
+
+	if err := n.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if counts[MessageTypeDailyAggregate] != 3 || counts[MessageTypeHourlyAggregate] != 0 || counts[MessageTypeFiveMinuteAggregate] != 0 {
+		t.Errorf("expected daily aggregates of the history, got %v", counts)
+	}
+
+	// Recent messages are kept.
+	if counts[MessageTypeObservation] == 0 || counts[MessageTypeRapidWind] == 0 {
+		t.Errorf("expected recent messages to be kept, got %v", counts)
+	}
+}
@@ -4,7 +4,9 @@ import "time"
 
 // WeatherSensor represents a Tempest weather sensor.
 type WeatherSensor struct {
-	SensorSerial string     `json:"serial_number"`
-	LastMessage  time.Time  `json:"last_message"`
-	Coordinate   Coordinate `json:"coordinate"`
+	SensorSerial   string        `json:"serial_number"`
+	LastMessage    time.Time     `json:"last_message"`
+	Coordinate     Coordinate    `json:"coordinate"`
+	ReportInterval time.Duration `json:"report_interval"` // Observation interval reported by the sensor.
+	Online         bool          `json:"online"`          // True while the sensor is reporting.
 }
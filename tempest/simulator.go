@@ -0,0 +1,504 @@
+package tempest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Intervals Tempest hubs and sensors report messages at.
+const (
+	simulatedRapidWindInterval    = 3 * time.Second
+	simulatedObservationInterval  = time.Minute
+	simulatedDeviceStatusInterval = time.Minute
+	simulatedHubStatusInterval    = 10 * time.Second
+)
+
+// A ScenarioPhase is a period of weather in a scenario.
+type ScenarioPhase struct {
+	Duration          time.Duration // Length of the phase. Zero lasts forever.
+	Wind              float64       // Mean wind speed in meters per second.
+	RainRate          float64       // Rain in millimeters per minute.
+	StrikesPerMinute  float64       // Mean lightning strikes per minute.
+	StrikeDistance    float64       // Mean lightning strike distance in kilometers.
+	CloudCover        float64       // Cloud cover from 0 (clear) to 1 (overcast).
+	TemperatureOffset float64       // Degrees Celsius added to the diurnal temperature.
+	PressureOffset    float64       // Millibars added to the station pressure.
+}
+
+// A Scenario is a script of weather phases. The last phase continues
+// once the others have passed.
+type Scenario []ScenarioPhase
+
+// Built-in scenarios.
+var (
+	// ScenarioFair is a fair weather day with a light breeze.
+	ScenarioFair = Scenario{
+		{Wind: 3, CloudCover: 0.1},
+	}
+
+	// ScenarioStorm is a thunderstorm passing over the station after
+	// half an hour of fair weather.
+	ScenarioStorm = Scenario{
+		{Duration: 30 * time.Minute, Wind: 3, CloudCover: 0.2},
+		{Duration: 20 * time.Minute, Wind: 7, StrikesPerMinute: 1, StrikeDistance: 25, CloudCover: 0.6, PressureOffset: -1},
+		{Duration: 20 * time.Minute, Wind: 14, RainRate: 0.6, StrikesPerMinute: 6, StrikeDistance: 5, CloudCover: 1, TemperatureOffset: -6, PressureOffset: -3},
+		{Duration: 20 * time.Minute, Wind: 6, RainRate: 0.05, StrikesPerMinute: 0.5, StrikeDistance: 20, CloudCover: 0.7, TemperatureOffset: -3, PressureOffset: -1},
+		{Wind: 3, CloudCover: 0.3, TemperatureOffset: -1},
+	}
+)
+
+// scenarios maps scenario names to the built-in scenarios.
+var scenarios = map[string]Scenario{
+	"fair":  ScenarioFair,
+	"storm": ScenarioStorm,
+}
+
+// ScenarioByName returns the built-in scenario with the name.
+func ScenarioByName(name string) (Scenario, error) {
+	scenario, found := scenarios[name]
+	if !found {
+		return nil, fmt.Errorf("unknown scenario: %s", name)
+	}
+
+	return scenario, nil
+}
+
+// phase returns the phase of the scenario at the elapsed time.
+func (s Scenario) phase(elapsed time.Duration) ScenarioPhase {
+	if len(s) == 0 {
+		return ScenarioFair[0]
+	}
+
+	for _, phase := range s[:len(s)-1] {
+		if phase.Duration <= 0 || elapsed < phase.Duration {
+			return phase
+		}
+		elapsed -= phase.Duration
+	}
+
+	return s[len(s)-1]
+}
+
+// SimulatorConfig configures the virtual hubs and sensors of a
+// Simulator. Zero fields use defaults.
+type SimulatorConfig struct {
+	Hubs          int           // Number of hubs. Defaults to 1.
+	SensorsPerHub int           // Number of sensors paired with each hub. Defaults to 1.
+	Start         time.Time     // Simulated time of the first message. Defaults to now.
+	Duration      time.Duration // Simulated time to run for. Zero runs forever.
+	Speed         float64       // Multiple of real time. Zero runs as fast as possible.
+	Seed          int64         // Seed for the weather. Zero uses a random seed.
+	Scenario      Scenario      // Weather script. Defaults to ScenarioFair.
+	MeanTemp      float64       // Mean daily temperature in degrees Celsius. Defaults to 15.
+}
+
+// Simulator generates the messages a network of Tempest hubs and
+// sensors broadcasts, with a diurnal temperature cycle, gusty wind and
+// the weather of a scripted scenario.
+//
+// Simulator is a Source, so a Network can read simulated messages
+// in-process. Broadcast sends the messages to a UDP address instead.
+type Simulator struct {
+	config SimulatorConfig
+	random *rand.Rand
+	hubs   []*simulatedHub
+	now    time.Time
+	end    time.Time
+	queue  []Datagram // Datagrams due at the current time.
+	pacer  pacer
+
+	closed chan struct{}
+	once   sync.Once
+}
+
+// simulatedHub is a virtual hub and its sensors.
+type simulatedHub struct {
+	serial     string
+	ip         net.IP
+	started    time.Time
+	nextStatus time.Time
+	seq        int
+	sensors    []*simulatedSensor
+}
+
+// simulatedSensor is a virtual Tempest sensor.
+type simulatedSensor struct {
+	serial     string
+	nextRapid  time.Time
+	nextObs    time.Time
+	nextStatus time.Time
+	direction  float64
+	raining    bool
+
+	// Wind and lightning since the last observation.
+	windMin, windMax, windSum float64
+	windSamples               int
+	strikes                   int
+	strikeDistanceSum         float64
+}
+
+// NewSimulator returns a simulator for the configuration.
+func NewSimulator(config SimulatorConfig) *Simulator {
+	if config.Hubs <= 0 {
+		config.Hubs = 1
+	}
+
+	if config.SensorsPerHub <= 0 {
+		config.SensorsPerHub = 1
+	}
+
+	if config.Start.IsZero() {
+		config.Start = time.Now()
+	}
+
+	if config.Seed == 0 {
+		config.Seed = time.Now().UnixNano()
+	}
+
+	if len(config.Scenario) == 0 {
+		config.Scenario = ScenarioFair
+	}
+
+	if config.MeanTemp == 0 {
+		config.MeanTemp = 15
+	}
+
+	s := &Simulator{
+		config: config,
+		random: rand.New(rand.NewSource(config.Seed)),
+		now:    config.Start,
+		pacer:  pacer{speed: config.Speed},
+		closed: make(chan struct{}),
+	}
+
+	if config.Duration > 0 {
+		s.end = config.Start.Add(config.Duration)
+	}
+
+	for i := 0; i < config.Hubs; i++ {
+		hub := &simulatedHub{
+			serial:  fmt.Sprintf("HB-%08d", i+1),
+			ip:      net.IPv4(192, 168, 50, byte(10+i%240)),
+			started: config.Start.Add(-time.Hour),
+			// Stagger devices so their messages interleave.
+			nextStatus: config.Start.Add(time.Duration(i) * time.Second),
+		}
+
+		for j := 0; j < config.SensorsPerHub; j++ {
+			offset := time.Duration(i*config.SensorsPerHub+j) * 500 * time.Millisecond
+			hub.sensors = append(hub.sensors, &simulatedSensor{
+				serial:     fmt.Sprintf("ST-%08d", i*config.SensorsPerHub+j+1),
+				nextRapid:  config.Start.Add(offset),
+				nextObs:    config.Start.Add(offset),
+				nextStatus: config.Start.Add(offset + 30*time.Second),
+				direction:  s.random.Float64() * 360,
+			})
+		}
+
+		s.hubs = append(s.hubs, hub)
+	}
+
+	return s
+}
+
+// Read returns the next simulated datagram. Datagrams are returned at
+// the configured speed. Read returns io.EOF once the configured
+// duration has been simulated or the simulator is closed.
+func (s *Simulator) Read(ctx context.Context) (Datagram, error) {
+	select {
+	case <-s.closed:
+		return Datagram{}, io.EOF
+	default:
+	}
+
+	datagram, err := s.Next()
+	if err != nil {
+		return Datagram{}, err
+	}
+
+	if err := s.pacer.wait(ctx, s.closed, datagram.Received); err != nil {
+		return Datagram{}, err
+	}
+
+	return datagram, nil
+}
+
+// Close stops the simulator.
+func (s *Simulator) Close() error {
+	s.once.Do(func() {
+		close(s.closed)
+	})
+
+	return nil
+}
+
+// Next returns the next simulated datagram without waiting. Next
+// returns io.EOF once the configured duration has been simulated.
+func (s *Simulator) Next() (Datagram, error) {
+	for len(s.queue) == 0 {
+		if !s.end.IsZero() && !s.nextDue().Before(s.end) {
+			return Datagram{}, io.EOF
+		}
+
+		s.step()
+	}
+
+	datagram := s.queue[0]
+	s.queue = s.queue[1:]
+
+	return datagram, nil
+}
+
+// Broadcast sends the simulated datagrams to the UDP address until the
+// context is cancelled or the configured duration has been simulated.
+func (s *Simulator) Broadcast(ctx context.Context, addr *net.UDPAddr) error {
+	return SendUDP(ctx, s, addr)
+}
+
+// nextDue returns the time of the next scheduled message.
+func (s *Simulator) nextDue() time.Time {
+	due := time.Time{}
+	earliest := func(t time.Time) {
+		if due.IsZero() || t.Before(due) {
+			due = t
+		}
+	}
+
+	for _, hub := range s.hubs {
+		earliest(hub.nextStatus)
+		for _, sensor := range hub.sensors {
+			earliest(sensor.nextRapid)
+			earliest(sensor.nextObs)
+			earliest(sensor.nextStatus)
+		}
+	}
+
+	return due
+}
+
+// step advances to the next scheduled time and queues every message
+// due at that time.
+func (s *Simulator) step() {
+	s.now = s.nextDue()
+	phase := s.config.Scenario.phase(s.now.Sub(s.config.Start))
+
+	for _, hub := range s.hubs {
+		for _, sensor := range hub.sensors {
+			if !sensor.nextRapid.After(s.now) {
+				s.rapidWind(hub, sensor, phase)
+				sensor.nextRapid = sensor.nextRapid.Add(simulatedRapidWindInterval)
+			}
+
+			if !sensor.nextObs.After(s.now) {
+				s.observation(hub, sensor, phase)
+				sensor.nextObs = sensor.nextObs.Add(simulatedObservationInterval)
+			}
+
+			if !sensor.nextStatus.After(s.now) {
+				s.deviceStatus(hub, sensor)
+				sensor.nextStatus = sensor.nextStatus.Add(simulatedDeviceStatusInterval)
+			}
+		}
+
+		if !hub.nextStatus.After(s.now) {
+			s.hubStatus(hub)
+			hub.nextStatus = hub.nextStatus.Add(simulatedHubStatusInterval)
+		}
+	}
+
+	// Keep datagrams in time order for readers pacing the simulation.
+	sort.SliceStable(s.queue, func(i, j int) bool {
+		return s.queue[i].Received.Before(s.queue[j].Received)
+	})
+}
+
+// send queues the message from the hub at the current time.
+func (s *Simulator) send(hub *simulatedHub, message map[string]interface{}) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		// Messages only hold numbers, strings and slices of them.
+		panic(fmt.Sprintf("tempest: error encoding simulated message: %v", err))
+	}
+
+	s.queue = append(s.queue, Datagram{
+		Data:     data,
+		Source:   hub.ip,
+		Received: s.now,
+	})
+}
+
+// rapidWind queues a rapid wind message and lightning strikes.
+func (s *Simulator) rapidWind(hub *simulatedHub, sensor *simulatedSensor, phase ScenarioPhase) {
+	// Gusty wind: a noisy mean with occasional gusts well above it.
+	speed := phase.Wind * (1 + 0.3*s.random.NormFloat64())
+	if s.random.Float64() < 0.1 {
+		speed *= 1.8
+	}
+	speed = math.Max(0, speed)
+
+	sensor.direction = math.Mod(sensor.direction+8*s.random.NormFloat64()+360, 360)
+	if speed == 0 {
+		sensor.direction = 0
+	}
+
+	if sensor.windSamples == 0 || speed < sensor.windMin {
+		sensor.windMin = speed
+	}
+	if speed > sensor.windMax {
+		sensor.windMax = speed
+	}
+	sensor.windSum += speed
+	sensor.windSamples++
+
+	s.send(hub, map[string]interface{}{
+		"serial_number": sensor.serial,
+		"type":          MessageTypeRapidWind,
+		"hub_sn":        hub.serial,
+		"ob":            []interface{}{s.now.Unix(), round(speed, 2), int(sensor.direction)},
+	})
+
+	// Lightning arrives at random at the phase's strike rate.
+	chance := phase.StrikesPerMinute * simulatedRapidWindInterval.Minutes()
+	if phase.StrikesPerMinute > 0 && s.random.Float64() < chance {
+		distance := math.Max(1, math.Round(phase.StrikeDistance+3*s.random.NormFloat64()))
+		sensor.strikes++
+		sensor.strikeDistanceSum += distance
+
+		s.send(hub, map[string]interface{}{
+			"serial_number": sensor.serial,
+			"type":          MessageTypeLightningStrike,
+			"hub_sn":        hub.serial,
+			"evt":           []interface{}{s.now.Unix(), distance, s.random.Intn(10000)},
+		})
+	}
+}
+
+// observation queues an obs_st message summarizing the last minute and
+// a rain start event when rain begins.
+func (s *Simulator) observation(hub *simulatedHub, sensor *simulatedSensor, phase ScenarioPhase) {
+	hour := float64(s.now.Hour()) + float64(s.now.Minute())/60
+
+	// Temperature peaks mid afternoon, humidity falls as it warms.
+	temperature := s.config.MeanTemp + 6*math.Sin(2*math.Pi*(hour-9)/24) + phase.TemperatureOffset + 0.1*s.random.NormFloat64()
+	humidity := 70 - 2.5*(temperature-s.config.MeanTemp)
+	if phase.RainRate > 0 {
+		humidity += 25
+	}
+	humidity = math.Min(100, math.Max(5, humidity))
+
+	pressure := 1013 + phase.PressureOffset + 0.5*math.Sin(2*math.Pi*hour/12)
+
+	// Daylight between 6:00 and 18:00, dimmed by cloud.
+	daylight := math.Max(0, math.Sin(math.Pi*(hour-6)/12))
+	illuminance := daylight * 110000 * (1 - 0.8*phase.CloudCover)
+	uv := daylight * 10 * (1 - 0.7*phase.CloudCover)
+
+	rain := 0.0
+	precipitationType := 0
+	if phase.RainRate > 0 {
+		rain = phase.RainRate * (0.5 + s.random.Float64())
+		precipitationType = 1
+	}
+
+	if rain > 0 && !sensor.raining {
+		s.send(hub, map[string]interface{}{
+			"serial_number": sensor.serial,
+			"type":          MessageTypeRainStartEvent,
+			"hub_sn":        hub.serial,
+			"evt":           []interface{}{s.now.Unix()},
+		})
+	}
+	sensor.raining = rain > 0
+
+	lull, average, gust := 0.0, 0.0, 0.0
+	if sensor.windSamples > 0 {
+		lull = sensor.windMin
+		average = sensor.windSum / float64(sensor.windSamples)
+		gust = sensor.windMax
+	}
+
+	strikeDistance := 0.0
+	if sensor.strikes > 0 {
+		strikeDistance = math.Round(sensor.strikeDistanceSum / float64(sensor.strikes))
+	}
+
+	s.send(hub, map[string]interface{}{
+		"serial_number": sensor.serial,
+		"type":          MessageTypeObservation,
+		"hub_sn":        hub.serial,
+		"obs": [][]interface{}{{
+			s.now.Unix(),
+			round(lull, 2),
+			round(average, 2),
+			round(gust, 2),
+			int(sensor.direction),
+			int(simulatedRapidWindInterval.Seconds()),
+			round(pressure, 2),
+			round(temperature, 2),
+			round(humidity, 2),
+			int(illuminance),
+			round(uv, 2),
+			int(illuminance / 120),
+			round(rain, 3),
+			precipitationType,
+			strikeDistance,
+			sensor.strikes,
+			2.6,
+			int(simulatedObservationInterval.Minutes()),
+		}},
+		"firmware_revision": 176,
+	})
+
+	sensor.windMin, sensor.windMax, sensor.windSum = 0, 0, 0
+	sensor.windSamples = 0
+	sensor.strikes = 0
+	sensor.strikeDistanceSum = 0
+}
+
+// deviceStatus queues a device_status message for the sensor.
+func (s *Simulator) deviceStatus(hub *simulatedHub, sensor *simulatedSensor) {
+	s.send(hub, map[string]interface{}{
+		"serial_number":     sensor.serial,
+		"type":              MessageTypeDeviceStatus,
+		"hub_sn":            hub.serial,
+		"timestamp":         s.now.Unix(),
+		"uptime":            int(s.now.Sub(hub.started).Seconds()),
+		"voltage":           2.6,
+		"firmware_revision": 176,
+		"rssi":              -60 - s.random.Intn(20),
+		"hub_rssi":          -60 - s.random.Intn(20),
+		"sensor_status":     0,
+		"debug":             0,
+	})
+}
+
+// hubStatus queues a hub_status message for the hub.
+func (s *Simulator) hubStatus(hub *simulatedHub) {
+	hub.seq++
+
+	s.send(hub, map[string]interface{}{
+		"serial_number":     hub.serial,
+		"type":              MessageTypeHubStatus,
+		"firmware_revision": "171",
+		"uptime":            int(s.now.Sub(hub.started).Seconds()),
+		"rssi":              -50 - s.random.Intn(20),
+		"timestamp":         s.now.Unix(),
+		"reset_flags":       "BOR,PIN,POR",
+		"seq":               hub.seq,
+		"radio_stats":       []int{25, 1, 0, int(RadioActive), 16355},
+	})
+}
+
+// round rounds the value to the number of decimal places.
+func round(value float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(value*scale) / scale
+}
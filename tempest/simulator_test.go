@@ -0,0 +1,129 @@
+package tempest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestSimulator_Decodes(t *testing.T) {
+	sim := NewSimulator(SimulatorConfig{
+		Hubs:          2,
+		SensorsPerHub: 2,
+		Start:         time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC),
+		Duration:      10 * time.Minute,
+		Seed:          1,
+	})
+
+	n := NewNetwork("test")
+	sub := n.SubscribeBuffered(4096)
+	defer sub.Unsubscribe()
+
+	if err := n.RunSources(context.Background(), sim); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := n.Stats(); stats.Decoded != stats.Datagrams || stats.DecodeErrors != 0 {
+		t.Errorf("expected every datagram to decode, got %+v", stats)
+	}
+
+	counts := map[Type]int{}
+	for len(sub.Messages()) > 0 {
+		counts[(<-sub.Messages()).Type()]++
+	}
+
+	// Four sensors over ten minutes on two hubs.
+	expected := map[Type]int{
+		MessageTypeRapidWind:    4 * 200,
+		MessageTypeObservation:  4 * 10,
+		MessageTypeDeviceStatus: 4 * 10,
+		MessageTypeHubStatus:    2 * 60,
+		MessageTypeHubOnline:    2,
+		MessageTypeSensorOnline: 4,
+	}
+	for messageType, count := range expected {
+		if counts[messageType] != count {
+			t.Errorf("expected %d %s messages, got %d", count, messageType, counts[messageType])
+		}
+	}
+
+	if len(n.HubManager().Hubs()) != 2 || len(n.HubManager().Sensors("HB-00000002")) != 2 {
+		t.Errorf("unexpected hubs: %+v", n.HubManager().Hubs())
+	}
+}
+
+func TestSimulator_Seed(t *testing.T) {
+	config := SimulatorConfig{
+		Start:    time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC),
+		Duration: 5 * time.Minute,
+		Seed:     42,
+	}
+	first, second := NewSimulator(config), NewSimulator(config)
+
+	for {
+		a, err := first.Next()
+		b, _ := second.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if !bytes.Equal(a.Data, b.Data) || !a.Received.Equal(b.Received) {
+			t.Fatalf("simulators with the same seed differ: %s != %s", a.Data, b.Data)
+		}
+	}
+}
+
+func TestSimulator_Storm(t *testing.T) {
+	sim := NewSimulator(SimulatorConfig{
+		Start:    time.Date(2021, 6, 1, 15, 0, 0, 0, time.UTC),
+		Duration: 30 * time.Minute,
+		Seed:     7,
+		Scenario: Scenario{
+			{Duration: 5 * time.Minute, Wind: 2},
+			{Wind: 12, RainRate: 0.5, StrikesPerMinute: 6, StrikeDistance: 8, CloudCover: 1},
+		},
+	})
+
+	n := NewNetwork("test")
+	sub := n.SubscribeBuffered(4096, MessageTypeRainStartEvent, MessageTypeLightningStrike)
+	defer sub.Unsubscribe()
+
+	if err := n.RunSources(context.Background(), sim); err != nil {
+		t.Fatal(err)
+	}
+
+	var rainStarts, strikes int
+	for len(sub.Messages()) > 0 {
+		switch m := (<-sub.Messages()).(type) {
+		case *RainStartEvent:
+			rainStarts++
+			if m.Time().Before(time.Date(2021, 6, 1, 15, 5, 0, 0, time.UTC)) {
+				t.Errorf("rain started before the storm at %s", m.Time())
+			}
+		case *LightningStrikeEvent:
+			strikes++
+		}
+	}
+
+	if rainStarts != 1 {
+		t.Errorf("expected rain to start once, got %d", rainStarts)
+	}
+
+	if strikes < 50 {
+		t.Errorf("expected regular lightning in the storm, got %d strikes", strikes)
+	}
+}
+
+func TestSimulator_Close(t *testing.T) {
+	sim := NewSimulator(SimulatorConfig{Speed: 1})
+	if _, err := sim.Read(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	sim.Close()
+	if _, err := sim.Read(context.Background()); err != io.EOF {
+		t.Errorf("expected io.EOF after close, got %v", err)
+	}
+}
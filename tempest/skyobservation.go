@@ -0,0 +1,228 @@
+package tempest
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	obsSkyIndexTimestampEpochUTC int = iota
+	obsSkyIndexIlluminance
+	obsSkyIndexUV
+	obsSkyIndexRainAccumulation
+	obsSkyIndexWindLull
+	obsSkyIndexWindAverage
+	obsSkyIndexWindGust
+	obsSkyIndexWindDirection
+	obsSkyIndexBatteryVolts
+	obsSkyIndexReportingInterval
+	obsSkyIndexSolarRadiation
+	obsSkyIndexLocalDayRainAccumulation
+	obsSkyIndexPrecipitationType
+	obsSkyIndexWindSampleInterval
+)
+
+// SkyObservation represents an observation from a legacy SKY sensor.
+type SkyObservation struct {
+	SensorSerial string
+	HubSerial    string
+	Observations []SkyReading
+}
+
+// SkyReading is a single reading reported in an obs_sky message.
+type SkyReading struct {
+	EpochSecondsUTC          time.Time // Epoch seconds UTC
+	Illuminance              int       // lux
+	UV                       float64   // UV index 0-11
+	RainAccumulation         float64   // millimeters over the reporting interval
+	WindLull                 Speed     // meters per second
+	WindAverage              Speed     // meters per second
+	WindGust                 Speed     // meters per second
+	WindDirection            Direction // degrees
+	BatteryVolts             float64   // sensor battery voltage
+	ReportingInterval        int       // sensor reporting interval minutes
+	SolarRadiation           int       // watts per square meter
+	LocalDayRainAccumulation float64   // millimeters since local midnight
+	PrecipitationType        int       // 0=none, 1=rain, 2=hail
+	WindSampleInterval       int       // seconds
+
+	// Fields the sensor reported as null, read as zero.
+	Missing ObservationField
+}
+
+// Has returns true if the sensor reported every one of the fields.
+func (r *SkyReading) Has(fields ObservationField) bool {
+	return r.Missing&fields == 0
+}
+
+// Type returns the message type for the sky observation.
+func (s *SkyObservation) Type() Type {
+	return MessageTypeSkyObservation
+}
+
+// Time returns the time of the latest reading in the message.
+func (s *SkyObservation) Time() time.Time {
+	var latest time.Time
+	for _, ob := range s.Observations {
+		if ob.EpochSecondsUTC.After(latest) {
+			latest = ob.EpochSecondsUTC
+		}
+	}
+
+	return latest
+}
+
+// updateSensor records the sensor's reporting interval.
+func (s *SkyObservation) updateSensor(sensor *WeatherSensor) {
+	for _, ob := range s.Observations {
+		if ob.ReportingInterval > 0 {
+			sensor.ReportInterval = time.Duration(ob.ReportingInterval) * time.Minute
+		}
+	}
+}
+
+// Read parses the sky observation message.
+func (s *SkyObservation) Read(message []byte) error {
+	var rawMessage RawMessage
+	if err := json.Unmarshal(message, &rawMessage); err != nil {
+		return err
+	}
+
+	return s.read(rawMessage)
+}
+
+// read parses the sky observation from a raw message.
+func (s *SkyObservation) read(rawMessage RawMessage) error {
+	s.Observations = make([]SkyReading, 0)
+
+	sensorSerial, err := rawMessage.SensorSerial()
+	if err != nil {
+		return err
+	}
+	s.SensorSerial = sensorSerial
+
+	hubSerial, err := rawMessage.HubSerial()
+	if err != nil {
+		return err
+	}
+	s.HubSerial = hubSerial
+
+	obs, ok := rawMessage["obs"].([]interface{})
+	if !ok {
+		return fmt.Errorf("observations not found")
+	}
+
+	for _, ob := range obs {
+		observation, ok := ob.([]interface{})
+		if !ok || len(observation) <= obsSkyIndexWindSampleInterval {
+			return fmt.Errorf("sky observation does not have %d elements", obsSkyIndexWindSampleInterval+1)
+		}
+
+		reading := SkyReading{}
+
+		// Epoch seconds UTC the observation was taken. Every other
+		// field may be null when an instrument fails.
+		epochUtc, ok := observation[obsSkyIndexTimestampEpochUTC].(float64)
+		if !ok {
+			return fmt.Errorf("unable to read epoch seconds utc")
+		}
+		reading.EpochSecondsUTC = time.Unix(int64(epochUtc), 0)
+
+		// Illuminance.
+		illuminance, err := observationNumber(observation, obsSkyIndexIlluminance, FieldIlluminance, &reading.Missing)
+		if err != nil {
+			return err
+		}
+		reading.Illuminance = int(illuminance)
+
+		// UV index.
+		uv, err := observationNumber(observation, obsSkyIndexUV, FieldUV, &reading.Missing)
+		if err != nil {
+			return err
+		}
+		reading.UV = uv
+
+		// Rain accumulation.
+		rainAccumulation, err := observationNumber(observation, obsSkyIndexRainAccumulation, FieldRainAccumulation, &reading.Missing)
+		if err != nil {
+			return err
+		}
+		reading.RainAccumulation = rainAccumulation
+
+		// Wind lull.
+		windLull, err := observationNumber(observation, obsSkyIndexWindLull, FieldWindLull, &reading.Missing)
+		if err != nil {
+			return err
+		}
+		reading.WindLull = NewSpeed(windLull, MetersPerSecond)
+
+		// Wind average.
+		windAverage, err := observationNumber(observation, obsSkyIndexWindAverage, FieldWindAverage, &reading.Missing)
+		if err != nil {
+			return err
+		}
+		reading.WindAverage = NewSpeed(windAverage, MetersPerSecond)
+
+		// Wind gust.
+		windGust, err := observationNumber(observation, obsSkyIndexWindGust, FieldWindGust, &reading.Missing)
+		if err != nil {
+			return err
+		}
+		reading.WindGust = NewSpeed(windGust, MetersPerSecond)
+
+		// Wind direction.
+		windDirection, err := observationNumber(observation, obsSkyIndexWindDirection, FieldWindDirection, &reading.Missing)
+		if err != nil {
+			return err
+		}
+		reading.WindDirection = NewDirection(windDirection, Degrees)
+
+		// Battery volts.
+		batteryVolts, err := observationNumber(observation, obsSkyIndexBatteryVolts, FieldBatteryVolts, &reading.Missing)
+		if err != nil {
+			return err
+		}
+		reading.BatteryVolts = batteryVolts
+
+		// Reporting interval.
+		reportingInterval, err := observationNumber(observation, obsSkyIndexReportingInterval, FieldReportingInterval, &reading.Missing)
+		if err != nil {
+			return err
+		}
+		reading.ReportingInterval = int(reportingInterval)
+
+		// Solar radiation.
+		solarRadiation, err := observationNumber(observation, obsSkyIndexSolarRadiation, FieldSolarRadiation, &reading.Missing)
+		if err != nil {
+			return err
+		}
+		reading.SolarRadiation = int(solarRadiation)
+
+		// Local day rain accumulation. SKY firmware reports null
+		// until the hub has a local day to accumulate against.
+		localDayRain, err := observationNumber(observation, obsSkyIndexLocalDayRainAccumulation, FieldLocalDayRainAccumulation, &reading.Missing)
+		if err != nil {
+			return err
+		}
+		reading.LocalDayRainAccumulation = localDayRain
+
+		// Precipitation type.
+		precipitationType, err := observationNumber(observation, obsSkyIndexPrecipitationType, FieldPrecipitationType, &reading.Missing)
+		if err != nil {
+			return err
+		}
+		reading.PrecipitationType = int(precipitationType)
+
+		// Wind sample interval.
+		windSampleInterval, err := observationNumber(observation, obsSkyIndexWindSampleInterval, FieldWindSampleInterval, &reading.Missing)
+		if err != nil {
+			return err
+		}
+		reading.WindSampleInterval = int(windSampleInterval)
+
+		s.Observations = append(s.Observations, reading)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package tempest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSkyObservation_Read(t *testing.T) {
+	rawMessage := `{"serial_number":"SK-00008453","type":"obs_sky","hub_sn":"HB-00000001","obs":[[1493321340,9000,10,0.0,2.6,4.6,7.4,187,3.12,1,130,null,0,3]],"firmware_revision":29}`
+	obs := SkyObservation{}
+	if err := obs.Read([]byte(rawMessage)); err != nil {
+		t.Fatalf("error reading sky observation: %v", err)
+	}
+
+	if obs.SensorSerial != "SK-00008453" || obs.HubSerial != "HB-00000001" {
+		t.Errorf("unexpected serial numbers: %s %s", obs.SensorSerial, obs.HubSerial)
+	}
+
+	if len(obs.Observations) != 1 {
+		t.Fatalf("unexpected number of observations: %d", len(obs.Observations))
+	}
+
+	ob := obs.Observations[0]
+	if !obs.Time().Equal(time.Unix(1493321340, 0)) {
+		t.Errorf("unexpected time: %v", obs.Time())
+	}
+
+	if ob.Illuminance != 9000 {
+		t.Errorf("unexpected illuminance: %d", ob.Illuminance)
+	}
+
+	if ob.UV != 10 {
+		t.Errorf("unexpected UV index: %f", ob.UV)
+	}
+
+	if ob.WindLull.MetersPerSecond() != 2.6 {
+		t.Errorf("unexpected wind lull: %f", ob.WindLull.MetersPerSecond())
+	}
+
+	if ob.WindAverage.MetersPerSecond() != 4.6 {
+		t.Errorf("unexpected wind average: %f", ob.WindAverage.MetersPerSecond())
+	}
+
+	if ob.WindGust.MetersPerSecond() != 7.4 {
+		t.Errorf("unexpected wind gust: %f", ob.WindGust.MetersPerSecond())
+	}
+
+	if ob.WindDirection.Degrees() != 187 {
+		t.Errorf("unexpected wind direction: %f", ob.WindDirection.Degrees())
+	}
+
+	if ob.BatteryVolts != 3.12 {
+		t.Errorf("unexpected battery volts: %f", ob.BatteryVolts)
+	}
+
+	if ob.SolarRadiation != 130 {
+		t.Errorf("unexpected solar radiation: %d", ob.SolarRadiation)
+	}
+
+	if ob.LocalDayRainAccumulation != 0 {
+		t.Errorf("unexpected local day rain accumulation: %f", ob.LocalDayRainAccumulation)
+	}
+
+	if ob.WindSampleInterval != 3 {
+		t.Errorf("unexpected wind sample interval: %d", ob.WindSampleInterval)
+	}
+}
+
+func TestSkyObservation_ReadNullFields(t *testing.T) {
+	// The wind and light instruments have failed and report null.
+	rawMessage := `{"serial_number":"SK-00008453","type":"obs_sky","hub_sn":"HB-00000001","obs":[[1493321340,null,null,0.5,null,null,null,null,3.12,1,null,null,1,3]],"firmware_revision":29}`
+	obs := SkyObservation{}
+	if err := obs.Read([]byte(rawMessage)); err != nil {
+		t.Fatalf("error reading sky observation: %v", err)
+	}
+
+	ob := obs.Observations[0]
+	if ob.Has(FieldWindLull) || ob.Has(FieldWindAverage) || ob.Has(FieldWindGust) || ob.Has(FieldWindDirection) {
+		t.Errorf("expected wind fields to be missing")
+	}
+
+	if ob.Has(FieldIlluminance | FieldUV | FieldSolarRadiation) {
+		t.Errorf("expected light fields to be missing")
+	}
+
+	if !ob.Has(FieldRainAccumulation | FieldBatteryVolts | FieldPrecipitationType | FieldWindSampleInterval) {
+		t.Errorf("unexpected missing fields: %s", ob.Missing)
+	}
+
+	if ob.RainAccumulation != 0.5 || ob.WindAverage.MetersPerSecond() != 0 {
+		t.Errorf("unexpected readings: %+v", ob)
+	}
+
+	// The hub has no local day yet.
+	if ob.Has(FieldLocalDayRainAccumulation) {
+		t.Errorf("expected local day rain accumulation to be missing")
+	}
+
+	rawMessage = `{"serial_number":"SK-00008453","type":"obs_sky","hub_sn":"HB-00000001","obs":[[1493321340,9000,10,0.0,2.6,4.6,7.4,187,3.12,1,130,1.5,0,3]]}`
+	if err := obs.Read([]byte(rawMessage)); err != nil {
+		t.Fatalf("error reading sky observation: %v", err)
+	}
+
+	if ob := obs.Observations[0]; !ob.Has(FieldLocalDayRainAccumulation) || ob.LocalDayRainAccumulation != 1.5 {
+		t.Errorf("unexpected local day rain accumulation: %f missing %s", ob.LocalDayRainAccumulation, ob.Missing)
+	}
+
+	// Values that are neither numbers nor null are errors.
+	rawMessage = `{"serial_number":"SK-00008453","type":"obs_sky","hub_sn":"HB-00000001","obs":[[1493321340,9000,10,0.0,2.6,"4.6",7.4,187,3.12,1,130,null,0,3]]}`
+	if err := obs.Read([]byte(rawMessage)); err == nil {
+		t.Errorf("expected an error reading a string wind average")
+	}
+}
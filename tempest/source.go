@@ -0,0 +1,216 @@
+package tempest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Port is the UDP port Tempest hubs broadcast messages on.
+const Port = 50222
+
+// A Datagram is a single message received from a hub.
+type Datagram struct {
+	Data     []byte    // The message, normally a JSON object.
+	Source   net.IP    // IP address of the hub that sent the datagram.
+	Received time.Time // Time the datagram was received.
+}
+
+// A Source supplies datagrams to a Network.
+//
+// Read returns the next datagram, or io.EOF when the source has no
+// more datagrams. The datagram's data is only valid until the next
+// call to Read. Close must unblock a pending Read.
+type Source interface {
+	Read(ctx context.Context) (Datagram, error)
+	Close() error
+}
+
+// UDPSource reads datagrams broadcast by hubs on the local network.
+type UDPSource struct {
+	conn   *net.UDPConn
+	buffer []byte
+}
+
+// ListenUDP returns a source listening for datagrams on the address.
+// Use an address with the IP net.IPv4zero and Port to listen to all
+// interfaces.
+func ListenUDP(addr *net.UDPAddr) (*UDPSource, error) {
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to network: %w", err)
+	}
+
+	return &UDPSource{
+		conn: conn,
+		// One byte larger than the largest datagram to detect
+		// oversized datagrams, which the connection truncates.
+		buffer: make([]byte, MaxDatagramSize+1),
+	}, nil
+}
+
+// Addr returns the local address the source is listening on.
+func (u *UDPSource) Addr() *net.UDPAddr {
+	return u.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Read returns the next datagram received on the connection.
+func (u *UDPSource) Read(ctx context.Context) (Datagram, error) {
+	bytesRead, source, err := u.conn.ReadFromUDP(u.buffer)
+	if err != nil {
+		if errors.Is(err, net.ErrClosed) {
+			return Datagram{}, io.EOF
+		}
+
+		return Datagram{}, err
+	}
+
+	return Datagram{
+		Data:     u.buffer[:bytesRead],
+		Source:   source.IP,
+		Received: time.Now(),
+	}, nil
+}
+
+// Close closes the connection.
+func (u *UDPSource) Close() error {
+	err := u.conn.Close()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// SendUDP sends the datagrams read from the source to the UDP address
+// until the source is exhausted or the context is cancelled. The
+// source is not closed.
+func SendUDP(ctx context.Context, source Source, addr *net.UDPAddr) error {
+	// An unconnected socket keeps sending when nothing is listening yet,
+	// rather than failing on the ICMP errors a connected socket reports.
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return fmt.Errorf("error opening socket: %w", err)
+	}
+	defer conn.Close()
+
+	for {
+		datagram, err := source.Read(ctx)
+		if err != nil {
+			if err == io.EOF || ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		if _, err := conn.WriteToUDP(datagram.Data, addr); err != nil {
+			return fmt.Errorf("error sending datagram to %s: %w", addr, err)
+		}
+	}
+}
+
+// ChannelSource reads datagrams sent on a channel. It lets tests and
+// applications feed datagrams to a network without opening sockets.
+type ChannelSource struct {
+	datagrams <-chan Datagram
+	closed    chan struct{}
+	once      sync.Once
+}
+
+// NewChannelSource returns a source reading datagrams from the channel.
+// The source is exhausted when the channel is closed.
+func NewChannelSource(datagrams <-chan Datagram) *ChannelSource {
+	return &ChannelSource{
+		datagrams: datagrams,
+		closed:    make(chan struct{}),
+	}
+}
+
+// Read returns the next datagram sent on the channel.
+func (c *ChannelSource) Read(ctx context.Context) (Datagram, error) {
+	select {
+	case datagram, ok := <-c.datagrams:
+		if !ok {
+			return Datagram{}, io.EOF
+		}
+
+		if datagram.Received.IsZero() {
+			datagram.Received = time.Now()
+		}
+
+		return datagram, nil
+	case <-c.closed:
+		return Datagram{}, io.EOF
+	case <-ctx.Done():
+		return Datagram{}, ctx.Err()
+	}
+}
+
+// Close stops the source. The channel is not closed.
+func (c *ChannelSource) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+
+	return nil
+}
+
+// ReaderSource reads datagrams from a reader holding one message per
+// line, such as a file of saved messages or standard input.
+type ReaderSource struct {
+	reader  io.Reader
+	scanner *bufio.Scanner
+	source  net.IP
+}
+
+// NewReaderSource returns a source reading one datagram per line from
+// the reader. Datagrams are reported as sent by the source IP address.
+// Closing the source closes the reader if it is an io.Closer.
+func NewReaderSource(reader io.Reader, source net.IP) *ReaderSource {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, MaxDatagramSize+1), 1024*1024)
+
+	return &ReaderSource{
+		reader:  reader,
+		scanner: scanner,
+		source:  source,
+	}
+}
+
+// Read returns the next non-empty line.
+func (r *ReaderSource) Read(ctx context.Context) (Datagram, error) {
+	for r.scanner.Scan() {
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		return Datagram{
+			Data:     line,
+			Source:   r.source,
+			Received: time.Now(),
+		}, nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return Datagram{}, err
+	}
+
+	return Datagram{}, io.EOF
+}
+
+// Close closes the reader if it is an io.Closer.
+func (r *ReaderSource) Close() error {
+	if closer, ok := r.reader.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package tempest
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNetwork_RunSourcesReader(t *testing.T) {
+	input := strings.Join([]string{
+		`{"serial_number":"ST-00000512","type":"rapid_wind","hub_sn":"HB-00013030","ob":[1493322445,2.3,128]}`,
+		``,
+		`not json`,
+		`{"serial_number":"ST-00000512","type":"rapid_wind","hub_sn":"HB-00013030","ob":[1493322448,2.5,130]}`,
+	}, "\n")
+
+	n := NewNetwork("test")
+	sub := n.Subscribe(MessageTypeRapidWind)
+	defer sub.Unsubscribe()
+
+	source := NewReaderSource(strings.NewReader(input), net.IPv4(192, 168, 1, 2))
+	if err := n.RunSources(context.Background(), source); err != nil {
+		t.Fatalf("unexpected error running sources: %v", err)
+	}
+
+	if len(sub.Messages()) != 2 {
+		t.Errorf("expected 2 rapid wind messages, got %d", len(sub.Messages()))
+	}
+
+	stats := n.Stats()
+	if stats.Datagrams != 3 || stats.Malformed != 1 || stats.Decoded != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestNetwork_RunSourcesShareRegistry(t *testing.T) {
+	first := make(chan Datagram, 1)
+	second := make(chan Datagram, 1)
+
+	first <- Datagram{
+		Data:   []byte(`{"serial_number":"ST-00000001","type":"rapid_wind","hub_sn":"HB-00000001","ob":[1493322445,2.3,128]}`),
+		Source: net.IPv4(192, 168, 1, 2),
+	}
+	second <- Datagram{
+		Data:   []byte(`{"serial_number":"ST-00000002","type":"rapid_wind","hub_sn":"HB-00000002","ob":[1493322445,2.3,128]}`),
+		Source: net.IPv4(192, 168, 1, 3),
+	}
+	close(first)
+	close(second)
+
+	n := NewNetwork("test")
+	if err := n.RunSources(context.Background(), NewChannelSource(first), NewChannelSource(second)); err != nil {
+		t.Fatalf("unexpected error running sources: %v", err)
+	}
+
+	hubs := n.HubManager().Hubs()
+	if len(hubs) != 2 {
+		t.Fatalf("expected hubs from both sources, got %d", len(hubs))
+	}
+
+	if !hubs[1].IPAddress.Equal(net.IPv4(192, 168, 1, 3)) {
+		t.Errorf("unexpected hub address: %s", hubs[1].IPAddress)
+	}
+}
+
+func TestNetwork_StopUnblocksChannelSource(t *testing.T) {
+	n := NewNetwork("test")
+	if err := n.StartSources(context.Background(), NewChannelSource(make(chan Datagram))); err != nil {
+		t.Fatalf("unexpected error starting sources: %v", err)
+	}
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- n.Stop() }()
+
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("unexpected error stopping network: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("stop did not unblock the source")
+	}
+}
+
+func TestChannelSource_Close(t *testing.T) {
+	source := NewChannelSource(make(chan Datagram))
+	source.Close()
+	source.Close()
+
+	if _, err := source.Read(context.Background()); err != io.EOF {
+		t.Errorf("expected io.EOF after close, got %v", err)
+	}
+}
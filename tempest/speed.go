@@ -1,5 +1,7 @@
 package tempest
 
+import "encoding/json"
+
 // SpeedUnit represents a unit of speed.
 type SpeedUnit int
 
@@ -25,6 +27,29 @@ func NewSpeed(speed float64, unit SpeedUnit) Speed {
 	}
 }
 
+// speedJSON is the JSON form of a speed reading.
+type speedJSON struct {
+	Speed float64
+	Unit  SpeedUnit
+}
+
+// MarshalJSON encodes the speed reading and its unit.
+func (s Speed) MarshalJSON() ([]byte, error) {
+	return json.Marshal(speedJSON{Speed: s.speed, Unit: s.unit})
+}
+
+// UnmarshalJSON decodes a speed reading encoded by MarshalJSON.
+func (s *Speed) UnmarshalJSON(data []byte) error {
+	var decoded speedJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	*s = NewSpeed(decoded.Speed, decoded.Unit)
+
+	return nil
+}
+
 // MetersPerSecond converts the speed to meters per second.
 func (s *Speed) MetersPerSecond() float64 {
 	switch s.unit {
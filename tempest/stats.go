@@ -0,0 +1,41 @@
+package tempest
+
+import "sync/atomic"
+
+// Stats are counters for datagrams received by a network.
+type Stats struct {
+	Datagrams    uint64 // Datagrams received.
+	Oversized    uint64 // Datagrams dropped for exceeding MaxDatagramSize.
+	Malformed    uint64 // Datagrams dropped for not holding a single JSON object.
+	InvalidType  uint64 // Datagrams dropped for a missing or unknown message type.
+	QueueDropped uint64 // Messages dropped because the processing queue was full.
+	Coalesced    uint64 // Rapid wind messages dropped to make room in the processing queue.
+	DecodeErrors uint64 // Messages dropped because decoding failed.
+	Decoded      uint64 // Messages decoded and delivered to subscribers.
+}
+
+// stats are the counters updated while the network is running.
+type stats struct {
+	datagrams    atomic.Uint64
+	oversized    atomic.Uint64
+	malformed    atomic.Uint64
+	invalidType  atomic.Uint64
+	queueDropped atomic.Uint64
+	coalesced    atomic.Uint64
+	decodeErrors atomic.Uint64
+	decoded      atomic.Uint64
+}
+
+// snapshot returns the current value of the counters.
+func (s *stats) snapshot() Stats {
+	return Stats{
+		Datagrams:    s.datagrams.Load(),
+		Oversized:    s.oversized.Load(),
+		Malformed:    s.malformed.Load(),
+		InvalidType:  s.invalidType.Load(),
+		QueueDropped: s.queueDropped.Load(),
+		Coalesced:    s.coalesced.Load(),
+		DecodeErrors: s.decodeErrors.Load(),
+		Decoded:      s.decoded.Load(),
+	}
+}
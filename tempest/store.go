@@ -0,0 +1,1568 @@
+package tempest
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSegmentSize is the size an ObservationStore seals a segment
+// at when StoreConfig.SegmentSize is zero.
+const DefaultSegmentSize = 8 << 20
+
+// Files of an ObservationStore.
+const (
+	storeSegmentExt = ".seg"
+	storeIndexExt   = ".idx"
+	storeTempExt    = ".tmp"
+	storeTombstones = "tombstones"
+	storeCompaction = "compaction"
+)
+
+var (
+	storeSegmentMagic = []byte("TMPSEG1\n") // Segment of records.
+	storeBlockMagic   = []byte("TMPSEG2\n") // Compacted segment of blocks.
+	storeIndexMagic   = []byte("TMPIDX2\n")
+	storeChecksums    = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// storeFrameHeader is the length of a frame's payload length and
+// checksum.
+const storeFrameHeader = 8
+
+// storeBlockSize is the most observations compacted into a block.
+const storeBlockSize = 1440
+
+// storeFields is the number of fields of a WeatherObservation saved
+// after its time.
+const storeFields = 17
+
+var errStoreClosed = errors.New("observation store is closed")
+
+// StoreConfig configures an ObservationStore.
+type StoreConfig struct {
+	Dir         string // Directory for the store's files.
+	SegmentSize int64  // Seal a segment and start the next after this many bytes. Defaults to DefaultSegmentSize.
+	Sync        bool   // Sync every save and delete to disk before returning.
+}
+
+// ObservationStore is a MessageRepo keeping the observations of obs_st
+// messages in append-only segment files, so years of history fit on a
+// small computer without a database. It only saves Observation
+// messages; other messages are rejected with ErrUnsupportedMessage.
+//
+// Each WeatherObservation is saved as a record framed by its length
+// and a CRC-32C checksum. Records are appended to the newest segment
+// until it reaches the segment size, when it is sealed by writing an
+// index of its records by sensor and time. Opening the store discards
+// a record torn by a crash at the end of the newest segment and
+// rebuilds missing or damaged indexes.
+//
+// Deleting messages records a tombstone hiding the deleted records
+// until Compact rewrites the sealed segments without them. Compacted
+// segments hold blocks of each sensor's observations in the compressed
+// encoding of EncodeObservations.
+//
+// Messages are streamed with one observation each, in the order they
+// were saved when their times are equal, until the observations are
+// compacted. The sensor's coordinate is not saved.
+//
+// ObservationStore is safe for concurrent use by one process.
+type ObservationStore struct {
+	config StoreConfig
+
+	mu         sync.RWMutex
+	segments   []*storeSegment // Ordered by ID. The last is being written.
+	index      *segmentIndex   // Index of the segment being written.
+	active     *os.File        // The segment being written, opened for appending.
+	tombstones []storeTombstone
+	deletes    *os.File // Tombstones, opened for appending.
+}
+
+// storeSegment summarises a segment so queries only read the indexes
+// of segments that may hold selected records.
+type storeSegment struct {
+	id      int
+	size    int64
+	blocks  bool // Compacted into blocks.
+	records int
+	first   int64 // Earliest record time in Unix nanoseconds.
+	last    int64 // Latest record time in Unix nanoseconds.
+	series  []storeSeries
+}
+
+// storeSeries identifies the sensor and hub that reported records.
+type storeSeries struct {
+	hub    string
+	sensor string
+}
+
+// segmentIndex indexes the records of a segment by series and time.
+type segmentIndex struct {
+	series  []storeSeries
+	entries [][]indexEntry // Entries of each series ordered by time, then offset.
+}
+
+// indexEntry locates a record in a segment.
+type indexEntry struct {
+	time   int64 // Unix nanoseconds.
+	offset int64 // Offset of the record's frame.
+	item   int   // Position of the record in its block.
+}
+
+// storeTombstone deletes the records selected by its query saved
+// before its position.
+type storeTombstone struct {
+	Query   MessageQuery `json:"query"`
+	Segment int          `json:"segment"`
+	Offset  int64        `json:"offset"`
+}
+
+// storeCompactionMarker records a compaction whose new segments are
+// ready, so a compaction interrupted by a crash is finished on open.
+// The first Compacted segments are replaced by the new segments and
+// the rest are removed.
+type storeCompactionMarker struct {
+	Segments  []int `json:"segments"`
+	Compacted int   `json:"compacted"`
+}
+
+// storeRef locates a record selected by a query.
+type storeRef struct {
+	time    int64
+	segment int // Position in segments.
+	offset  int64
+	item    int
+}
+
+// storeReader reads the records selected by a query, keeping segments
+// open and the last blocks read decoded.
+type storeReader struct {
+	store  *ObservationStore
+	files  map[int]*os.File
+	blocks map[storeRef]storeBlock // Keyed by segment and offset.
+}
+
+// storeBlock is a decoded block of a sensor's observations.
+type storeBlock struct {
+	series       storeSeries
+	observations []WeatherObservation
+}
+
+// storeReaderBlocks is the most blocks a storeReader keeps decoded.
+const storeReaderBlocks = 64
+
+// OpenObservationStore opens the store in the configured directory,
+// creating it if it does not exist.
+func OpenObservationStore(config StoreConfig) (*ObservationStore, error) {
+	if config.SegmentSize <= 0 {
+		config.SegmentSize = DefaultSegmentSize
+	}
+
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating store directory: %w", err)
+	}
+
+	s := &ObservationStore{config: config}
+	if err := s.recoverCompaction(); err != nil {
+		return nil, err
+	}
+
+	ids, err := s.segmentIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		if err := s.createSegment(1); err != nil {
+			return nil, err
+		}
+		ids = append(ids, 1)
+	}
+
+	for _, id := range ids[:len(ids)-1] {
+		segment, err := s.openSealed(id)
+		if err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, segment)
+	}
+
+	if err := s.openActive(ids[len(ids)-1]); err != nil {
+		return nil, err
+	}
+
+	if err := s.openTombstones(); err != nil {
+		s.active.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Close closes the store.
+func (s *ObservationStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active == nil {
+		return nil
+	}
+
+	err := s.active.Close()
+	if closeErr := s.deletes.Close(); err == nil {
+		err = closeErr
+	}
+	s.active, s.deletes = nil, nil
+
+	return err
+}
+
+// SaveMessage appends the observations of an Observation message to
+// the store.
+func (s *ObservationStore) SaveMessage(ctx context.Context, message WeatherMessage) error {
+	observation, ok := message.(*Observation)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedMessage, message.Type())
+	}
+
+	if len(observation.HubSerial) > math.MaxUint8 || len(observation.SensorSerial) > math.MaxUint8 {
+		return fmt.Errorf("serial numbers of %s are too long to save", observation.SensorSerial)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active == nil {
+		return errStoreClosed
+	}
+
+	frames := make([]byte, 0, len(observation.Observations)*256)
+	offsets := make([]int, 0, len(observation.Observations))
+	for _, ob := range observation.Observations {
+		offsets = append(offsets, len(frames))
+		frames = appendFrame(frames, appendRecord(nil, observation.HubSerial, observation.SensorSerial, ob))
+	}
+
+	segment := s.segments[len(s.segments)-1]
+	if segment.records > 0 && segment.size+int64(len(frames)) > s.config.SegmentSize {
+		if err := s.seal(); err != nil {
+			return err
+		}
+		segment = s.segments[len(s.segments)-1]
+	}
+
+	if _, err := s.active.Write(frames); err != nil {
+		// Remove a partial write so later records follow whole frames.
+		s.active.Truncate(segment.size)
+		return fmt.Errorf("error saving observation: %w", err)
+	}
+
+	if s.config.Sync {
+		if err := s.active.Sync(); err != nil {
+			return fmt.Errorf("error saving observation: %w", err)
+		}
+	}
+
+	series := storeSeries{hub: observation.HubSerial, sensor: observation.SensorSerial}
+	for i, ob := range observation.Observations {
+		s.index.add(series, indexEntry{time: ob.EpochSecondsUTC.UnixNano(), offset: segment.size + int64(offsets[i])})
+	}
+	segment.size += int64(len(frames))
+	segment.summarise(s.index)
+
+	return nil
+}
+
+// StreamMessages calls fn with an Observation message for each saved
+// observation selected by the query ordered by time, until fn returns
+// false. The store is locked for reading while streaming, so fn must
+// not save or delete messages.
+func (s *ObservationStore) StreamMessages(ctx context.Context, query MessageQuery, fn func(message WeatherMessage) bool) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.active == nil {
+		return errStoreClosed
+	}
+
+	refs, err := s.collect(query)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].before(refs[j])
+	})
+
+	reader := s.newReader()
+	defer reader.close()
+
+	for _, ref := range refs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		message, err := reader.read(ref)
+		if err != nil {
+			return err
+		}
+
+		if !fn(message) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// LatestMessages returns an Observation message with the latest saved
+// observation selected by the query from each sensor, ordered by
+// serial number.
+func (s *ObservationStore) LatestMessages(ctx context.Context, query MessageQuery) ([]WeatherMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.active == nil {
+		return nil, errStoreClosed
+	}
+
+	start, end := queryRange(query)
+	latest := make(map[string]storeRef)
+	err := s.selectSeries(query, func(position int, series storeSeries, entries []indexEntry) {
+		segmentID := s.segments[position].id
+		last := sort.Search(len(entries), func(i int) bool {
+			return entries[i].time >= end
+		})
+
+		for i := last - 1; i >= 0 && entries[i].time >= start; i-- {
+			if s.deleted(series, entries[i], segmentID) {
+				continue
+			}
+
+			ref := storeRef{time: entries[i].time, segment: position, offset: entries[i].offset, item: entries[i].item}
+			key := latestKey(series.hub, series.sensor)
+			if found, ok := latest[key]; !ok || found.before(ref) {
+				latest[key] = ref
+			}
+			break
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(latest))
+	for key := range latest {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	reader := s.newReader()
+	defer reader.close()
+
+	messages := make([]WeatherMessage, 0, len(keys))
+	for _, key := range keys {
+		message, err := reader.read(latest[key])
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+// DeleteMessages deletes the saved observations selected by the query.
+// The observations are hidden at once and removed from disk by the
+// next Compact.
+func (s *ObservationStore) DeleteMessages(ctx context.Context, query MessageQuery) error {
+	if !storesTypes(query.Types) {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active == nil {
+		return errStoreClosed
+	}
+
+	segment := s.segments[len(s.segments)-1]
+	tombstone := storeTombstone{Query: query, Segment: segment.id, Offset: segment.size}
+	payload, err := json.Marshal(tombstone)
+	if err != nil {
+		return fmt.Errorf("error encoding tombstone: %w", err)
+	}
+
+	if _, err := s.deletes.Write(appendFrame(nil, payload)); err != nil {
+		return fmt.Errorf("error saving tombstone: %w", err)
+	}
+
+	if s.config.Sync {
+		if err := s.deletes.Sync(); err != nil {
+			return fmt.Errorf("error saving tombstone: %w", err)
+		}
+	}
+
+	s.tombstones = append(s.tombstones, tombstone)
+
+	return nil
+}
+
+// Compact seals the segment being written and rewrites the sealed
+// segments without deleted observations, compressing each sensor's
+// observations into blocks packed into as few segments as the segment
+// size allows. Saves wait while the store is compacted.
+//
+// The new segments are written beside the old ones and replace them
+// once they are complete, so a crash during compaction leaves either
+// the old or the new segments.
+func (s *ObservationStore) Compact(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active == nil {
+		return errStoreClosed
+	}
+
+	if s.segments[len(s.segments)-1].records > 0 {
+		if err := s.seal(); err != nil {
+			return err
+		}
+	}
+
+	sealed := s.segments[:len(s.segments)-1]
+	ids := make([]int, 0, len(sealed))
+	for _, segment := range sealed {
+		ids = append(ids, segment.id)
+	}
+
+	compacted, err := s.compact(ctx, sealed, ids)
+	if err != nil {
+		for _, id := range ids {
+			os.Remove(s.path(id, storeSegmentExt+storeTempExt))
+			os.Remove(s.path(id, storeIndexExt+storeTempExt))
+		}
+		return err
+	}
+
+	marker, err := json.Marshal(storeCompactionMarker{Segments: ids, Compacted: len(compacted)})
+	if err != nil {
+		return fmt.Errorf("error encoding compaction: %w", err)
+	}
+
+	if err := writeSynced(filepath.Join(s.config.Dir, storeCompaction), marker); err != nil {
+		return err
+	}
+
+	if err := s.deletes.Close(); err != nil {
+		return fmt.Errorf("error closing tombstones: %w", err)
+	}
+
+	if err := s.finishCompaction(marker); err != nil {
+		return err
+	}
+
+	s.segments = append(compacted, s.segments[len(s.segments)-1])
+
+	return s.openTombstones()
+}
+
+// compact writes the live records of the sealed segments to temporary
+// segments with the first of the IDs and returns their summaries.
+func (s *ObservationStore) compact(ctx context.Context, sealed []*storeSegment, ids []int) ([]*storeSegment, error) {
+	compacted := make([]*storeSegment, 0)
+	var output []byte
+	var index *segmentIndex
+
+	// finish writes the segment being compacted and its index.
+	finish := func() error {
+		if index == nil {
+			return nil
+		}
+
+		id := ids[len(compacted)]
+		if err := writeSynced(s.path(id, storeSegmentExt+storeTempExt), output); err != nil {
+			return err
+		}
+
+		if err := writeSynced(s.path(id, storeIndexExt+storeTempExt), index.encode()); err != nil {
+			return err
+		}
+
+		segment := &storeSegment{id: id, size: int64(len(output)), blocks: true}
+		segment.summarise(index)
+		compacted = append(compacted, segment)
+		output, index = nil, nil
+
+		return nil
+	}
+
+	// write writes a block of the series' observations.
+	write := func(series storeSeries, observations []WeatherObservation) error {
+		sort.SliceStable(observations, func(i, j int) bool {
+			return observations[i].EpochSecondsUTC.Before(observations[j].EpochSecondsUTC)
+		})
+
+		payload := appendString(appendString(nil, series.hub), series.sensor)
+		frame := appendFrame(nil, append(payload, EncodeObservations(observations)...))
+
+		// Start the next segment when this one is full, unless every
+		// ID is in use.
+		if index != nil && int64(len(output)+len(frame)) > s.config.SegmentSize && len(compacted) < len(ids)-1 {
+			if err := finish(); err != nil {
+				return err
+			}
+		}
+
+		if index == nil {
+			output = append(make([]byte, 0, s.config.SegmentSize), storeBlockMagic...)
+			index = newSegmentIndex()
+		}
+
+		for i, ob := range observations {
+			index.add(series, indexEntry{time: ob.EpochSecondsUTC.UnixNano(), offset: int64(len(output)), item: i})
+		}
+		output = append(output, frame...)
+
+		return nil
+	}
+
+	// Observations of each series waiting for a full block, and the
+	// order the series were found in.
+	pending := make(map[storeSeries][]WeatherObservation)
+	order := make([]storeSeries, 0)
+
+	var writeErr error
+	for _, segment := range sealed {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		data, err := os.ReadFile(s.path(segment.id, storeSegmentExt))
+		if err != nil {
+			return nil, fmt.Errorf("error reading segment %d: %w", segment.id, err)
+		}
+
+		err = scanSegment(data, func(entry indexEntry, frame []byte, record storeRecord) {
+			if writeErr != nil || s.deleted(record.series, entry, segment.id) {
+				return
+			}
+
+			observations, found := pending[record.series]
+			if !found {
+				order = append(order, record.series)
+			}
+
+			observations = append(observations, record.observation)
+			if len(observations) < storeBlockSize {
+				pending[record.series] = observations
+				return
+			}
+
+			pending[record.series] = make([]WeatherObservation, 0)
+			writeErr = write(record.series, observations)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error reading segment %d: %w", segment.id, err)
+		}
+
+		if writeErr != nil {
+			return nil, writeErr
+		}
+	}
+
+	for _, series := range order {
+		if len(pending[series]) == 0 {
+			continue
+		}
+
+		if err := write(series, pending[series]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := finish(); err != nil {
+		return nil, err
+	}
+
+	return compacted, nil
+}
+
+// finishCompaction replaces the compacted segments with the new
+// segments and removes the tombstones applied by the compaction
+// recorded in the marker. Finishing a compaction again is harmless.
+func (s *ObservationStore) finishCompaction(data []byte) error {
+	var marker storeCompactionMarker
+	if err := json.Unmarshal(data, &marker); err != nil {
+		return fmt.Errorf("error decoding compaction: %w", err)
+	}
+
+	for i, id := range marker.Segments {
+		for _, ext := range []string{storeSegmentExt, storeIndexExt} {
+			var err error
+			if i < marker.Compacted {
+				err = os.Rename(s.path(id, ext+storeTempExt), s.path(id, ext))
+			} else {
+				err = os.Remove(s.path(id, ext))
+			}
+
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("error replacing segment %d: %w", id, err)
+			}
+		}
+	}
+
+	if err := os.Remove(filepath.Join(s.config.Dir, storeTombstones)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing tombstones: %w", err)
+	}
+	syncDir(s.config.Dir)
+
+	if err := os.Remove(filepath.Join(s.config.Dir, storeCompaction)); err != nil {
+		return fmt.Errorf("error removing compaction: %w", err)
+	}
+	syncDir(s.config.Dir)
+
+	return nil
+}
+
+// recoverCompaction finishes a compaction interrupted after its new
+// segments were written, or removes the new segments of a compaction
+// interrupted before.
+func (s *ObservationStore) recoverCompaction() error {
+	marker, err := os.ReadFile(filepath.Join(s.config.Dir, storeCompaction))
+	if err == nil {
+		if err := s.finishCompaction(marker); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error reading compaction: %w", err)
+	}
+
+	temps, err := filepath.Glob(filepath.Join(s.config.Dir, "*"+storeTempExt+"*"))
+	if err != nil {
+		return err
+	}
+
+	for _, temp := range temps {
+		if err := os.Remove(temp); err != nil {
+			return fmt.Errorf("error removing %s: %w", filepath.Base(temp), err)
+		}
+	}
+
+	return nil
+}
+
+// seal writes the index of the segment being written and starts the
+// next segment.
+func (s *ObservationStore) seal() error {
+	segment := s.segments[len(s.segments)-1]
+	if err := s.active.Sync(); err != nil {
+		return fmt.Errorf("error sealing segment %d: %w", segment.id, err)
+	}
+
+	if err := writeSynced(s.path(segment.id, storeIndexExt), s.index.encode()); err != nil {
+		return err
+	}
+
+	if err := s.active.Close(); err != nil {
+		return fmt.Errorf("error sealing segment %d: %w", segment.id, err)
+	}
+	s.active = nil
+
+	if err := s.createSegment(segment.id + 1); err != nil {
+		return err
+	}
+
+	return s.openActive(segment.id + 1)
+}
+
+// createSegment creates an empty segment.
+func (s *ObservationStore) createSegment(id int) error {
+	if err := writeSynced(s.path(id, storeSegmentExt), storeSegmentMagic); err != nil {
+		return fmt.Errorf("error creating segment %d: %w", id, err)
+	}
+
+	return nil
+}
+
+// openSealed returns the summary of a sealed segment, rebuilding its
+// index if it is missing or damaged.
+func (s *ObservationStore) openSealed(id int) (*storeSegment, error) {
+	info, err := os.Stat(s.path(id, storeSegmentExt))
+	if err != nil {
+		return nil, fmt.Errorf("error opening segment %d: %w", id, err)
+	}
+
+	index, err := s.readIndex(id)
+	if err != nil {
+		data, err := os.ReadFile(s.path(id, storeSegmentExt))
+		if err != nil {
+			return nil, fmt.Errorf("error reading segment %d: %w", id, err)
+		}
+
+		// Records after damage to a sealed segment cannot be found,
+		// so only the records before it are indexed.
+		index = newSegmentIndex()
+		err = scanSegment(data, func(entry indexEntry, frame []byte, record storeRecord) {
+			index.add(record.series, entry)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error reading segment %d: %w", id, err)
+		}
+
+		if err := writeSynced(s.path(id, storeIndexExt), index.encode()); err != nil {
+			return nil, err
+		}
+	}
+
+	header := make([]byte, len(storeBlockMagic))
+	if err := readHeader(s.path(id, storeSegmentExt), header); err != nil {
+		return nil, fmt.Errorf("error reading segment %d: %w", id, err)
+	}
+
+	segment := &storeSegment{id: id, size: info.Size(), blocks: bytes.Equal(header, storeBlockMagic)}
+	segment.summarise(index)
+
+	return segment, nil
+}
+
+// openActive opens the last segment for appending, truncating it
+// after its last whole record.
+func (s *ObservationStore) openActive(id int) error {
+	// An index is left by a crash after the segment was sealed but
+	// before the next was started. The segment is indexed in memory
+	// while it is written and sealed again when it is full.
+	if err := os.Remove(s.path(id, storeIndexExt)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error opening segment %d: %w", id, err)
+	}
+
+	data, err := os.ReadFile(s.path(id, storeSegmentExt))
+	if err != nil {
+		return fmt.Errorf("error reading segment %d: %w", id, err)
+	}
+
+	// A segment shorter than its header was torn while being created.
+	if len(data) < len(storeSegmentMagic) && bytes.HasPrefix(storeSegmentMagic, data) {
+		if err := s.createSegment(id); err != nil {
+			return err
+		}
+		data = storeSegmentMagic
+	}
+
+	// Compacted segments are never written to, so start the next.
+	if bytes.HasPrefix(data, storeBlockMagic) {
+		segment, err := s.openSealed(id)
+		if err != nil {
+			return err
+		}
+		s.segments = append(s.segments, segment)
+
+		if err := s.createSegment(id + 1); err != nil {
+			return err
+		}
+
+		return s.openActive(id + 1)
+	}
+
+	index := newSegmentIndex()
+	size := int64(len(storeSegmentMagic))
+	err = scanSegment(data, func(entry indexEntry, frame []byte, record storeRecord) {
+		index.add(record.series, entry)
+		size = entry.offset + int64(len(frame))
+	})
+	if err != nil {
+		return fmt.Errorf("error reading segment %d: %w", id, err)
+	}
+
+	active, err := os.OpenFile(s.path(id, storeSegmentExt), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return fmt.Errorf("error opening segment %d: %w", id, err)
+	}
+
+	// Remove a record torn by a crash.
+	if size < int64(len(data)) {
+		if err := active.Truncate(size); err != nil {
+			active.Close()
+			return fmt.Errorf("error truncating segment %d: %w", id, err)
+		}
+	}
+
+	segment := &storeSegment{id: id, size: size}
+	segment.summarise(index)
+	s.segments = append(s.segments, segment)
+	s.index = index
+	s.active = active
+
+	return nil
+}
+
+// openTombstones reads the tombstones and opens them for appending,
+// removing a tombstone torn by a crash.
+func (s *ObservationStore) openTombstones() error {
+	path := filepath.Join(s.config.Dir, storeTombstones)
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error reading tombstones: %w", err)
+	}
+
+	tombstones := make([]storeTombstone, 0)
+	size := 0
+	for size < len(data) {
+		payload, n, ok := nextFrame(data[size:])
+		if !ok {
+			break
+		}
+
+		var tombstone storeTombstone
+		if err := json.Unmarshal(payload, &tombstone); err != nil {
+			return fmt.Errorf("error decoding tombstone: %w", err)
+		}
+
+		tombstones = append(tombstones, tombstone)
+		size += n
+	}
+
+	deletes, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening tombstones: %w", err)
+	}
+
+	if size < len(data) {
+		if err := deletes.Truncate(int64(size)); err != nil {
+			deletes.Close()
+			return fmt.Errorf("error truncating tombstones: %w", err)
+		}
+	}
+
+	s.tombstones = tombstones
+	s.deletes = deletes
+
+	return nil
+}
+
+// segmentIDs returns the IDs of the segments in the directory in
+// order.
+func (s *ObservationStore) segmentIDs() ([]int, error) {
+	paths, err := filepath.Glob(filepath.Join(s.config.Dir, "*"+storeSegmentExt))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(paths))
+	for _, path := range paths {
+		id, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(path), storeSegmentExt))
+		if err != nil || id <= 0 {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	return ids, nil
+}
+
+// path returns the path of the segment file with the extension.
+func (s *ObservationStore) path(id int, ext string) string {
+	return filepath.Join(s.config.Dir, fmt.Sprintf("%08d%s", id, ext))
+}
+
+// readIndex reads the index of a sealed segment.
+func (s *ObservationStore) readIndex(id int) (*segmentIndex, error) {
+	data, err := os.ReadFile(s.path(id, storeIndexExt))
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := decodeSegmentIndex(data)
+	if err != nil {
+		return nil, fmt.Errorf("error reading index of segment %d: %w", id, err)
+	}
+
+	return index, nil
+}
+
+// segmentIndex returns the index of the segment at the position.
+func (s *ObservationStore) segmentIndex(position int) (*segmentIndex, error) {
+	if position == len(s.segments)-1 {
+		return s.index, nil
+	}
+
+	return s.readIndex(s.segments[position].id)
+}
+
+// selectSeries calls fn with the index entries of each series in the
+// segments that may hold observations selected by the query.
+func (s *ObservationStore) selectSeries(query MessageQuery, fn func(position int, series storeSeries, entries []indexEntry)) error {
+	if !storesTypes(query.Types) {
+		return nil
+	}
+
+	start, end := queryRange(query)
+	for position, segment := range s.segments {
+		if segment.records == 0 || segment.last < start || segment.first >= end || !segment.selects(query) {
+			continue
+		}
+
+		index, err := s.segmentIndex(position)
+		if err != nil {
+			return err
+		}
+
+		for i, series := range index.series {
+			if series.selectedBy(query) {
+				fn(position, series, index.entries[i])
+			}
+		}
+	}
+
+	return nil
+}
+
+// collect returns the records selected by the query that are not
+// deleted.
+func (s *ObservationStore) collect(query MessageQuery) ([]storeRef, error) {
+	start, end := queryRange(query)
+	refs := make([]storeRef, 0)
+	err := s.selectSeries(query, func(position int, series storeSeries, entries []indexEntry) {
+		segmentID := s.segments[position].id
+		first := sort.Search(len(entries), func(i int) bool {
+			return entries[i].time >= start
+		})
+
+		for _, entry := range entries[first:] {
+			if entry.time >= end {
+				break
+			}
+
+			if !s.deleted(series, entry, segmentID) {
+				refs = append(refs, storeRef{time: entry.time, segment: position, offset: entry.offset, item: entry.item})
+			}
+		}
+	})
+
+	return refs, err
+}
+
+// deleted returns true if a tombstone deletes the record.
+func (s *ObservationStore) deleted(series storeSeries, entry indexEntry, segmentID int) bool {
+	for _, tombstone := range s.tombstones {
+		if segmentID > tombstone.Segment || segmentID == tombstone.Segment && entry.offset >= tombstone.Offset {
+			continue
+		}
+
+		if tombstone.Query.match(MessageTypeObservation, time.Unix(0, entry.time), series.hub, series.sensor) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newReader returns a reader of the store's records.
+func (s *ObservationStore) newReader() *storeReader {
+	return &storeReader{
+		store:  s,
+		files:  make(map[int]*os.File),
+		blocks: make(map[storeRef]storeBlock),
+	}
+}
+
+// read returns the message of the record.
+func (r *storeReader) read(ref storeRef) (WeatherMessage, error) {
+	segment := r.store.segments[ref.segment]
+
+	var record storeRecord
+	var err error
+	if segment.blocks {
+		record, err = r.blockRecord(ref)
+	} else {
+		var payload []byte
+		if payload, err = r.frame(segment, ref.offset); err == nil {
+			record, err = decodeRecord(payload)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading record at %d of segment %d: %w", ref.offset, segment.id, err)
+	}
+
+	return &Observation{
+		SensorSerial: record.series.sensor,
+		HubSerial:    record.series.hub,
+		Observations: []WeatherObservation{record.observation},
+	}, nil
+}
+
+// blockRecord returns the record in a block of a compacted segment,
+// decoding the block unless it is already decoded.
+func (r *storeReader) blockRecord(ref storeRef) (storeRecord, error) {
+	key := storeRef{segment: ref.segment, offset: ref.offset}
+	block, found := r.blocks[key]
+	if !found {
+		payload, err := r.frame(r.store.segments[ref.segment], ref.offset)
+		if err != nil {
+			return storeRecord{}, err
+		}
+
+		if block, err = decodeBlock(payload); err != nil {
+			return storeRecord{}, err
+		}
+
+		if len(r.blocks) == storeReaderBlocks {
+			clear(r.blocks)
+		}
+		r.blocks[key] = block
+	}
+
+	if ref.item >= len(block.observations) {
+		return storeRecord{}, errors.New("record not in block")
+	}
+
+	return storeRecord{series: block.series, observation: block.observations[ref.item]}, nil
+}
+
+// frame returns the payload of the frame at the offset of the segment.
+func (r *storeReader) frame(segment *storeSegment, offset int64) ([]byte, error) {
+	file, ok := r.files[segment.id]
+	if !ok {
+		var err error
+		if file, err = os.Open(r.store.path(segment.id, storeSegmentExt)); err != nil {
+			return nil, err
+		}
+		r.files[segment.id] = file
+	}
+
+	header := make([]byte, storeFrameHeader)
+	if _, err := file.ReadAt(header, offset); err != nil {
+		return nil, err
+	}
+
+	size := int64(binary.LittleEndian.Uint32(header))
+	if offset+storeFrameHeader+size > segment.size {
+		return nil, errors.New("record is damaged")
+	}
+
+	frame := make([]byte, storeFrameHeader+size)
+	if _, err := file.ReadAt(frame, offset); err != nil {
+		return nil, err
+	}
+
+	payload, _, ok := nextFrame(frame)
+	if !ok {
+		return nil, errors.New("record is damaged")
+	}
+
+	return payload, nil
+}
+
+// close closes the segments opened for reading.
+func (r *storeReader) close() {
+	for _, file := range r.files {
+		file.Close()
+	}
+}
+
+// before returns true if the record is ordered before the other:
+// earlier, or saved earlier at the same time.
+func (r storeRef) before(other storeRef) bool {
+	if r.time != other.time {
+		return r.time < other.time
+	}
+
+	if r.segment != other.segment {
+		return r.segment < other.segment
+	}
+
+	if r.offset != other.offset {
+		return r.offset < other.offset
+	}
+
+	return r.item < other.item
+}
+
+// summarise records the time range and series of the segment's index.
+func (s *storeSegment) summarise(index *segmentIndex) {
+	s.records = 0
+	s.series = index.series
+	for _, entries := range index.entries {
+		if len(entries) == 0 {
+			continue
+		}
+
+		if s.records == 0 || entries[0].time < s.first {
+			s.first = entries[0].time
+		}
+
+		if s.records == 0 || entries[len(entries)-1].time > s.last {
+			s.last = entries[len(entries)-1].time
+		}
+
+		s.records += len(entries)
+	}
+}
+
+// selects returns true if the segment holds a series selected by the
+// query.
+func (s *storeSegment) selects(query MessageQuery) bool {
+	for _, series := range s.series {
+		if series.selectedBy(query) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selectedBy returns true if the query selects the series.
+func (s storeSeries) selectedBy(query MessageQuery) bool {
+	return (query.HubSerial == "" || query.HubSerial == s.hub) && (query.SensorSerial == "" || query.SensorSerial == s.sensor)
+}
+
+// newSegmentIndex returns an empty index.
+func newSegmentIndex() *segmentIndex {
+	return &segmentIndex{series: make([]storeSeries, 0), entries: make([][]indexEntry, 0)}
+}
+
+// add indexes a record of the series.
+func (x *segmentIndex) add(series storeSeries, entry indexEntry) {
+	i := 0
+	for i < len(x.series) && x.series[i] != series {
+		i++
+	}
+
+	if i == len(x.series) {
+		x.series = append(x.series, series)
+		x.entries = append(x.entries, make([]indexEntry, 0))
+	}
+
+	// Observations normally arrive in order, so append unless the
+	// record is older than the newest indexed.
+	entries := x.entries[i]
+	j := len(entries)
+	if j > 0 && entry.time < entries[j-1].time {
+		j = sort.Search(len(entries), func(k int) bool {
+			return entries[k].time > entry.time
+		})
+	}
+
+	entries = append(entries, indexEntry{})
+	copy(entries[j+1:], entries[j:])
+	entries[j] = entry
+	x.entries[i] = entries
+}
+
+// encode returns the index file of the index: a header, each series
+// with its entries and a checksum of the series.
+func (x *segmentIndex) encode() []byte {
+	data := append([]byte(nil), storeIndexMagic...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(x.series)))
+	for i, series := range x.series {
+		data = appendString(data, series.hub)
+		data = appendString(data, series.sensor)
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(x.entries[i])))
+		for _, entry := range x.entries[i] {
+			data = binary.LittleEndian.AppendUint64(data, uint64(entry.time))
+			data = binary.LittleEndian.AppendUint64(data, uint64(entry.offset))
+			data = binary.LittleEndian.AppendUint32(data, uint32(entry.item))
+		}
+	}
+
+	return binary.LittleEndian.AppendUint32(data, crc32.Checksum(data[len(storeIndexMagic):], storeChecksums))
+}
+
+// decodeSegmentIndex decodes an index file.
+func decodeSegmentIndex(data []byte) (*segmentIndex, error) {
+	if len(data) < len(storeIndexMagic)+4 || !bytes.HasPrefix(data, storeIndexMagic) {
+		return nil, errors.New("not an index")
+	}
+
+	body := data[len(storeIndexMagic) : len(data)-4]
+	if crc32.Checksum(body, storeChecksums) != binary.LittleEndian.Uint32(data[len(data)-4:]) {
+		return nil, errors.New("checksum mismatch")
+	}
+
+	r := binaryReader{data: body}
+	index := newSegmentIndex()
+	for count := r.uint32(); count > 0 && r.err == nil; count-- {
+		series := storeSeries{hub: r.string(), sensor: r.string()}
+		entries := make([]indexEntry, 0)
+		for n := r.uint32(); n > 0 && r.err == nil; n-- {
+			entries = append(entries, indexEntry{time: int64(r.uint64()), offset: int64(r.uint64()), item: int(r.uint32())})
+		}
+
+		index.series = append(index.series, series)
+		index.entries = append(index.entries, entries)
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return index, nil
+}
+
+// storeRecord is an observation saved to the store.
+type storeRecord struct {
+	series      storeSeries
+	observation WeatherObservation
+}
+
+// appendRecord appends the encoded observation: its time in Unix
+// nanoseconds, the hub and sensor serial numbers, the missing fields
+// and the value of each field.
+func appendRecord(data []byte, hub string, sensor string, ob WeatherObservation) []byte {
+	data = binary.LittleEndian.AppendUint64(data, uint64(ob.EpochSecondsUTC.UnixNano()))
+	data = appendString(data, hub)
+	data = appendString(data, sensor)
+	data = binary.LittleEndian.AppendUint32(data, uint32(ob.Missing))
+	for _, value := range observationValues(ob) {
+		data = binary.LittleEndian.AppendUint64(data, math.Float64bits(value))
+	}
+
+	return data
+}
+
+// decodeRecord decodes a record encoded by appendRecord.
+func decodeRecord(data []byte) (storeRecord, error) {
+	r := binaryReader{data: data}
+	nanos := int64(r.uint64())
+	series := storeSeries{hub: r.string(), sensor: r.string()}
+	missing := ObservationField(r.uint32())
+
+	var values [storeFields]float64
+	for i := range values {
+		values[i] = math.Float64frombits(r.uint64())
+	}
+
+	if r.err != nil {
+		return storeRecord{}, r.err
+	}
+
+	return storeRecord{series: series, observation: observationFromValues(time.Unix(0, nanos), values, missing)}, nil
+}
+
+// observationValues returns the value of each field of the
+// observation, in the order of observationFieldNames and in the units
+// sensors report them in.
+func observationValues(ob WeatherObservation) [storeFields]float64 {
+	return [storeFields]float64{
+		ob.WindLull.MetersPerSecond(),
+		ob.WindAverage.MetersPerSecond(),
+		ob.WindGust.MetersPerSecond(),
+		ob.WindDirection.Degrees(),
+		float64(ob.WindSampleInterval),
+		ob.StationPressure.Millibar(),
+		ob.AirTemperature.C(),
+		ob.RelativeHumidity,
+		float64(ob.Illuminance),
+		ob.UV,
+		float64(ob.SolarRadiation),
+		ob.RainAccumulation,
+		float64(ob.PrecipitationType),
+		ob.LightningStrikeAvg.Kilometers(),
+		float64(ob.LightningStrikeCnt),
+		ob.BatteryVolts,
+		float64(ob.ReportingInterval),
+	}
+}
+
+// observationFromValues returns the observation with the time, field
+// values returned by observationValues and missing fields.
+func observationFromValues(t time.Time, values [storeFields]float64, missing ObservationField) WeatherObservation {
+	return WeatherObservation{
+		EpochSecondsUTC:    t,
+		WindLull:           NewSpeed(values[0], MetersPerSecond),
+		WindAverage:        NewSpeed(values[1], MetersPerSecond),
+		WindGust:           NewSpeed(values[2], MetersPerSecond),
+		WindDirection:      NewDirection(values[3], Degrees),
+		WindSampleInterval: int(values[4]),
+		StationPressure:    NewPressure(values[5], Millibar),
+		AirTemperature:     NewTemp(values[6], Celsius),
+		RelativeHumidity:   values[7],
+		Illuminance:        int(values[8]),
+		UV:                 values[9],
+		SolarRadiation:     int(values[10]),
+		RainAccumulation:   values[11],
+		PrecipitationType:  int(values[12]),
+		LightningStrikeAvg: NewDistance(values[13], Kilometers),
+		LightningStrikeCnt: int(values[14]),
+		BatteryVolts:       values[15],
+		ReportingInterval:  int(values[16]),
+		Missing:            missing,
+	}
+}
+
+// scanSegment calls fn with the index entry, frame and record of each
+// whole record in the segment, stopping at the first torn or damaged
+// frame. The records of a block share its frame.
+func scanSegment(data []byte, fn func(entry indexEntry, frame []byte, record storeRecord)) error {
+	blocks := bytes.HasPrefix(data, storeBlockMagic)
+	if !blocks && !bytes.HasPrefix(data, storeSegmentMagic) {
+		return errors.New("not a segment")
+	}
+
+	offset := len(storeSegmentMagic)
+	for offset < len(data) {
+		payload, n, ok := nextFrame(data[offset:])
+		if !ok {
+			return nil
+		}
+		frame := data[offset : offset+n]
+
+		if !blocks {
+			record, err := decodeRecord(payload)
+			if err != nil {
+				return nil
+			}
+
+			fn(indexEntry{time: record.observation.EpochSecondsUTC.UnixNano(), offset: int64(offset)}, frame, record)
+			offset += n
+			continue
+		}
+
+		block, err := decodeBlock(payload)
+		if err != nil {
+			return nil
+		}
+
+		for i, ob := range block.observations {
+			fn(indexEntry{time: ob.EpochSecondsUTC.UnixNano(), offset: int64(offset), item: i}, frame, storeRecord{series: block.series, observation: ob})
+		}
+		offset += n
+	}
+
+	return nil
+}
+
+// decodeBlock decodes a block of a compacted segment: the hub and
+// sensor serial numbers and their observations encoded by
+// EncodeObservations.
+func decodeBlock(data []byte) (storeBlock, error) {
+	r := binaryReader{data: data}
+	series := storeSeries{hub: r.string(), sensor: r.string()}
+	if r.err != nil {
+		return storeBlock{}, r.err
+	}
+
+	observations, err := DecodeObservations(r.data)
+	if err != nil {
+		return storeBlock{}, err
+	}
+
+	return storeBlock{series: series, observations: observations}, nil
+}
+
+// readHeader reads the start of the file into header.
+func readHeader(path string, header []byte) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.ReadFull(file, header)
+
+	return err
+}
+
+// appendFrame appends the payload framed by its length and CRC-32C
+// checksum.
+func appendFrame(data []byte, payload []byte) []byte {
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(payload)))
+	data = binary.LittleEndian.AppendUint32(data, crc32.Checksum(payload, storeChecksums))
+
+	return append(data, payload...)
+}
+
+// nextFrame returns the payload of the frame at the start of the data
+// and the length of the frame, or false if the frame is torn or
+// damaged.
+func nextFrame(data []byte) (payload []byte, n int, ok bool) {
+	if len(data) < storeFrameHeader {
+		return nil, 0, false
+	}
+
+	size := binary.LittleEndian.Uint32(data)
+	if uint64(size) > uint64(len(data)-storeFrameHeader) {
+		return nil, 0, false
+	}
+
+	payload = data[storeFrameHeader : storeFrameHeader+int(size)]
+	if crc32.Checksum(payload, storeChecksums) != binary.LittleEndian.Uint32(data[4:]) {
+		return nil, 0, false
+	}
+
+	return payload, storeFrameHeader + int(size), true
+}
+
+// appendString appends the string prefixed by its length.
+func appendString(data []byte, s string) []byte {
+	return append(append(data, byte(len(s))), s...)
+}
+
+// binaryReader reads the little-endian values of the store's files,
+// keeping the first error.
+type binaryReader struct {
+	data []byte
+	err  error
+}
+
+// next returns the next n bytes.
+func (r *binaryReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if len(r.data) < n {
+		r.err = errors.New("unexpected end of data")
+		return nil
+	}
+
+	b := r.data[:n]
+	r.data = r.data[n:]
+
+	return b
+}
+
+// uint8 returns the next byte.
+func (r *binaryReader) uint8() uint8 {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+// uint32 returns the next 32-bit integer.
+func (r *binaryReader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+// uint64 returns the next 64-bit integer.
+func (r *binaryReader) uint64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+// uvarint returns the next variable-length integer.
+func (r *binaryReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+
+	value, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = errors.New("invalid variable-length integer")
+		return 0
+	}
+	r.data = r.data[n:]
+
+	return value
+}
+
+// column returns the next bytes prefixed by their length.
+func (r *binaryReader) column() []byte {
+	length := r.uvarint()
+	if r.err == nil && length > uint64(len(r.data)) {
+		r.err = errors.New("unexpected end of data")
+		return nil
+	}
+	return r.next(int(length))
+}
+
+// string returns the next string prefixed by its length.
+func (r *binaryReader) string() string {
+	length := r.next(1)
+	if length == nil {
+		return ""
+	}
+	return string(r.next(int(length[0])))
+}
+
+// queryRange returns the query's time range in Unix nanoseconds, from
+// the start to before the end.
+func queryRange(query MessageQuery) (start int64, end int64) {
+	start, end = math.MinInt64, math.MaxInt64
+	if !query.Start.IsZero() {
+		start = query.Start.UnixNano()
+	}
+
+	if !query.End.IsZero() {
+		end = query.End.UnixNano()
+	}
+
+	return start, end
+}
+
+// storesTypes returns true if the types select observations.
+func storesTypes(types []Type) bool {
+	if len(types) == 0 {
+		return true
+	}
+
+	for _, t := range types {
+		if t == MessageTypeObservation {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeSynced replaces the file with the data and syncs it to disk.
+// The data is written to a temporary file renamed over the file, so a
+// crash leaves either the old or the new file.
+func writeSynced(path string, data []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+storeTempExt+"*")
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", filepath.Base(path), err)
+	}
+	defer os.Remove(temp.Name())
+
+	_, err = temp.Write(data)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", filepath.Base(path), err)
+	}
+
+	syncDir(filepath.Dir(path))
+
+	return nil
+}
+
+// syncDir syncs the directory so renames and removals in it survive a
+// crash. Not every platform can sync a directory, so errors are
+// ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
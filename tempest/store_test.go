@@ -0,0 +1,305 @@
+package tempest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testObservation returns an observation from the sensor at the second.
+func testObservation(sensor string, second int64) *Observation {
+	return &Observation{
+		SensorSerial: sensor,
+		HubSerial:    "HB-00013030",
+		Observations: []WeatherObservation{{
+			EpochSecondsUTC:    time.Unix(second, 0),
+			WindLull:           NewSpeed(0.4, MetersPerSecond),
+			WindAverage:        NewSpeed(float64(second%10), MetersPerSecond),
+			WindGust:           NewSpeed(3.1, MetersPerSecond),
+			WindDirection:      NewDirection(187.0, Degrees),
+			WindSampleInterval: 3,
+			StationPressure:    NewPressure(1017.6, Millibar),
+			AirTemperature:     NewTemp(22.4, Celsius),
+			RelativeHumidity:   50.2,
+			Illuminance:        328,
+			SolarRadiation:     3,
+			RainAccumulation:   0.12,
+			PrecipitationType:  1,
+			LightningStrikeAvg: NewDistance(14, Kilometers),
+			LightningStrikeCnt: 2,
+			BatteryVolts:       2.41,
+			ReportingInterval:  1,
+			Missing:            FieldUV,
+		}},
+	}
+}
+
+// openStore opens a store in the directory sealing segments after a
+// few records.
+func openStore(t *testing.T, dir string) *ObservationStore {
+	t.Helper()
+
+	store, err := OpenObservationStore(StoreConfig{Dir: dir, SegmentSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+// saveObservations saves an observation from each sensor every second
+// from start to before end.
+func saveObservations(t *testing.T, store *ObservationStore, start int64, end int64, sensors ...string) {
+	t.Helper()
+
+	for second := start; second < end; second++ {
+		for _, sensor := range sensors {
+			if err := store.SaveMessage(context.Background(), testObservation(sensor, second)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// checkSeconds checks the messages are from the seconds in order.
+func checkSeconds(t *testing.T, messages []WeatherMessage, seconds ...int64) {
+	t.Helper()
+
+	if len(messages) != len(seconds) {
+		t.Fatalf("expected %d messages, got %d", len(seconds), len(messages))
+	}
+
+	for i, message := range messages {
+		if !message.Time().Equal(time.Unix(seconds[i], 0)) {
+			t.Errorf("expected message %d at %d, got %v", i, seconds[i], message.Time())
+		}
+	}
+}
+
+func TestObservationStore_Query(t *testing.T) {
+	ctx := context.Background()
+	store := openStore(t, t.TempDir())
+
+	saveObservations(t, store, 100, 120, "ST-00000512", "ST-00000513")
+
+	// Saved out of order.
+	saveObservations(t, store, 90, 91, "ST-00000512")
+
+	if len(store.segments) < 3 {
+		t.Fatalf("expected records in several segments, got %d", len(store.segments))
+	}
+
+	messages := loadMessages(t, store, MessageQuery{Start: time.Unix(105, 0), End: time.Unix(108, 0), SensorSerial: "ST-00000512"})
+	checkSeconds(t, messages, 105, 106, 107)
+
+	if !reflect.DeepEqual(messages[0], testObservation("ST-00000512", 105)) {
+		t.Errorf("unexpected observation: %+v", messages[0])
+	}
+
+	all := loadMessages(t, store, MessageQuery{})
+	if len(all) != 41 || !all[0].Time().Equal(time.Unix(90, 0)) {
+		t.Errorf("expected 41 messages from 90, got %d", len(all))
+	}
+
+	// Observations from both sensors at the same time keep the order
+	// they were saved in.
+	if all[1].(*Observation).SensorSerial != "ST-00000512" || all[2].(*Observation).SensorSerial != "ST-00000513" {
+		t.Errorf("expected the order of saves at the same time")
+	}
+
+	if other := loadMessages(t, store, MessageQuery{Types: []Type{MessageTypeRapidWind}}); len(other) != 0 {
+		t.Errorf("expected no rapid wind messages, got %d", len(other))
+	}
+
+	latest, err := store.LatestMessages(ctx, MessageQuery{End: time.Unix(110, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSeconds(t, latest, 109, 109)
+
+	if err := store.SaveMessage(ctx, testWind("ST-00000512", 100)); !errors.Is(err, ErrUnsupportedMessage) {
+		t.Errorf("expected ErrUnsupportedMessage, got %v", err)
+	}
+}
+
+func TestObservationStore_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	store := openStore(t, dir)
+	saveObservations(t, store, 100, 110, "ST-00000512")
+	store.Close()
+
+	// A save cut short by a crash is removed.
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+storeSegmentExt))
+	active, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active.Write(appendFrame(nil, appendRecord(nil, "HB-00013030", "ST-00000512", testObservation("ST-00000512", 110).Observations[0]))[:40])
+	active.Close()
+
+	// A damaged index is rebuilt.
+	if err := os.WriteFile(filepath.Join(dir, "00000001"+storeIndexExt), []byte("TMPIDX1\ngarbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store = openStore(t, dir)
+	checkSeconds(t, loadMessages(t, store, MessageQuery{}), 100, 101, 102, 103, 104, 105, 106, 107, 108, 109)
+
+	if _, err := store.readIndex(1); err != nil {
+		t.Errorf("expected the index to be rebuilt: %v", err)
+	}
+
+	// Saves continue after the last whole record.
+	saveObservations(t, store, 110, 111, "ST-00000512")
+	store.Close()
+
+	store = openStore(t, dir)
+	checkSeconds(t, loadMessages(t, store, MessageQuery{Start: time.Unix(108, 0)}), 108, 109, 110)
+}
+
+func TestObservationStore_DeleteAndCompact(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := openStore(t, dir)
+	saveObservations(t, store, 100, 120, "ST-00000512", "ST-00000513")
+
+	if err := store.DeleteMessages(ctx, MessageQuery{End: time.Unix(115, 0), SensorSerial: "ST-00000512"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Observations saved after the delete are kept.
+	saveObservations(t, store, 100, 101, "ST-00000512")
+
+	check := func(store *ObservationStore) {
+		t.Helper()
+		checkSeconds(t, loadMessages(t, store, MessageQuery{SensorSerial: "ST-00000512"}), 100, 115, 116, 117, 118, 119)
+
+		if other := loadMessages(t, store, MessageQuery{SensorSerial: "ST-00000513"}); len(other) != 20 {
+			t.Errorf("expected 20 messages from the other sensor, got %d", len(other))
+		}
+	}
+	check(store)
+
+	// Left by a compaction interrupted before it finished.
+	if err := os.WriteFile(filepath.Join(dir, "00000001"+storeSegmentExt+storeTempExt), []byte("partial"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store.Close()
+	store = openStore(t, dir)
+	check(store)
+
+	before := len(store.segments)
+	if err := store.Compact(ctx); err != nil {
+		t.Fatal(err)
+	}
+	check(store)
+
+	if len(store.segments) >= before || len(store.tombstones) != 0 {
+		t.Errorf("expected fewer segments and no tombstones, got %d segments and %d tombstones", len(store.segments), len(store.tombstones))
+	}
+
+	temps, _ := filepath.Glob(filepath.Join(dir, "*"+storeTempExt+"*"))
+	if len(temps) != 0 {
+		t.Errorf("expected no temporary files, got %v", temps)
+	}
+
+	store.Close()
+	store = openStore(t, dir)
+	check(store)
+}
+
+func TestObservationStore_Network(t *testing.T) {
+	store := openStore(t, t.TempDir())
+
+	sim := NewSimulator(SimulatorConfig{Hubs: 1, SensorsPerHub: 2, Start: time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC), Duration: 5 * time.Minute, Seed: 1})
+	n := NewNetwork("test", WithMessageRepo(store))
+	sub := n.SubscribeBuffered(4096, MessageTypeObservation)
+	defer sub.Unsubscribe()
+
+	if err := n.RunSources(context.Background(), sim); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only observations are saved.
+	saved := loadMessages(t, store, MessageQuery{})
+	if decoded := sub.Messages(); len(saved) != len(decoded) || len(saved) == 0 {
+		t.Errorf("expected %d saved observations, got %d", len(decoded), len(saved))
+	}
+}
+
+func TestObservationStore_CompactCompresses(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store, err := OpenObservationStore(StoreConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Two days of observations from two sensors every minute.
+	for second := int64(0); second < 2*24*60*60; second += 60 {
+		for _, sensor := range []string{"ST-00000512", "ST-00000513"} {
+			if err := store.SaveMessage(ctx, testObservation(sensor, 1622505600+second)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// size returns the size of the store's segments.
+	size := func() int64 {
+		var total int64
+		segments, _ := filepath.Glob(filepath.Join(dir, "*"+storeSegmentExt))
+		for _, segment := range segments {
+			info, err := os.Stat(segment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			total += info.Size()
+		}
+		return total
+	}
+
+	before := size()
+	if err := store.Compact(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if after := size(); after*10 > before {
+		t.Errorf("expected compaction to shrink %d bytes to a tenth, got %d", before, after)
+	}
+
+	if !store.segments[0].blocks {
+		t.Error("expected a compacted segment")
+	}
+
+	// Compacted observations are read from their blocks, and an index
+	// of a compacted segment is rebuilt from them.
+	store.Close()
+	if err := os.Remove(filepath.Join(dir, "00000001"+storeIndexExt)); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenObservationStore(StoreConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := MessageQuery{Start: time.Unix(1622505600+3600, 0), End: time.Unix(1622505600+3720, 0), SensorSerial: "ST-00000513"}
+	messages := loadMessages(t, store, query)
+	checkSeconds(t, messages, 1622505600+3600, 1622505600+3660)
+
+	if !reflect.DeepEqual(messages[1], testObservation("ST-00000513", 1622505600+3660)) {
+		t.Errorf("unexpected observation: %+v", messages[1])
+	}
+
+	if all := loadMessages(t, store, MessageQuery{}); len(all) != 2*2*24*60 {
+		t.Errorf("expected %d observations, got %d", 2*2*24*60, len(all))
+	}
+}
@@ -0,0 +1,147 @@
+package tempest
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultSubscriptionBuffer is the number of messages buffered for a
+// subscriber created with Subscribe.
+const DefaultSubscriptionBuffer = 64
+
+// A Subscription delivers decoded messages from a Network.
+//
+// Messages are buffered per subscriber. Delivery never blocks the
+// network: when a subscriber's buffer is full the newest message is
+// dropped for that subscriber and counted in Dropped. Blocking
+// subscriptions instead make the network wait for the subscriber.
+type Subscription struct {
+	messages  chan WeatherMessage
+	types     map[Type]struct{} // Empty for all message types.
+	blocking  bool              // Delivery waits for a full buffer.
+	cancelled chan struct{}     // Closed to stop a blocked delivery.
+	dropped   atomic.Uint64
+	broker    *broker
+	once      sync.Once
+}
+
+// Messages returns the channel messages are delivered on.
+// The channel is closed when the subscription is cancelled.
+func (s *Subscription) Messages() <-chan WeatherMessage {
+	return s.messages
+}
+
+// Dropped returns the number of messages dropped because the
+// subscriber's buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Unsubscribe stops delivery and closes the messages channel.
+// It is safe to call more than once.
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		// Release a delivery blocked on the subscriber, which holds the
+		// broker's lock.
+		close(s.cancelled)
+		s.broker.remove(s)
+	})
+}
+
+// wants returns true if the subscription receives the message type.
+func (s *Subscription) wants(t Type) bool {
+	if len(s.types) == 0 {
+		return true
+	}
+
+	_, ok := s.types[t]
+	return ok
+}
+
+// broker fans messages out to subscriptions.
+type broker struct {
+	mu            sync.RWMutex
+	subscriptions map[*Subscription]struct{}
+}
+
+// newBroker returns a broker without subscriptions.
+func newBroker() *broker {
+	return &broker{
+		subscriptions: make(map[*Subscription]struct{}),
+	}
+}
+
+// add creates a subscription for the message types, dropping messages
+// when its buffer is full.
+func (b *broker) add(buffer int, types ...Type) *Subscription {
+	return b.subscribe(buffer, false, types)
+}
+
+// addBlocking creates a subscription for the message types, waiting for
+// the subscriber when its buffer is full.
+func (b *broker) addBlocking(buffer int, types ...Type) *Subscription {
+	return b.subscribe(buffer, true, types)
+}
+
+// subscribe creates a subscription for the message types.
+func (b *broker) subscribe(buffer int, blocking bool, types []Type) *Subscription {
+	if buffer < 0 {
+		buffer = 0
+	}
+
+	sub := &Subscription{
+		messages:  make(chan WeatherMessage, buffer),
+		types:     make(map[Type]struct{}, len(types)),
+		blocking:  blocking,
+		cancelled: make(chan struct{}),
+		broker:    b,
+	}
+
+	for _, t := range types {
+		sub.types[t] = struct{}{}
+	}
+
+	b.mu.Lock()
+	b.subscriptions[sub] = struct{}{}
+	b.mu.Unlock()
+
+	return sub
+}
+
+// remove deletes the subscription and closes its channel.
+func (b *broker) remove(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, found := b.subscriptions[sub]; found {
+		delete(b.subscriptions, sub)
+		close(sub.messages)
+	}
+}
+
+// publish delivers the message to every interested subscription,
+// waiting only for blocking subscriptions.
+func (b *broker) publish(msg WeatherMessage) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subscriptions {
+		if !sub.wants(msg.Type()) {
+			continue
+		}
+
+		if sub.blocking {
+			select {
+			case sub.messages <- msg:
+			case <-sub.cancelled:
+			}
+			continue
+		}
+
+		select {
+		case sub.messages <- msg:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package tempest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBroker_PublishFiltersTypes(t *testing.T) {
+	b := newBroker()
+	wind := b.add(1, MessageTypeRapidWind)
+	all := b.add(2)
+
+	b.publish(&RapidWindEvent{EventTime: time.Unix(1, 0)})
+	b.publish(&Observation{})
+
+	if got := len(wind.messages); got != 1 {
+		t.Errorf("expected 1 rapid wind message, got %d", got)
+	}
+
+	if got := len(all.messages); got != 2 {
+		t.Errorf("expected 2 messages, got %d", got)
+	}
+}
+
+func TestBroker_PublishDropsWhenFull(t *testing.T) {
+	b := newBroker()
+	sub := b.add(1)
+
+	b.publish(&RapidWindEvent{WindSpeed: 1})
+	b.publish(&RapidWindEvent{WindSpeed: 2})
+
+	if sub.Dropped() != 1 {
+		t.Errorf("expected 1 dropped message, got %d", sub.Dropped())
+	}
+
+	msg := <-sub.Messages()
+	if msg.(*RapidWindEvent).WindSpeed != 1 {
+		t.Errorf("expected the oldest message to be kept")
+	}
+}
+
+func TestSubscription_Unsubscribe(t *testing.T) {
+	b := newBroker()
+	sub := b.add(1)
+
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+
+	if _, ok := <-sub.Messages(); ok {
+		t.Errorf("expected messages channel to be closed")
+	}
+
+	// Publishing after unsubscribing must not panic.
+	b.publish(&RapidWindEvent{})
+}
+
+func TestBroker_PublishBlocking(t *testing.T) {
+	b := newBroker()
+	sub := b.addBlocking(1)
+
+	// The second message waits for the subscriber to read the first.
+	b.publish(&RapidWindEvent{WindSpeed: 1})
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		b.publish(&RapidWindEvent{WindSpeed: 2})
+	}()
+
+	for want := 1.0; want <= 2; want++ {
+		if msg := <-sub.Messages(); msg.(*RapidWindEvent).WindSpeed != want {
+			t.Errorf("expected wind speed %v, got %+v", want, msg)
+		}
+	}
+	<-published
+
+	if sub.Dropped() != 0 {
+		t.Errorf("expected no dropped messages, got %d", sub.Dropped())
+	}
+
+	// Unsubscribing releases a blocked delivery.
+	b.publish(&RapidWindEvent{WindSpeed: 3})
+	blocked := make(chan struct{})
+	go func() {
+		defer close(blocked)
+		b.publish(&RapidWindEvent{WindSpeed: 4})
+	}()
+
+	sub.Unsubscribe()
+
+	select {
+	case <-blocked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("unsubscribing did not release the blocked delivery")
+	}
+}
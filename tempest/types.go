@@ -14,23 +14,10 @@ const (
 	MessageTypeHubStatus       = "hub_status"
 )
 
-// validTypes is a map of valid Tempest message types.
-// We use a map here to make it easy and fast to check
-// if a message type is valid or not.
-var validTypes = map[Type]struct{}{
-	MessageTypeRainStartEvent:  {},
-	MessageTypeLightningStrike: {},
-	MessageTypeRapidWind:       {},
-	MessageTypeObservation:     {},
-	MessageTypeAirObservation:  {},
-	MessageTypeSkyObservation:  {},
-	MessageTypeDeviceStatus:    {},
-	MessageTypeHubStatus:       {},
-}
-
 // Valid returns true if the message type is a valid
-// Tempest message type.
+// Tempest message type. A message type is valid if a
+// decoder is registered for the type.
 func (t Type) Valid() bool {
-	_, ok := validTypes[t]
+	_, ok := lookupDecoder(t)
 	return ok
 }
@@ -12,7 +12,7 @@ func FuzzType_Valid(f *testing.F) {
 	f.Add("MessageTypeDeviceStatus")
 	f.Add("MessageTypeHubStatus")
 	f.Fuzz(func(t *testing.T, s string) {
-		if _, ok := validTypes[Type(s)]; ok {
+		if _, ok := lookupDecoder(Type(s)); ok {
 			if !Type(s).Valid() {
 				t.Fatalf("expected %s to be valid", s)
 			}